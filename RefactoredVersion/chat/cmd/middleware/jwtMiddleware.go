@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"github.com/MicahParks/keyfunc"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/viper"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	jwks     *keyfunc.JWKS
+	issuer   string
+	clientId string
+)
+
+func InitJWKS(v *viper.Viper) {
+	jwtUrl := v.GetString("aws.cognito.token-signing-key-url")
+	issuer = v.GetString("aws.cognito.jwt-issuer-url")
+	clientId = v.GetString("aws.cognito.client-id")
+
+	var err error
+	jwks, err = keyfunc.Get(jwtUrl, keyfunc.Options{
+		RefreshInterval: time.Hour,
+		RefreshErrorHandler: func(err error) {
+			log.Printf("Error refreshing JWKS: %v", err.Error())
+		},
+		RefreshUnknownKID: true,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// JwtMiddleware validates the Cognito access token and stores the claims,
+// the caller's user id (the "sub" claim) and Cognito groups in the request
+// locals. Browsers cannot set headers on a WebSocket upgrade, so the token
+// may also be passed as the "token" query parameter.
+func JwtMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenString := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if tokenString == "" {
+			tokenString = c.Query("token")
+		}
+		if tokenString == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "Authorization header is empty",
+			})
+		}
+		token, err := jwt.Parse(tokenString, jwks.Keyfunc)
+		if err != nil || !token.Valid {
+			return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "Token is invalid",
+			})
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "Token is invalid",
+			})
+		}
+		if claims["iss"] != issuer {
+			return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "Token is invalid. Issued by unknown issuer",
+			})
+		}
+		if claims["client_id"] != clientId {
+			return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "Token is invalid. Audience is invalid",
+			})
+		}
+		sub, ok := claims["sub"].(string)
+		if !ok || sub == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "Token has no subject",
+			})
+		}
+		var groups []string
+		if raw, ok := claims["cognito:groups"].([]interface{}); ok {
+			for _, group := range raw {
+				if name, ok := group.(string); ok {
+					groups = append(groups, name)
+				}
+			}
+		}
+		c.Locals("claims", claims)
+		c.Locals("userId", sub)
+		c.Locals("groups", groups)
+
+		return c.Next()
+	}
+}
+
+// GroupMiddleware lets only callers in the given Cognito group through. It
+// runs after JwtMiddleware, which stores the groups.
+func GroupMiddleware(group string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		groups, _ := c.Locals("groups").([]string)
+		if group == "" || !slices.Contains(groups, group) {
+			return c.Status(fiber.StatusForbidden).JSON(response.Response{
+				Message: "caller is not allowed to see this",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -3,13 +3,20 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/cmd/middleware"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/attachment"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/chat"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/moderation"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/notification"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/placeOrder"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/review"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
 	"time"
 
 	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/ws"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/expvar"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"go.uber.org/fx"
@@ -25,6 +32,10 @@ type AppState struct {
 	wsHandler    *ws.WSHandler
 	orderHandler *placeOrder.OrderHandler
 	chatHandler  *chat.ChatRestHanlder
+	attHandler   *attachment.AttachmentHandler
+	modHandler   *moderation.ModerationHandler
+	revHandler   *review.ReviewHandler
+	notHandler   *notification.NotificationHandler
 }
 
 func NewAppState(
@@ -34,25 +45,74 @@ func NewAppState(
 	wsH *ws.WSHandler,
 	orderH *placeOrder.OrderHandler,
 	chatH *chat.ChatRestHanlder,
+	attH *attachment.AttachmentHandler,
+	modH *moderation.ModerationHandler,
+	revH *review.ReviewHandler,
+	notH *notification.NotificationHandler,
 ) *AppState {
 	return &AppState{log: log, app: app, v: v, wsHandler: wsH,
 		orderHandler: orderH,
-		chatHandler:  chatH}
+		chatHandler:  chatH,
+		attHandler:   attH,
+		modHandler:   modH,
+		revHandler:   revH,
+		notHandler:   notH}
 }
 
 func (a *AppState) routeSetUp() {
-	a.app.Get("/ws/chat", func(c *fiber.Ctx) error {
+	a.app.Get("/ws/chat", middleware.JwtMiddleware(), func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
 	}, websocket.New(a.wsHandler.ChatHandle))
 	orderRest := a.app.Group("/orders")
-	orderRest.Post("/orders", a.orderHandler.PlaceHandler)
+	orderRest.Post("/orders", middleware.JwtMiddleware(), a.orderHandler.PlaceHandler)
 	orderRest.Get("/:userId", a.orderHandler.GetAllOrdersByUserId)
-	orderRest.Get("/:orderId/chat/", a.chatHandler.GetChatRoomByOrderId)
-	a.app.Get("/:userId/chat/", a.chatHandler.GetAllChatRoomByUserId)
-	a.app.Get("/sse/seller/:sellerId", a.orderHandler.NotificationHandler)
+	orderRest.Post("/:orderId/accept", middleware.JwtMiddleware(), a.orderHandler.Transition(model.OrderAccepted))
+	orderRest.Post("/:orderId/start", middleware.JwtMiddleware(), a.orderHandler.Transition(model.OrderInProgress))
+	orderRest.Post("/:orderId/cancel", middleware.JwtMiddleware(), a.orderHandler.Transition(model.OrderCancelled))
+	orderRest.Post("/:orderId/disputes", middleware.JwtMiddleware(), a.orderHandler.OpenDispute)
+	orderRest.Get("/:orderId/disputes", middleware.JwtMiddleware(), a.orderHandler.GetOrderDisputes)
+	orderRest.Post("/:orderId/deliveries", middleware.JwtMiddleware(), a.orderHandler.Deliver)
+	orderRest.Get("/:orderId/deliveries", middleware.JwtMiddleware(), a.orderHandler.GetDeliveries)
+	orderRest.Post("/:orderId/deliveries/:deliveryId/accept", middleware.JwtMiddleware(), a.orderHandler.AcceptDelivery)
+	orderRest.Post("/:orderId/deliveries/:deliveryId/revision", middleware.JwtMiddleware(), a.orderHandler.RequestRevision)
+	orderRest.Get("/:orderId/timeline", middleware.JwtMiddleware(), a.orderHandler.GetTimeline)
+	orderRest.Get("/:orderId/payment", middleware.JwtMiddleware(), a.orderHandler.GetPayment)
+	orderRest.Post("/:orderId/review", middleware.JwtMiddleware(), a.revHandler.SubmitReview)
+	orderRest.Get("/:orderId/chat/", middleware.JwtMiddleware(), a.chatHandler.GetChatRoomByOrderId)
+	orderRest.Get("/:orderId/chat/export", middleware.JwtMiddleware(), a.chatHandler.ExportTranscript)
+	orderRest.Post("/transcripts/verify", middleware.JwtMiddleware(), a.chatHandler.VerifyTranscript)
+	chatRest := a.app.Group("/chat", middleware.JwtMiddleware())
+	chatRest.Get("/rooms/:roomId/messages", a.chatHandler.GetRoomMessages)
+	chatRest.Get("/search", a.chatHandler.SearchMessages)
+	chatRest.Get("/rooms/:roomId/members", a.chatHandler.GetRoomMembers)
+	chatRest.Post("/rooms/:roomId/members", a.chatHandler.AddRoomMember)
+	chatRest.Delete("/rooms/:roomId/members/:userId", a.chatHandler.RemoveRoomMember)
+	chatRest.Post("/rooms/:roomId/attachments", a.attHandler.Upload)
+	chatRest.Post("/rooms/:roomId/attachments/presign", a.attHandler.PresignUpload)
+	modRest := a.app.Group("/moderation", middleware.JwtMiddleware())
+	modRest.Get("/flags", a.modHandler.GetFlags)
+	modRest.Post("/flags/:id/review", a.modHandler.ReviewFlag)
+	a.app.Post("/reviews/:reviewId/reply", middleware.JwtMiddleware(), a.revHandler.ReplyToReview)
+	a.app.Get("/gigs/:gigId/reviews", a.revHandler.GetGigReviews)
+	a.app.Get("/sellers/:sellerId/reviews", a.revHandler.GetSellerReviews)
+	disputeRest := a.app.Group("/disputes", middleware.JwtMiddleware())
+	disputeRest.Get("/", a.orderHandler.GetDisputes)
+	disputeRest.Get("/:disputeId", a.orderHandler.GetDispute)
+	disputeRest.Post("/:disputeId/evidence", a.orderHandler.AddDisputeEvidence)
+	disputeRest.Post("/:disputeId/assign", a.orderHandler.AssignDispute)
+	disputeRest.Post("/:disputeId/ruling", a.orderHandler.RuleDispute)
+	a.app.Get("/attachments/files/*", a.attHandler.ServeLocalFile)
+	a.app.Get("/users/:id/presence", middleware.JwtMiddleware(), a.chatHandler.GetPresence)
+	a.app.Get("/:userId/chat/", middleware.JwtMiddleware(), a.chatHandler.GetAllChatRoomByUserId)
+	notificationRest := a.app.Group("/notifications", middleware.JwtMiddleware())
+	notificationRest.Get("/", a.notHandler.GetNotifications)
+	notificationRest.Post("/read-all", a.notHandler.MarkAllRead)
+	notificationRest.Post("/:id/read", a.notHandler.MarkRead)
+	a.app.Get("/events", middleware.JwtMiddleware(), a.notHandler.Events)
+	a.app.Get("/debug/vars", middleware.JwtMiddleware(), middleware.GroupMiddleware(a.v.GetString("chat.moderator-group")), expvar.New())
 }
 
 func (a *AppState) Start() error {
@@ -0,0 +1,144 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type AttachmentHandler struct {
+	log     *logrus.Logger
+	srv     *service.AttachmentService
+	context context.Context
+}
+
+func NewAttachmentHandler(log *logrus.Logger, srv *service.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{
+		log:     log,
+		srv:     srv,
+		context: context.Background(),
+	}
+}
+
+// PresignUpload issues a presigned PUT URL for a file of the given type and size.
+func (h *AttachmentHandler) PresignUpload(ctx *fiber.Ctx) error {
+	userId, roomId, ok := h.callerAndRoom(ctx)
+	if !ok {
+		return nil
+	}
+	var req model.AttachmentUploadRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid upload request",
+		})
+	}
+
+	upload, err := h.srv.CreateUpload(h.context, userId, roomId, &req)
+	if errors.Is(err, repository.ErrPresignUnsupported) {
+		return ctx.Status(fiber.StatusNotImplemented).JSON(response.Response{
+			Message: "presigned uploads are not available, use the multipart upload",
+		})
+	}
+	if err != nil {
+		return h.fail(ctx, err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(response.Response{
+		Message: "Upload url created",
+		Data:    upload,
+	})
+}
+
+// Upload accepts the file as the "file" part of a multipart form.
+func (h *AttachmentHandler) Upload(ctx *fiber.Ctx) error {
+	userId, roomId, ok := h.callerAndRoom(ctx)
+	if !ok {
+		return nil
+	}
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "multipart field \"file\" is required",
+		})
+	}
+	file, err := header.Open()
+	if err != nil {
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: "could not read uploaded file",
+		})
+	}
+	defer file.Close()
+
+	attachment, err := h.srv.Upload(h.context, userId, roomId, header.Filename, header.Size, file)
+	if err != nil {
+		return h.fail(ctx, err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(response.Response{
+		Message: "Attachment uploaded",
+		Data:    attachment,
+	})
+}
+
+// ServeLocalFile serves files of the local object store behind signed URLs.
+func (h *AttachmentHandler) ServeLocalFile(ctx *fiber.Ctx) error {
+	file, err := h.srv.OpenLocal(ctx.Params("*"), ctx.Query("expires"), ctx.Query("signature"))
+	if errors.Is(err, repository.ErrInvalidSignedObject) {
+		return ctx.SendStatus(fiber.StatusForbidden)
+	}
+	if errors.Is(err, repository.ErrObjectNotFound) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		h.log.Error(err.Error())
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	// the stream is closed by fasthttp once the body has been written
+	ctx.Set(fiber.HeaderCacheControl, "private, no-store")
+	return ctx.SendStream(file)
+}
+
+// callerAndRoom writes the error response itself and reports false when the
+// request has no valid room id or caller.
+func (h *AttachmentHandler) callerAndRoom(ctx *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	roomId, err := uuid.Parse(ctx.Params("roomId"))
+	if err != nil {
+		_ = ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "room id in param is not a valid uuid",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		_ = ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userId, roomId, true
+}
+
+func (h *AttachmentHandler) fail(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrChatRoomNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrNotParticipant):
+		status = fiber.StatusForbidden
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrAttachmentType):
+		status = fiber.StatusUnsupportedMediaType
+	default:
+		h.log.Error(err.Error())
+	}
+	return ctx.Status(status).JSON(response.Response{
+		Message: err.Error(),
+	})
+}
@@ -2,51 +2,170 @@ package chat
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/ws"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"slices"
 )
 
 type ChatRestHanlder struct {
-	log     *logrus.Logger
-	srv     *service.ChatService
-	context context.Context
+	log       *logrus.Logger
+	srv       *service.ChatService
+	attachSrv *service.AttachmentService
+	presence  *service.PresenceService
+	export    *service.TranscriptService
+	ws        *ws.WSHandler
+	moderator string
+	context   context.Context
 }
 
 func NewChatRestHanlder(log *logrus.Logger,
 	srv *service.ChatService,
+	attachSrv *service.AttachmentService,
+	presence *service.PresenceService,
+	export *service.TranscriptService,
+	wsHandler *ws.WSHandler,
+	v *viper.Viper,
 ) *ChatRestHanlder {
+	v.SetDefault("chat.moderator-group", "moderators")
 	return &ChatRestHanlder{
-		log:     log,
-		srv:     srv,
-		context: context.Background(),
+		log:       log,
+		srv:       srv,
+		attachSrv: attachSrv,
+		presence:  presence,
+		export:    export,
+		ws:        wsHandler,
+		moderator: v.GetString("chat.moderator-group"),
+		context:   context.Background(),
 	}
 }
 
 func (h ChatRestHanlder) GetChatRoomByOrderId(ctx *fiber.Ctx) error {
-	orderIdRaw := ctx.Params("orderId")
-	if orderIdRaw == "" {
+	orderId, err := uuid.Parse(ctx.Params("orderId"))
+	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
-			Message: "order id  in param can not be empty",
+			Message: "order id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
 		})
 	}
-	orderId := uuid.MustParse(orderIdRaw)
-	h.srv.GetChatRoomByOrderId(h.context, orderId)
+
+	chatRoom, err := h.srv.GetChatRoomByOrderId(h.context, userId, orderId)
+	if err != nil {
+		return h.memberError(ctx, err)
+	}
 	return ctx.Status(fiber.StatusOK).JSON(response.Response{
 		Message: "Get room by order id is successful",
+		Data:    chatRoom,
+	})
+}
+
+// ExportTranscript returns the signed conversation of an order as JSON or,
+// with format=pdf, as a PDF document.
+func (h ChatRestHanlder) ExportTranscript(ctx *fiber.Ctx) error {
+	orderId, err := uuid.Parse(ctx.Params("orderId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "order id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	format := ctx.Query("format", "json")
+	if format != "json" && format != "pdf" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "format must be json or pdf",
+		})
+	}
+
+	signed, err := h.export.Export(h.context, userId, orderId)
+	if errors.Is(err, service.ErrOrderNotFound) {
+		return ctx.Status(fiber.StatusNotFound).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	if err != nil {
+		return h.memberError(ctx, err)
+	}
+
+	ctx.Set("X-Transcript-Signature", signed.Algorithm+" "+signed.Signature)
+	fileName := fmt.Sprintf("order-%s-transcript", signed.Transcript.Order.OrderNumber)
+	if format == "json" {
+		ctx.Attachment(fileName + ".json")
+		return ctx.Status(fiber.StatusOK).JSON(signed)
+	}
+
+	document, err := service.RenderTranscriptPDF(signed)
+	if err != nil {
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	ctx.Attachment(fileName + ".pdf")
+	ctx.Set(fiber.HeaderContentType, "application/pdf")
+	return ctx.Status(fiber.StatusOK).Send(document)
+}
+
+// VerifyTranscript checks a JSON export against its signature.
+func (h ChatRestHanlder) VerifyTranscript(ctx *fiber.Ctx) error {
+	var signed model.SignedTranscript
+	if err := ctx.BodyParser(&signed); err != nil || signed.Transcript == nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "body must be a transcript export",
+		})
+	}
+	switch err := h.export.Verify(&signed); {
+	case errors.Is(err, service.ErrInvalidTranscriptSign):
+		return ctx.Status(fiber.StatusUnprocessableEntity).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case err != nil:
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Transcript signature is valid",
 	})
 }
 
+// GetAllChatRoomByUserId lists the caller's rooms with their unread counts.
+// The user id in the path has to be the caller's own.
 func (h ChatRestHanlder) GetAllChatRoomByUserId(ctx *fiber.Ctx) error {
-	userIdRaw := ctx.Params("userId")
-	if userIdRaw == "" {
+	userId, err := uuid.Parse(ctx.Params("userId"))
+	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
-			Message: "user id  in param can not be empty",
+			Message: "user id in param is not a valid uuid",
+		})
+	}
+	callerId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	if userId != callerId {
+		return ctx.Status(fiber.StatusForbidden).JSON(response.Response{
+			Message: "chat rooms of other users can not be listed",
 		})
 	}
-	userId := uuid.MustParse(userIdRaw)
 	chatRooms, err := h.srv.GetAllChatRoomByUserId(h.context, userId)
 	if err != nil {
 		h.log.Error(err.Error())
@@ -60,3 +179,209 @@ func (h ChatRestHanlder) GetAllChatRoomByUserId(ctx *fiber.Ctx) error {
 	})
 
 }
+
+func (h ChatRestHanlder) GetRoomMessages(ctx *fiber.Ctx) error {
+	roomId, err := uuid.Parse(ctx.Params("roomId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "room id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	before := model.MessageCursor{Timestamp: int64(ctx.QueryInt("before", 0)), ID: ctx.Query("before_id")}
+	limit := ctx.QueryInt("limit", 0)
+
+	page, err := h.srv.GetRoomMessages(h.context, userId, roomId, before, limit)
+	switch {
+	case errors.Is(err, service.ErrChatRoomNotFound):
+		return ctx.Status(fiber.StatusNotFound).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrNotParticipant):
+		return ctx.Status(fiber.StatusForbidden).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case err != nil:
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	if err := h.attachSrv.FillDownloadURLs(h.context, page.Messages); err != nil {
+		h.log.Error(err.Error())
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Get room messages is successful",
+		Data:    page,
+	})
+}
+
+func (h ChatRestHanlder) SearchMessages(ctx *fiber.Ctx) error {
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+
+	hits, err := h.srv.Search(h.context, userId, ctx.Query("q"), ctx.QueryInt("limit", 0))
+	switch {
+	case errors.Is(err, service.ErrEmptySearchQuery):
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case err != nil:
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Search messages is successful",
+		Data:    hits,
+	})
+}
+
+func (h ChatRestHanlder) GetRoomMembers(ctx *fiber.Ctx) error {
+	roomId, err := uuid.Parse(ctx.Params("roomId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "room id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+
+	chatRoom, err := h.srv.GetChatRoomForParticipant(h.context, userId, roomId)
+	if err != nil {
+		return h.memberError(ctx, err)
+	}
+	members := []model.RoomMember{
+		{ChatRoomID: roomId, UserID: chatRoom.ParticipantOne, Role: model.RoleBuyer, JoinedAt: chatRoom.CreatedAt},
+		{ChatRoomID: roomId, UserID: chatRoom.ParticipantTwo, Role: model.RoleSeller, JoinedAt: chatRoom.CreatedAt},
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Get room members is successful",
+		Data:    append(members, chatRoom.Members...),
+	})
+}
+
+func (h ChatRestHanlder) AddRoomMember(ctx *fiber.Ctx) error {
+	roomId, err := uuid.Parse(ctx.Params("roomId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "room id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	var req model.RoomMemberRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid member request body",
+		})
+	}
+
+	member, err := h.srv.AddMember(h.context, userId, roomId, h.isModerator(ctx), &req)
+	if err != nil {
+		return h.memberError(ctx, err)
+	}
+	h.ws.MembershipChanged(member, false)
+	return ctx.Status(fiber.StatusCreated).JSON(response.Response{
+		Message: "Add room member is successful",
+		Data:    member,
+	})
+}
+
+func (h ChatRestHanlder) RemoveRoomMember(ctx *fiber.Ctx) error {
+	roomId, err := uuid.Parse(ctx.Params("roomId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "room id in param is not a valid uuid",
+		})
+	}
+	memberId, err := uuid.Parse(ctx.Params("userId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "user id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+
+	member, err := h.srv.RemoveMember(h.context, userId, roomId, memberId, h.isModerator(ctx))
+	if err != nil {
+		return h.memberError(ctx, err)
+	}
+	h.ws.MembershipChanged(member, true)
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Remove room member is successful",
+		Data:    member,
+	})
+}
+
+// isModerator reports whether the caller is in the Cognito group of platform
+// moderators.
+func (h ChatRestHanlder) isModerator(ctx *fiber.Ctx) bool {
+	groups, _ := ctx.Locals("groups").([]string)
+	return slices.Contains(groups, h.moderator)
+}
+
+func (h ChatRestHanlder) memberError(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrChatRoomNotFound), errors.Is(err, service.ErrMemberNotFound):
+		return ctx.Status(fiber.StatusNotFound).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrNotParticipant), errors.Is(err, service.ErrMemberNotAllowed), errors.Is(err, service.ErrRoleChange):
+		return ctx.Status(fiber.StatusForbidden).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrFixedMember):
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: err.Error(),
+		})
+	default:
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+}
+
+func (h ChatRestHanlder) GetPresence(ctx *fiber.Ctx) error {
+	userId, err := uuid.Parse(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "user id in param is not a valid uuid",
+		})
+	}
+	presence, err := h.presence.Get(h.context, userId)
+	if err != nil {
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Get presence is successful",
+		Data:    presence,
+	})
+}
@@ -0,0 +1,122 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"slices"
+)
+
+// ModerationHandler serves the review queue to platform moderators.
+type ModerationHandler struct {
+	log       *logrus.Logger
+	srv       *service.ModerationService
+	moderator string
+	context   context.Context
+}
+
+func NewModerationHandler(log *logrus.Logger, srv *service.ModerationService, v *viper.Viper) *ModerationHandler {
+	v.SetDefault("chat.moderator-group", "moderators")
+	return &ModerationHandler{
+		log:       log,
+		srv:       srv,
+		moderator: v.GetString("chat.moderator-group"),
+		context:   context.Background(),
+	}
+}
+
+// GetFlags lists flagged and blocked messages. status (default pending, or
+// all), room, limit and offset are optional query parameters.
+func (h *ModerationHandler) GetFlags(ctx *fiber.Ctx) error {
+	if !h.isModerator(ctx) {
+		return ctx.Status(fiber.StatusForbidden).JSON(response.Response{
+			Message: "only moderators can review flagged messages",
+		})
+	}
+	status := model.FlagStatus(ctx.Query("status", string(model.FlagPending)))
+	if status == "all" {
+		status = ""
+	}
+	var roomId uuid.UUID
+	if room := ctx.Query("room"); room != "" {
+		var err error
+		if roomId, err = uuid.Parse(room); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "room in query is not a valid uuid",
+			})
+		}
+	}
+
+	flags, err := h.srv.Flags(h.context, status, roomId, ctx.QueryInt("limit"), ctx.QueryInt("offset"))
+	if err != nil {
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving moderation flags is successful",
+		Data:    flags,
+	})
+}
+
+// ReviewFlag closes a pending flag as dismissed or confirmed.
+func (h *ModerationHandler) ReviewFlag(ctx *fiber.Ctx) error {
+	if !h.isModerator(ctx) {
+		return ctx.Status(fiber.StatusForbidden).JSON(response.Response{
+			Message: "only moderators can review flagged messages",
+		})
+	}
+	reviewerId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	flagId, err := uuid.Parse(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "flag id in param is not a valid uuid",
+		})
+	}
+	var req model.FlagReviewRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid review request",
+		})
+	}
+
+	flag, err := h.srv.ResolveFlag(h.context, reviewerId, flagId, &req)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrInvalidFlagStatus):
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrFlagNotFound):
+		return ctx.Status(fiber.StatusNotFound).JSON(response.Response{
+			Message: err.Error(),
+		})
+	default:
+		h.log.Error(err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Message: err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Review moderation flag is successful",
+		Data:    flag,
+	})
+}
+
+func (h *ModerationHandler) isModerator(ctx *fiber.Ctx) bool {
+	groups, _ := ctx.Locals("groups").([]string)
+	return slices.Contains(groups, h.moderator)
+}
@@ -0,0 +1,175 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/ws"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Events streams everything that concerns the caller as server-sent events:
+// their notifications, about their orders and reviews, as "notification"
+// events with the notification id as event id, and the frames of their chat
+// rooms as "chat" events. A client that reconnects with the Last-Event-ID
+// header, or the lastEventId query parameter, first receives the
+// notifications it missed. Both subscriptions end when the client goes away.
+func (h *NotificationHandler) Events(c *fiber.Ctx) error {
+	userId, err := uuid.Parse(fmt.Sprint(c.Locals("userId")))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	var after int64
+	if lastEventId := c.Get("Last-Event-ID", c.Query("lastEventId")); lastEventId != "" {
+		if after, err = strconv.ParseInt(lastEventId, 10, 64); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "Last-Event-ID must be a notification id",
+			})
+		}
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Status(fiber.StatusOK)
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(h.context)
+		defer cancel()
+		stream := &eventStream{w: w}
+		if err := stream.comment("connected"); err != nil {
+			return
+		}
+		h.log.Infof("Event stream of %s opened after %d", userId, after)
+
+		// a new order opens a room the seller is not following yet
+		refresh := make(chan struct{}, 1)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			if err := h.followRooms(ctx, userId, stream, refresh); err != nil && !errors.Is(err, context.Canceled) {
+				h.log.Errorf("Room events of %s stopped: %v", userId, err)
+			}
+		}()
+
+		deliver := func(notification *model.Notification) error {
+			if notification.Type == model.NotificationOrderPlaced {
+				select {
+				case refresh <- struct{}{}:
+				default:
+				}
+			}
+			return stream.event(strconv.FormatInt(notification.ID, 10), "notification", notification)
+		}
+		heartbeat := func() error {
+			return stream.comment("heartbeat")
+		}
+		err := h.srv.Follow(ctx, userId, after, deliver, heartbeat)
+		cancel()
+		wg.Wait()
+		h.log.Infof("Event stream of %s closed: %v", userId, err)
+	}))
+
+	return nil
+}
+
+// followRooms forwards the frames of the user's chat rooms until ctx is done
+// or writing fails. The rooms are looked up again every
+// notifications.room-refresh, on refresh and when the user leaves a room.
+func (h *NotificationHandler) followRooms(ctx context.Context, userId uuid.UUID, stream *eventStream, refresh <-chan struct{}) error {
+	watch := h.broker.Watch(ctx, userId)
+	defer func() {
+		if err := watch.Close(); err != nil {
+			h.log.Errorf("Error closing room watch of %s: %v", userId, err)
+		}
+	}()
+	follow := func() error {
+		rooms, err := h.chatSrv.RoomIDs(ctx, userId)
+		if err != nil {
+			return err
+		}
+		return watch.Follow(ctx, rooms)
+	}
+	if err := follow(); err != nil {
+		return err
+	}
+
+	frames := watch.Frames(ctx)
+	ticker := time.NewTicker(h.roomRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case frame, ok := <-frames:
+			if !ok {
+				return errors.New("room subscription closed")
+			}
+			if err := stream.event("", "chat", frame); err != nil {
+				return err
+			}
+			if frame.Type == ws.FrameMember && frame.Left && frame.Member != nil && frame.Member.UserID == userId {
+				if err := follow(); err != nil {
+					return err
+				}
+			}
+		case <-refresh:
+			if err := follow(); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := follow(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// eventStream writes server-sent events. The notification and room
+// subscriptions of a stream write to it concurrently.
+type eventStream struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// event writes an event of the given name. Events without id leave the
+// client's Last-Event-ID as it is.
+func (s *eventStream) event(id, name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, payload); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *eventStream) comment(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
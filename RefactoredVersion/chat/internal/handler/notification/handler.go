@@ -0,0 +1,137 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/ws"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"time"
+)
+
+// NotificationHandler serves the notification feed of the caller and the
+// SSE stream it is pushed on together with the caller's chat rooms.
+type NotificationHandler struct {
+	log         *logrus.Logger
+	srv         *service.NotificationService
+	chatSrv     *service.ChatService
+	broker      *ws.Broker
+	context     context.Context
+	roomRefresh time.Duration
+}
+
+func NewNotificationHandler(
+	log *logrus.Logger,
+	v *viper.Viper,
+	srv *service.NotificationService,
+	chatSrv *service.ChatService,
+	broker *ws.Broker,
+) *NotificationHandler {
+	v.SetDefault("notifications.room-refresh", 30*time.Second)
+	return &NotificationHandler{
+		log:         log,
+		srv:         srv,
+		chatSrv:     chatSrv,
+		broker:      broker,
+		context:     context.Background(),
+		roomRefresh: v.GetDuration("notifications.room-refresh"),
+	}
+}
+
+// GetNotifications lists the caller's notifications, newest first. before,
+// limit and unread=true are optional query parameters.
+func (h *NotificationHandler) GetNotifications(ctx *fiber.Ctx) error {
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	before, err := strconv.ParseInt(ctx.Query("before", "0"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "before must be a notification id",
+		})
+	}
+
+	page, err := h.srv.List(h.context, userId, ctx.QueryBool("unread"), before, ctx.QueryInt("limit"))
+	if err != nil {
+		return h.notificationError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving notifications is successful",
+		Data:    page,
+	})
+}
+
+// MarkRead marks one of the caller's notifications as read.
+func (h *NotificationHandler) MarkRead(ctx *fiber.Ctx) error {
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "notification id in param is not valid",
+		})
+	}
+
+	notification, err := h.srv.MarkRead(h.context, userId, id)
+	if err != nil {
+		return h.notificationError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Notification is marked as read",
+		Data:    notification,
+	})
+}
+
+// MarkAllRead marks the caller's notifications as read.
+func (h *NotificationHandler) MarkAllRead(ctx *fiber.Ctx) error {
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	var req model.NotificationReadAllRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "invalid read request",
+			})
+		}
+	}
+
+	marked, err := h.srv.MarkAllRead(h.context, userId, req.UpTo)
+	if err != nil {
+		return h.notificationError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: fmt.Sprintf("%d notifications are marked as read", marked),
+		Data:    fiber.Map{"marked": marked},
+	})
+}
+
+func (h *NotificationHandler) notificationError(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrNotificationNotFound):
+		status = fiber.StatusNotFound
+	default:
+		h.log.Error(err.Error())
+	}
+	return ctx.Status(status).JSON(response.Response{
+		Message: err.Error(),
+	})
+}
@@ -0,0 +1,118 @@
+package placeOrder
+
+import (
+	"context"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Deliver hands in the seller's work. Files are uploaded to the order's chat
+// room first and referenced by attachment id.
+func (o *OrderHandler) Deliver(ctx *fiber.Ctx) error {
+	userId, orderId, ok := o.callerAndOrder(ctx)
+	if !ok {
+		return nil
+	}
+	var req model.DeliveryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid delivery request",
+		})
+	}
+
+	delivery, err := o.delivery.Deliver(context.Background(), userId, orderId, &req)
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(response.Response{
+		Message: fmt.Sprintf("Delivery #%d is sent", delivery.Number),
+		Data:    delivery,
+	})
+}
+
+func (o *OrderHandler) GetDeliveries(ctx *fiber.Ctx) error {
+	userId, orderId, ok := o.callerAndOrder(ctx)
+	if !ok {
+		return nil
+	}
+	deliveries, err := o.delivery.Deliveries(context.Background(), userId, orderId, o.isModerator(ctx))
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving deliveries is successful",
+		Data:    deliveries,
+	})
+}
+
+// AcceptDelivery lets the buyer accept the pending delivery, which completes
+// the order.
+func (o *OrderHandler) AcceptDelivery(ctx *fiber.Ctx) error {
+	return o.respondToDelivery(ctx, true)
+}
+
+// RequestRevision lets the buyer send the pending delivery back, while the
+// package has revisions left.
+func (o *OrderHandler) RequestRevision(ctx *fiber.Ctx) error {
+	return o.respondToDelivery(ctx, false)
+}
+
+func (o *OrderHandler) respondToDelivery(ctx *fiber.Ctx, accept bool) error {
+	userId, orderId, ok := o.callerAndOrder(ctx)
+	if !ok {
+		return nil
+	}
+	deliveryId, err := uuid.Parse(ctx.Params("deliveryId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "delivery id in param is not a valid uuid",
+		})
+	}
+	var req model.DeliveryResponseRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "invalid delivery response",
+			})
+		}
+	}
+
+	var delivery *model.OrderDelivery
+	message := "Delivery is accepted"
+	if accept {
+		delivery, err = o.delivery.Accept(context.Background(), userId, orderId, deliveryId, &req)
+	} else {
+		delivery, err = o.delivery.RequestRevision(context.Background(), userId, orderId, deliveryId, &req)
+		message = "Revision is requested"
+	}
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: message,
+		Data:    delivery,
+	})
+}
+
+// callerAndOrder writes the error response itself and reports false when the
+// request has no valid order id or caller.
+func (o *OrderHandler) callerAndOrder(ctx *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	orderId, err := uuid.Parse(ctx.Params("orderId"))
+	if err != nil {
+		_ = ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "order id in param is not a valid uuid",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		_ = ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userId, orderId, true
+}
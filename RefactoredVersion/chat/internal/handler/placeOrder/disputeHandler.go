@@ -0,0 +1,199 @@
+package placeOrder
+
+import (
+	"context"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// OpenDispute lets the buyer or seller escalate an order to a moderator,
+// with evidence from the order's chat.
+func (o *OrderHandler) OpenDispute(ctx *fiber.Ctx) error {
+	userId, orderId, ok := o.callerAndOrder(ctx)
+	if !ok {
+		return nil
+	}
+	var req model.DisputeOpenRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid dispute request",
+		})
+	}
+
+	dispute, err := o.dispute.Open(context.Background(), userId, orderId, &req)
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(response.Response{
+		Message: "Dispute is opened",
+		Data:    dispute,
+	})
+}
+
+func (o *OrderHandler) GetOrderDisputes(ctx *fiber.Ctx) error {
+	userId, orderId, ok := o.callerAndOrder(ctx)
+	if !ok {
+		return nil
+	}
+	disputes, err := o.dispute.Disputes(context.Background(), userId, orderId, o.isModerator(ctx))
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving disputes is successful",
+		Data:    disputes,
+	})
+}
+
+// GetDisputes is the moderators' queue. status (default open, or all),
+// assigned (me or a moderator id), limit and offset are optional query
+// parameters.
+func (o *OrderHandler) GetDisputes(ctx *fiber.Ctx) error {
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	status := model.DisputeStatus(ctx.Query("status", string(model.DisputeOpen)))
+	if status == "all" {
+		status = ""
+	}
+	var moderatorId *uuid.UUID
+	switch assigned := ctx.Query("assigned"); assigned {
+	case "":
+	case "me":
+		moderatorId = &userId
+	default:
+		id, err := uuid.Parse(assigned)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "assigned in query must be me or a valid uuid",
+			})
+		}
+		moderatorId = &id
+	}
+
+	disputes, err := o.dispute.Queue(context.Background(), o.isModerator(ctx), status, moderatorId, ctx.QueryInt("limit"), ctx.QueryInt("offset"))
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving disputes is successful",
+		Data:    disputes,
+	})
+}
+
+func (o *OrderHandler) GetDispute(ctx *fiber.Ctx) error {
+	userId, disputeId, ok := o.callerAndDispute(ctx)
+	if !ok {
+		return nil
+	}
+	dispute, err := o.dispute.Dispute(context.Background(), userId, disputeId, o.isModerator(ctx))
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving dispute is successful",
+		Data:    dispute,
+	})
+}
+
+// AddDisputeEvidence submits further messages of the order's chat.
+func (o *OrderHandler) AddDisputeEvidence(ctx *fiber.Ctx) error {
+	userId, disputeId, ok := o.callerAndDispute(ctx)
+	if !ok {
+		return nil
+	}
+	var req model.DisputeEvidenceRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid evidence request",
+		})
+	}
+
+	dispute, err := o.dispute.AddEvidence(context.Background(), userId, disputeId, &req)
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Evidence is submitted",
+		Data:    dispute,
+	})
+}
+
+// AssignDispute hands a dispute to a moderator, the caller when the body
+// names no one.
+func (o *OrderHandler) AssignDispute(ctx *fiber.Ctx) error {
+	userId, disputeId, ok := o.callerAndDispute(ctx)
+	if !ok {
+		return nil
+	}
+	var req model.DisputeAssignRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "invalid assign request",
+			})
+		}
+	}
+
+	dispute, err := o.dispute.Assign(context.Background(), userId, disputeId, o.isModerator(ctx), &req)
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Dispute is assigned",
+		Data:    dispute,
+	})
+}
+
+// RuleDispute closes a dispute with the assigned moderator's ruling.
+func (o *OrderHandler) RuleDispute(ctx *fiber.Ctx) error {
+	userId, disputeId, ok := o.callerAndDispute(ctx)
+	if !ok {
+		return nil
+	}
+	var req model.DisputeRulingRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid ruling request",
+		})
+	}
+
+	dispute, err := o.dispute.Rule(context.Background(), userId, disputeId, o.isModerator(ctx), &req)
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	message := fmt.Sprintf("Dispute is ruled %s", dispute.Ruling)
+	if dispute.SettlementError != "" {
+		message += "; its payment is not settled yet and will be retried"
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: message,
+		Data:    dispute,
+	})
+}
+
+// callerAndDispute writes the error response itself and reports false when
+// the request has no valid dispute id or caller.
+func (o *OrderHandler) callerAndDispute(ctx *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	disputeId, err := uuid.Parse(ctx.Params("disputeId"))
+	if err != nil {
+		_ = ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "dispute id in param is not a valid uuid",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		_ = ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userId, disputeId, true
+}
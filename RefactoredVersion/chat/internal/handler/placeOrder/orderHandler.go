@@ -1,137 +1,110 @@
 package placeOrder
 
 import (
-	"bufio"
 	"context"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
-	"github.com/valyala/fasthttp"
+	"github.com/spf13/viper"
 	"go.uber.org/fx"
 	"gorm.io/gorm"
+	"slices"
 )
 
 var OrdHandlerModule = fx.Module("order_handler_module", fx.Provide(
 	NewOrderHandler))
 
 type OrderHandler struct {
-	log    *logrus.Logger
-	srv    *service.OrderService
-	redis  *redis.Client
-	gormDB *gorm.DB
+	log       *logrus.Logger
+	srv       *service.OrderService
+	delivery  *service.DeliveryService
+	dispute   *service.DisputeService
+	gormDB    *gorm.DB
+	moderator string
 }
 
 func NewOrderHandler(log *logrus.Logger,
-	redisClient *redis.Client,
 	srv *service.OrderService,
+	delivery *service.DeliveryService,
+	dispute *service.DisputeService,
 	db *gorm.DB,
+	v *viper.Viper,
 ) *OrderHandler {
+	v.SetDefault("chat.moderator-group", "moderators")
 	return &OrderHandler{log: log,
-		srv:    srv,
-		redis:  redisClient,
-		gormDB: db,
+		srv:       srv,
+		delivery:  delivery,
+		dispute:   dispute,
+		gormDB:    db,
+		moderator: v.GetString("chat.moderator-group"),
 	}
 }
 
+// PlaceHandler places an order for the caller, who is the buyer. Requests
+// with an Idempotency-Key header can be retried safely: a replay returns the
+// order and chat room of the first request with 200 and the
+// Idempotent-Replayed header.
 func (o *OrderHandler) PlaceHandler(c *fiber.Ctx) error {
+	buyerId, err := uuid.Parse(fmt.Sprint(c.Locals("userId")))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
 	var req model.OrderPlaceRequest
 	if err := c.BodyParser(&req); err != nil {
 		o.log.Error("Error parsing request:", err.Error())
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
-
-	OrderId, err := o.srv.FindOrCreate(&req)
-	if err != nil {
-		o.log.Error("Error in FindOrCreate:", err.Error())
-		return c.SendStatus(fiber.StatusInternalServerError)
-	}
-	masterKey := o.MasterKey(
-		req.SellerId,
-		req.BuyerId,
-		req.ServiceId,
-		OrderId.OrderNumber,
-	)
-
-	chatRoom := model.ChatRoom{
-		ParticipantOne: req.BuyerId,
-		ParticipantTwo: req.SellerId,
-		ServiceId:      req.ServiceId,
-		MasterKey:      masterKey,
-		OrderId:        OrderId.ID,
-	}
-
-	if err := o.gormDB.FirstOrCreate(&chatRoom, "master_key = ?", masterKey).Error; err != nil {
-		o.log.Error("Error creating chat room:", err.Error())
-		return c.SendStatus(fiber.StatusInternalServerError)
+	if req.BuyerId != uuid.Nil && req.BuyerId != buyerId {
+		return c.Status(fiber.StatusForbidden).JSON(response.Response{
+			Message: "orders can only be placed for the caller",
+		})
 	}
+	req.BuyerId = buyerId
 
-	publish := fmt.Sprintf("New Order for %s , Status (%s), Buyer (%s)", OrderId.OrderNumber, "PENDING", req.BuyerId)
-	o.log.Infof("Publishing to Redis channel '%s': %s", "order_notification:"+req.SellerId.String(), publish)
-
-	if err := o.redis.Publish(context.TODO(), "order_notification:"+req.SellerId.String(), publish).Err(); err != nil {
-		o.log.Error("Failed to publish to Redis:", err.Error())
+	placed, err := o.srv.PlaceOrder(context.Background(), &req, c.Get("Idempotency-Key"))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPackageRequired),
+		errors.Is(err, service.ErrPackageMismatch),
+		errors.Is(err, service.ErrInvalidIdempotencyKey):
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrPackageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrPackageUnavailable),
+		errors.Is(err, service.ErrIdempotencyKeyInUse):
+		return c.Status(fiber.StatusConflict).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrIdempotencyKeyReused):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(response.Response{
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrPaymentDeclined):
+		return c.Status(fiber.StatusPaymentRequired).JSON(response.Response{
+			Message: err.Error(),
+		})
+	default:
+		o.log.Error("Error in PlaceOrder:", err.Error())
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"chat_room": chatRoom.ChatRoomID,
-	})
-}
-func (o *OrderHandler) NotificationHandler(c *fiber.Ctx) error {
-	sellerId := c.Params("sellerId")
-	if sellerId == "" {
-		return c.SendStatus(fiber.StatusBadRequest)
+	if placed.Replayed {
+		c.Set("Idempotent-Replayed", "true")
+		return c.Status(fiber.StatusOK).JSON(placed)
 	}
 
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Status(fiber.StatusOK)
-
-	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
-		channel := "order_notification:" + sellerId
-		pubSub := o.redis.Subscribe(context.Background(), channel)
-		if pubSub == nil {
-			o.log.Error("Redis subscription is nil")
-			return
-		}
-		defer func() {
-			if err := pubSub.Close(); err != nil {
-				o.log.Error("Error closing Redis subscription:", err)
-			}
-		}()
-
-		o.log.Infof("Subscribed to Redis channel: %s", channel)
-
-		for msg := range pubSub.Channel() {
-			o.log.Infof("Received message from Redis channel '%s': %s", channel, msg.Payload)
-
-			if _, err := fmt.Fprintf(w, "data: %s\n\n", msg.Payload); err != nil {
-				o.log.Error("Error writing to stream:", err)
-				return
-			}
-
-			if err := w.Flush(); err != nil {
-				o.log.Error("Error flushing buffer:", err)
-				return
-			}
-		}
-	}))
-
-	return nil
-}
-func (o *OrderHandler) MasterKey(seller, buyer, serviceId uuid.UUID, orderId string) string {
-	hash := sha256.New()
-	hash.Write([]byte(seller.String() + buyer.String() + serviceId.String() + orderId))
-	return fmt.Sprintf("%x", hash.Sum(nil))
+	return c.Status(fiber.StatusCreated).JSON(placed)
 }
-
 func (o *OrderHandler) GetAllOrdersByUserId(ctx *fiber.Ctx) error {
 	userIdRaw := ctx.Params("userId")
 	if userIdRaw == "" {
@@ -157,3 +130,145 @@ func (o *OrderHandler) GetAllOrdersByUserId(ctx *fiber.Ctx) error {
 		Data:    orders,
 	})
 }
+
+// Transition returns the handler of the endpoint that moves an order to the
+// given status. The caller must be the buyer or seller of the order, or a
+// moderator when a dispute is settled.
+func (o *OrderHandler) Transition(to model.OrderStatus) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		orderId, err := uuid.Parse(ctx.Params("orderId"))
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Message: "order id in param is not a valid uuid",
+			})
+		}
+		userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+		if err != nil {
+			return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+				Message: "caller is not identified",
+			})
+		}
+
+		var req model.OrderTransitionRequest
+		if len(ctx.Body()) > 0 {
+			if err := ctx.BodyParser(&req); err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+					Message: "invalid transition request",
+				})
+			}
+		}
+
+		order, err := o.srv.Transition(context.Background(), userId, orderId, to, o.isModerator(ctx), req.Note)
+		if err != nil {
+			return o.orderError(ctx, err)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(response.Response{
+			Message: fmt.Sprintf("Order moved to %s", order.Status),
+			Data:    order,
+		})
+	}
+}
+
+// GetTimeline returns the audit trail of an order to its buyer, its seller
+// and moderators.
+func (o *OrderHandler) GetTimeline(ctx *fiber.Ctx) error {
+	orderId, err := uuid.Parse(ctx.Params("orderId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "order id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+
+	events, err := o.srv.Timeline(context.Background(), userId, orderId, o.isModerator(ctx))
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving order timeline is successful",
+		Data:    events,
+	})
+}
+
+// GetPayment returns the escrow payment of an order with its ledger entries.
+func (o *OrderHandler) GetPayment(ctx *fiber.Ctx) error {
+	orderId, err := uuid.Parse(ctx.Params("orderId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "order id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+
+	payment, err := o.srv.Payment(context.Background(), userId, orderId, o.isModerator(ctx))
+	if err != nil {
+		return o.orderError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving order payment is successful",
+		Data:    payment,
+	})
+}
+
+func (o *OrderHandler) isModerator(ctx *fiber.Ctx) bool {
+	groups, _ := ctx.Locals("groups").([]string)
+	return slices.Contains(groups, o.moderator)
+}
+
+// orderError maps errors of the order workflow to responses.
+func (o *OrderHandler) orderError(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound),
+		errors.Is(err, service.ErrPaymentNotFound),
+		errors.Is(err, service.ErrDisputeNotFound),
+		errors.Is(err, service.ErrMessageNotFound),
+		errors.Is(err, service.ErrChatRoomNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrNotOrderParty),
+		errors.Is(err, service.ErrTransitionNotAllowed),
+		errors.Is(err, service.ErrNotParticipant),
+		errors.Is(err, service.ErrDisputeModeratorOnly),
+		errors.Is(err, service.ErrNotAssignedModerator):
+		status = fiber.StatusForbidden
+	case errors.Is(err, service.ErrInvalidTransition),
+		errors.Is(err, service.ErrUseDeliveryWorkflow),
+		errors.Is(err, service.ErrUseDisputeWorkflow),
+		errors.Is(err, service.ErrDisputeClosed),
+		errors.Is(err, service.ErrDisputeNotAssigned),
+		errors.Is(err, service.ErrModeratorIsParty),
+		errors.Is(err, service.ErrDeliveryNotPending),
+		errors.Is(err, service.ErrNoRevisionsLeft),
+		errors.Is(err, service.ErrDeliveryRoomNotFound):
+		status = fiber.StatusConflict
+	case errors.Is(err, service.ErrEmptyDelivery),
+		errors.Is(err, service.ErrDisputeReason),
+		errors.Is(err, service.ErrTooMuchEvidence),
+		errors.Is(err, service.ErrInvalidRuling),
+		errors.Is(err, service.ErrInvalidRefundAmount),
+		errors.Is(err, service.ErrTooManyAttachments),
+		errors.Is(err, service.ErrAttachmentNotFound),
+		errors.Is(err, service.ErrAttachmentNotReady),
+		errors.Is(err, service.ErrAttachmentType),
+		errors.Is(err, service.ErrAttachmentSize),
+		errors.Is(err, service.ErrAttachmentTooLarge):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, service.ErrMessageBlocked):
+		status = fiber.StatusUnprocessableEntity
+	default:
+		o.log.Error(err.Error())
+	}
+	return ctx.Status(status).JSON(response.Response{
+		Message: err.Error(),
+	})
+}
@@ -0,0 +1,156 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model/response"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ReviewHandler serves order reviews and the public review lists of gigs
+// and sellers.
+type ReviewHandler struct {
+	log     *logrus.Logger
+	srv     *service.ReviewService
+	context context.Context
+}
+
+func NewReviewHandler(log *logrus.Logger, srv *service.ReviewService) *ReviewHandler {
+	return &ReviewHandler{
+		log:     log,
+		srv:     srv,
+		context: context.Background(),
+	}
+}
+
+// SubmitReview lets the buyer review a completed order.
+func (h *ReviewHandler) SubmitReview(ctx *fiber.Ctx) error {
+	orderId, err := uuid.Parse(ctx.Params("orderId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "order id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	var req model.ReviewRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid review request",
+		})
+	}
+
+	review, err := h.srv.Submit(h.context, userId, orderId, &req)
+	if err != nil {
+		return h.reviewError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(response.Response{
+		Message: "Review is submitted",
+		Data:    review,
+	})
+}
+
+// ReplyToReview lets the seller answer a review publicly.
+func (h *ReviewHandler) ReplyToReview(ctx *fiber.Ctx) error {
+	reviewId, err := uuid.Parse(ctx.Params("reviewId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "review id in param is not a valid uuid",
+		})
+	}
+	userId, err := uuid.Parse(fmt.Sprint(ctx.Locals("userId")))
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Message: "caller is not identified",
+		})
+	}
+	var req model.ReviewReplyRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "invalid reply request",
+		})
+	}
+
+	review, err := h.srv.Reply(h.context, userId, reviewId, &req)
+	if err != nil {
+		return h.reviewError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Reply is posted",
+		Data:    review,
+	})
+}
+
+// GetGigReviews lists the public reviews of a gig. limit and offset are
+// optional query parameters.
+func (h *ReviewHandler) GetGigReviews(ctx *fiber.Ctx) error {
+	gigId, err := uuid.Parse(ctx.Params("gigId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "gig id in param is not a valid uuid",
+		})
+	}
+	page, err := h.srv.GigReviews(h.context, gigId, ctx.QueryInt("limit"), ctx.QueryInt("offset"))
+	if err != nil {
+		return h.reviewError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving gig reviews is successful",
+		Data:    page,
+	})
+}
+
+// GetSellerReviews lists the public reviews of all gigs of a seller. limit
+// and offset are optional query parameters.
+func (h *ReviewHandler) GetSellerReviews(ctx *fiber.Ctx) error {
+	sellerId, err := uuid.Parse(ctx.Params("sellerId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Message: "seller id in param is not a valid uuid",
+		})
+	}
+	page, err := h.srv.SellerReviews(h.context, sellerId, ctx.QueryInt("limit"), ctx.QueryInt("offset"))
+	if err != nil {
+		return h.reviewError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(response.Response{
+		Message: "Retrieving seller reviews is successful",
+		Data:    page,
+	})
+}
+
+func (h *ReviewHandler) reviewError(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound),
+		errors.Is(err, service.ErrReviewNotFound),
+		errors.Is(err, service.ErrReviewGigNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrNotOrderParty),
+		errors.Is(err, service.ErrReviewNotBuyer),
+		errors.Is(err, service.ErrReviewNotSeller):
+		status = fiber.StatusForbidden
+	case errors.Is(err, service.ErrOrderNotCompleted),
+		errors.Is(err, service.ErrOrderReviewed),
+		errors.Is(err, service.ErrReviewReplyExists):
+		status = fiber.StatusConflict
+	case errors.Is(err, service.ErrInvalidRating),
+		errors.Is(err, service.ErrReviewTitle),
+		errors.Is(err, service.ErrEmptyReviewReply):
+		status = fiber.StatusBadRequest
+	default:
+		h.log.Error(err.Error())
+	}
+	return ctx.Status(status).JSON(response.Response{
+		Message: err.Error(),
+	})
+}
@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	roomChannelPrefix = "chat_room:"
+	onlineKeyPrefix   = "chat_online:"
+)
+
+// RoomEvent is what travels over the per-room Redis channel. A nil To means
+// the frame is for everyone in the room.
+type RoomEvent struct {
+	To    uuid.UUID `json:"to"`
+	Frame Envelope  `json:"frame"`
+}
+
+// Broker fans chat messages out across every chat-order replica. Each node
+// publishes to chat_room:{roomId} and only subscribes to the rooms that have
+// at least one client connected locally.
+//
+// Every socket is a member of the sorted set chat_online:{roomId}:{userId},
+// scored with the time it expires unless Touch is called again, so the
+// sockets of a node that died stop counting as online after ws.online-ttl.
+type Broker struct {
+	log       *logrus.Logger
+	redis     *redis.Client
+	nodeID    string
+	pubSub    *redis.PubSub
+	mu        sync.Mutex
+	rooms     map[uuid.UUID]int
+	deliver   func(event RoomEvent)
+	onlineTTL time.Duration
+}
+
+func NewBroker(log *logrus.Logger, v *viper.Viper, rdb *redis.Client) *Broker {
+	v.SetDefault("ws.online-ttl", 2*time.Minute)
+	return &Broker{
+		log:       log,
+		redis:     rdb,
+		nodeID:    uuid.NewString(),
+		rooms:     make(map[uuid.UUID]int),
+		onlineTTL: v.GetDuration("ws.online-ttl"),
+	}
+}
+
+func (b *Broker) Start(deliver func(event RoomEvent)) {
+	b.deliver = deliver
+	b.pubSub = b.redis.Subscribe(context.Background())
+	go b.listen()
+	b.log.Infof("chat broker %s started", b.nodeID)
+}
+
+func (b *Broker) Stop() error {
+	if b.pubSub == nil {
+		return nil
+	}
+	return b.pubSub.Close()
+}
+
+func (b *Broker) listen() {
+	for msg := range b.pubSub.Channel() {
+		var event RoomEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			b.log.Warnf("invalid room event on %s: %v", msg.Channel, err)
+			continue
+		}
+		b.deliver(event)
+	}
+}
+
+// Join registers a local socket with the broker, subscribing this node to the
+// room channel when it is the first local client of that room. The
+// subscription changes under mu, so a Leave of the last client can not
+// unsubscribe after a concurrent Join subscribed again.
+func (b *Broker) Join(ctx context.Context, userID, roomID uuid.UUID, connID string) error {
+	b.mu.Lock()
+	if b.rooms[roomID] == 0 {
+		if err := b.pubSub.Subscribe(ctx, b.channel(roomID)); err != nil {
+			b.mu.Unlock()
+			return fmt.Errorf("subscribe room %s: %w", roomID, err)
+		}
+	}
+	b.rooms[roomID]++
+	b.mu.Unlock()
+
+	return b.Touch(ctx, userID, roomID, connID)
+}
+
+// Touch keeps a socket online for another ws.online-ttl and drops the
+// expired sockets of the user in the room.
+func (b *Broker) Touch(ctx context.Context, userID, roomID uuid.UUID, connID string) error {
+	now := time.Now()
+	key := b.onlineKey(userID, roomID)
+
+	pipe := b.redis.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+b.score(now))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(b.onlineTTL).UnixMilli()), Member: connID})
+	pipe.Expire(ctx, key, 2*b.onlineTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Leave undoes Join and drops the room subscription once no local client is
+// left in it.
+func (b *Broker) Leave(ctx context.Context, userID, roomID uuid.UUID, connID string) error {
+	b.mu.Lock()
+	b.rooms[roomID]--
+	if b.rooms[roomID] <= 0 {
+		delete(b.rooms, roomID)
+		if err := b.pubSub.Unsubscribe(ctx, b.channel(roomID)); err != nil {
+			b.log.Errorf("unsubscribe room %s: %v", roomID, err)
+		}
+	}
+	b.mu.Unlock()
+
+	return b.redis.ZRem(ctx, b.onlineKey(userID, roomID), connID).Err()
+}
+
+// IsOnline reports whether the user has a live socket open for the room on
+// any node.
+func (b *Broker) IsOnline(ctx context.Context, userID, roomID uuid.UUID) (bool, error) {
+	n, err := b.redis.ZCount(ctx, b.onlineKey(userID, roomID), b.score(time.Now()), "+inf").Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (b *Broker) Publish(ctx context.Context, roomID, to uuid.UUID, frame Envelope) error {
+	data, err := json.Marshal(RoomEvent{To: to, Frame: frame})
+	if err != nil {
+		return fmt.Errorf("marshal room event: %w", err)
+	}
+	return b.redis.Publish(ctx, b.channel(roomID), data).Err()
+}
+
+func (b *Broker) channel(roomID uuid.UUID) string {
+	return roomChannelPrefix + roomID.String()
+}
+
+func (b *Broker) score(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func (b *Broker) onlineKey(userID, roomID uuid.UUID) string {
+	return fmt.Sprintf("%s%s:%s", onlineKeyPrefix, roomID.String(), userID.String())
+}
@@ -0,0 +1,137 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+var (
+	errClientClosed = errors.New("client is closed")
+	errSlowConsumer = errors.New("client outbound queue is full")
+)
+
+// slowClientEvictions counts sockets closed because they could not keep up
+// with their outbound queue. It is served with the other expvars on /debug/vars.
+var slowClientEvictions = expvar.NewInt("chat_ws_slow_client_evictions")
+
+// Client is one chat socket. Frames for it are queued with Send and written
+// by its write pump, which is the only goroutine writing to Conn once the
+// client is registered.
+type Client struct {
+	ConnID     string
+	UserID     uuid.UUID
+	ChatRoomID uuid.UUID
+	Role       model.RoomRole
+	Conn       *websocket.Conn
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+	// lastTouch is only used by the read loop
+	lastTouch time.Time
+}
+
+func newClient(conn *websocket.Conn, userID, roomID uuid.UUID, queueSize int) *Client {
+	return &Client{
+		ConnID:     uuid.NewString(),
+		UserID:     userID,
+		ChatRoomID: roomID,
+		Conn:       conn,
+		send:       make(chan []byte, queueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// Send queues a frame without blocking. A client whose queue is full is
+// evicted rather than allowed to hold up the sender.
+func (c *Client) Send(frame Envelope) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("marshal frame: %w", err)
+	}
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		if c.close(websocket.CloseTryAgainLater, "client too slow") {
+			slowClientEvictions.Add(1)
+		}
+		return errSlowConsumer
+	}
+}
+
+// close stops the write pump, which sends the close frame and closes the
+// connection. It reports whether this call was the one that closed it.
+func (c *Client) close(code int, text string) bool {
+	closed := false
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+		closed = true
+	})
+	return closed
+}
+
+// writePump drains the outbound queue and pings the peer so dead sockets are
+// noticed by the read deadline. It closes the connection when it returns,
+// which also ends the read loop.
+func (wc *WSHandler) writePump(client *Client) {
+	ticker := time.NewTicker(wc.pingInterval)
+	defer func() {
+		ticker.Stop()
+		if err := client.Conn.Close(); err != nil {
+			wc.log.Debugf("close connection %s: %v", client.ConnID, err)
+		}
+	}()
+
+	for {
+		select {
+		case data := <-client.send:
+			_ = client.Conn.SetWriteDeadline(time.Now().Add(wc.writeTimeout))
+			if err := client.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				wc.log.Errorf("write to client %s: %v", client.UserID, err)
+				client.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			_ = client.Conn.SetWriteDeadline(time.Now().Add(wc.writeTimeout))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				client.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-client.done:
+			if client.closeCode != websocket.CloseAbnormalClosure {
+				msg := websocket.FormatCloseMessage(client.closeCode, client.closeText)
+				_ = client.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wc.writeTimeout))
+			}
+			return
+		}
+	}
+}
+
+// send queues a frame for a registered client.
+func (wc *WSHandler) send(client *Client, frame Envelope) {
+	err := client.Send(frame)
+	switch {
+	case err == nil, errors.Is(err, errClientClosed):
+	case errors.Is(err, errSlowConsumer):
+		wc.log.Warnf("evicted slow client %s from room %s", client.UserID, client.ChatRoomID)
+	default:
+		wc.log.Errorf("send to client %s: %v", client.UserID, err)
+	}
+}
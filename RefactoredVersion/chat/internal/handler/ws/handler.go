@@ -1,66 +1,120 @@
 package ws
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/service"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"go.uber.org/fx"
-	"strings"
 	"sync"
 	"time"
 )
 
-var WsModule = fx.Module("ws_module", fx.Provide(NewWSHandler))
-
-type Client struct {
-	UserID     uuid.UUID
-	ChatRoomID uuid.UUID
-	Conn       *websocket.Conn
-}
+var WsModule = fx.Module("ws_module",
+	fx.Provide(
+		NewBroker,
+		NewWSHandler,
+		NewRoomPoster,
+	),
+	fx.Invoke(RegisterBrokerLifeCycle),
+)
 
+// ConnectionRequest is the first frame on the socket. UserID is optional and,
+// when present, must match the authenticated user.
 type ConnectionRequest struct {
 	UserID     uuid.UUID `json:"userId"`
 	ChatRoomID uuid.UUID `json:"chatRoomId"`
 }
 
-type Message struct {
-	From       uuid.UUID `json:"from"`
-	To         uuid.UUID `json:"to"`
-	ChatRoomID uuid.UUID `json:"chat_room_id"`
-	Body       string    `json:"body"`
-	File       string    `json:"file,omitempty"` // Will be replaced with S3 URL after upload
-}
-
 type WSHandler struct {
-	log      *logrus.Logger
-	Hub      sync.Map
-	dynamodb *dynamodb.Client
-	redis    *redis.Client
-	s3       *s3.Client
-	s3Bucket string
+	log          *logrus.Logger
+	Hub          sync.Map
+	store        repository.MessageStore
+	redis        *redis.Client
+	broker       *Broker
+	chatSrv      *service.ChatService
+	attachSrv    *service.AttachmentService
+	presence     *service.PresenceService
+	unread       *service.UnreadService
+	messages     *service.MessageService
+	index        repository.SearchIndex
+	moderation   *service.ModerationService
+	stop         chan struct{}
+	queueSize    int
+	writeTimeout time.Duration
+	pongTimeout  time.Duration
+	pingInterval time.Duration
+	resendWindow time.Duration
 }
 
-func NewWSHandler(log *logrus.Logger, ddb *dynamodb.Client, rdb *redis.Client, s3Client *s3.Client) *WSHandler {
+func NewWSHandler(
+	log *logrus.Logger,
+	v *viper.Viper,
+	store repository.MessageStore,
+	rdb *redis.Client,
+	broker *Broker,
+	chatSrv *service.ChatService,
+	attachSrv *service.AttachmentService,
+	presence *service.PresenceService,
+	unread *service.UnreadService,
+	messages *service.MessageService,
+	index repository.SearchIndex,
+	moderation *service.ModerationService,
+) *WSHandler {
+	v.SetDefault("ws.send-queue", 256)
+	v.SetDefault("ws.write-timeout", 10*time.Second)
+	v.SetDefault("ws.pong-timeout", 60*time.Second)
+	v.SetDefault("ws.resend-window", time.Hour)
+
+	pongTimeout := v.GetDuration("ws.pong-timeout")
 	return &WSHandler{
-		log:      log,
-		dynamodb: ddb,
-		redis:    rdb,
-		s3:       s3Client,
-		s3Bucket: "wolftagon-swan-htet",
+		log:          log,
+		store:        store,
+		redis:        rdb,
+		broker:       broker,
+		chatSrv:      chatSrv,
+		attachSrv:    attachSrv,
+		presence:     presence,
+		unread:       unread,
+		messages:     messages,
+		index:        index,
+		moderation:   moderation,
+		stop:         make(chan struct{}),
+		queueSize:    v.GetInt("ws.send-queue"),
+		writeTimeout: v.GetDuration("ws.write-timeout"),
+		pongTimeout:  pongTimeout,
+		pingInterval: pongTimeout * 9 / 10,
+		resendWindow: v.GetDuration("ws.resend-window"),
 	}
 }
 
+// NewRoomPoster lets services post into chat rooms through the handler.
+func NewRoomPoster(wc *WSHandler) service.RoomPoster {
+	return wc
+}
+
+func RegisterBrokerLifeCycle(lc fx.Lifecycle, wc *WSHandler) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			wc.broker.Start(wc.deliverLocal)
+			go wc.sweepPresence(wc.stop)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(wc.stop)
+			return wc.broker.Stop()
+		},
+	})
+}
+
 func (wc *WSHandler) ChatHandle(conn *websocket.Conn) {
 	var init ConnectionRequest
 	if _, raw, err := conn.ReadMessage(); err != nil {
@@ -81,36 +135,47 @@ func (wc *WSHandler) ChatHandle(conn *websocket.Conn) {
 		return
 	}
 
-	if init.ChatRoomID == uuid.Nil || init.UserID == uuid.Nil {
-		err := conn.WriteJSON(map[string]string{"error": "invalid connection parameters"})
-		if err != nil {
-			wc.log.Error("write:", err.Error())
-			return
-		}
-		err = conn.Close()
-		if err != nil {
-			wc.log.Error("close connection:", err.Error())
-			return
+	// The user comes from the validated token, never from the init frame.
+	userID, err := uuid.Parse(fmt.Sprint(conn.Locals("userId")))
+	if err != nil {
+		wc.rejectConnection(conn, init.ChatRoomID, "connection is not authenticated")
+		return
+	}
+	if init.ChatRoomID == uuid.Nil || (init.UserID != uuid.Nil && init.UserID != userID) {
+		wc.rejectConnection(conn, init.ChatRoomID, "invalid connection parameters")
+		return
+	}
+	init.UserID = userID
+
+	room, err := wc.chatSrv.GetChatRoomForParticipant(context.Background(), userID, init.ChatRoomID)
+	if err != nil {
+		if !errors.Is(err, service.ErrChatRoomNotFound) && !errors.Is(err, service.ErrNotParticipant) {
+			wc.log.Error("load chat room:", err)
 		}
+		wc.rejectConnection(conn, init.ChatRoomID, "you are not a participant of this chat room")
 		return
 	}
 
 	// Send welcome message
-	welcome := Message{
-		From:       uuid.MustParse("aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"),
+	welcome := Envelope{
+		Version:    ProtocolVersion,
+		Type:       FrameMessage,
+		From:       SystemUserID,
 		To:         init.UserID,
 		ChatRoomID: init.ChatRoomID,
 		Body:       "Welcome to the chat!",
 	}
-	err := conn.WriteJSON(welcome)
-	if err != nil {
+	_ = conn.SetWriteDeadline(time.Now().Add(wc.writeTimeout))
+	if err := conn.WriteJSON(welcome); err != nil {
 		wc.log.Error("write:", err.Error())
 		return
 	}
 
-	// Check and send unread messages
-	if unread, err := wc.getAndClearUnreadMessages(init.ChatRoomID, init.UserID); err == nil {
+	// Send the unread backlog; it stays queued until the client acks it
+	if unread, err := wc.getUnreadMessages(init.ChatRoomID, init.UserID); err == nil {
 		for _, msg := range unread {
+			wc.refreshAttachmentURL(&msg)
+			_ = conn.SetWriteDeadline(time.Now().Add(wc.writeTimeout))
 			err := conn.WriteJSON(msg)
 			if err != nil {
 				wc.log.Error("write:", err.Error())
@@ -121,139 +186,481 @@ func (wc *WSHandler) ChatHandle(conn *websocket.Conn) {
 		wc.log.Error("failed to retrieve unread messages:", err)
 	}
 
+	// From here on only the write pump writes to the connection
+	client := newClient(conn, init.UserID, init.ChatRoomID, wc.queueSize)
+	client.Role, _ = room.RoleOf(init.UserID)
+	pumpDone := make(chan struct{})
+	go func() {
+		defer close(pumpDone)
+		wc.writePump(client)
+	}()
+
 	// Register client
 	key := wc.HubKey(init.UserID, init.ChatRoomID)
-	client := &Client{UserID: init.UserID, ChatRoomID: init.ChatRoomID, Conn: conn}
 	wc.Hub.Store(key, client)
+	if err := wc.broker.Join(context.Background(), init.UserID, init.ChatRoomID, client.ConnID); err != nil {
+		wc.log.Error("broker join:", err)
+	}
+	wc.connectPresence(client)
+	wc.sendPeerPresence(client, room)
 
 	defer func() {
-		wc.Hub.Delete(key)
-		err := conn.Close()
-		if err != nil {
-			wc.log.Error("close connection:", err.Error())
-			return
+		wc.Hub.CompareAndDelete(key, client)
+		if err := wc.broker.Leave(context.Background(), init.UserID, init.ChatRoomID, client.ConnID); err != nil {
+			wc.log.Error("broker leave:", err)
 		}
+		wc.disconnectPresence(client)
+		// The connection is recycled once ChatHandle returns, so the pump
+		// has to be finished with it first.
+		client.close(websocket.CloseNormalClosure, "")
+		<-pumpDone
 	}()
 
+	_ = conn.SetReadDeadline(time.Now().Add(wc.pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		wc.touch(client)
+		return conn.SetReadDeadline(time.Now().Add(wc.pongTimeout))
+	})
+
 	for {
-		var in Message
+		var in Envelope
 		if err := conn.ReadJSON(&in); err != nil {
 			wc.log.Error("read:", err)
 			break
 		}
+		wc.touch(client)
+		in.normalize()
+		if in.Version > ProtocolVersion {
+			wc.send(client, errorFrame(in.ID, init.ChatRoomID, "unsupported protocol version"))
+			continue
+		}
 
-		// Handle file upload
-		if in.File != "" {
-			fileURL, err := wc.uploadFileToS3(in.File, init.UserID)
-			if err != nil {
-				wc.log.Error("file upload failed:", err)
-				err := conn.WriteJSON(map[string]string{"error": "file upload failed"})
-				if err != nil {
-					wc.log.Error("write:", err.Error())
-					return
-				}
+		in.From = init.UserID
+		if in.ChatRoomID == uuid.Nil {
+			in.ChatRoomID = init.ChatRoomID
+		}
+		if in.ChatRoomID != init.ChatRoomID {
+			wc.send(client, errorFrame(in.ID, init.ChatRoomID, "frames must target the connected chat room"))
+			continue
+		}
+		if in.To != uuid.Nil && !room.IsParticipant(in.To) {
+			// the recipient may have joined after this socket connected
+			if fresh, err := wc.chatSrv.GetChatRoomForParticipant(context.Background(), init.UserID, init.ChatRoomID); err == nil {
+				room = fresh
+			}
+			if !room.IsParticipant(in.To) {
+				wc.send(client, errorFrame(in.ID, init.ChatRoomID, "recipient is not a participant of this chat room"))
 				continue
 			}
-			in.File = fileURL
 		}
 
-		// Store in DynamoDB
-		dynamoMsg := &model.Message{
-			ChatRoomId: in.ChatRoomID,
-			To:         in.To,
-			From:       in.From,
-			Body:       in.Body,
-			ImageUrl:   in.File, // Store S3 URL
-			Timestamp:  time.Now().UTC().Unix(),
+		switch in.Type {
+		case FrameMessage:
+			wc.handleMessage(client, &in)
+		case FrameAck:
+			wc.handleBacklogAck(client, &in)
+		case FrameTyping:
+			wc.relay(in)
+		case FrameRead:
+			wc.handleReceipt(client, &in)
+		case FrameEdit, FrameDelete, FrameReaction:
+			wc.handleMessageUpdate(client, &in)
+		case FramePing:
+			wc.send(client, Envelope{
+				Version:    ProtocolVersion,
+				Type:       FramePing,
+				ID:         in.ID,
+				ChatRoomID: in.ChatRoomID,
+				From:       SystemUserID,
+				Timestamp:  time.Now().UTC().UnixMilli(),
+			})
+		default:
+			wc.send(client, errorFrame(in.ID, in.ChatRoomID, fmt.Sprintf("unsupported frame type %q", in.Type)))
 		}
+	}
+}
 
-		if err := wc.putMessage("ChatMessages", dynamoMsg); err != nil {
-			wc.log.Error("dynamodb put:", err)
-			continue
+// handleMessage stores a message frame, acks it to the sender and routes it
+// to the rest of the room.
+func (wc *WSHandler) handleMessage(client *Client, in *Envelope) {
+	if in.ID == "" {
+		wc.send(client, errorFrame("", in.ChatRoomID, "message id is required"))
+		return
+	}
+
+	// Files are uploaded through the attachment endpoints beforehand; the
+	// frame only references them.
+	if in.File != "" {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "inline files are not supported, upload the file and send its attachment_id"))
+		return
+	}
+
+	// Membership is checked per message so that removed members stop
+	// posting and new members receive broadcasts without reconnecting.
+	room, err := wc.chatSrv.GetChatRoomForParticipant(context.Background(), in.From, in.ChatRoomID)
+	if err != nil {
+		if !errors.Is(err, service.ErrNotParticipant) && !errors.Is(err, service.ErrChatRoomNotFound) {
+			wc.log.Error("load chat room:", err)
 		}
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "you are not a participant of this chat room"))
+		return
+	}
+	if role, _ := room.RoleOf(in.From); !role.CanPost() {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "observers can not post in this chat room"))
+		return
+	}
+	if wc.acknowledgeResend(client, in) {
+		return
+	}
 
-		in.From = init.UserID
-		if in.ChatRoomID == uuid.Nil {
-			in.ChatRoomID = init.ChatRoomID
+	stored := &model.Message{
+		ID:         in.ID,
+		ChatRoomId: in.ChatRoomID,
+		To:         in.To,
+		From:       in.From,
+		Body:       in.Body,
+		Timestamp:  time.Now().UTC().UnixMilli(),
+		Status:     model.StatusSent,
+	}
+
+	if in.AttachmentID != nil {
+		attachment, err := wc.attachSrv.Resolve(context.Background(), in.From, in.ChatRoomID, *in.AttachmentID)
+		if err != nil {
+			if !errors.Is(err, service.ErrAttachmentNotFound) &&
+				!errors.Is(err, service.ErrAttachmentNotReady) &&
+				!errors.Is(err, service.ErrAttachmentType) &&
+				!errors.Is(err, service.ErrAttachmentSize) {
+				wc.log.Error("resolve attachment:", err)
+			}
+			wc.send(client, errorFrame(in.ID, in.ChatRoomID, err.Error()))
+			return
+		}
+		downloadURL, err := wc.attachSrv.DownloadURL(context.Background(), attachment)
+		if err != nil {
+			wc.log.Error("attachment url:", err)
+			wc.send(client, errorFrame(in.ID, in.ChatRoomID, "attachment is not available"))
+			return
+		}
+		stored.AttachmentID = &attachment.ID
+		in.File = downloadURL
+	}
+
+	verdict, err := wc.moderation.Review(context.Background(), stored)
+	if errors.Is(err, service.ErrMessageBlocked) {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, fmt.Sprintf("%s (%s)", err.Error(), verdict.Rule)))
+		return
+	}
+	if err != nil {
+		wc.log.Error("moderate message:", err)
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "message could not be stored"))
+		return
+	}
+	stored.Body = verdict.Body
+	in.Body = verdict.Body
+
+	if err := wc.store.Save(context.Background(), stored); err != nil {
+		// a resend of the same frame may have been stored in the meantime
+		if wc.acknowledgeResend(client, in) {
+			return
 		}
+		wc.log.Error("store message:", err)
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "message could not be stored"))
+		return
+	}
+	wc.send(client, ackFrame(stored))
+	wc.indexMessage(stored)
 
-		// Distribute message
-		if in.To == uuid.Nil {
-			wc.broadcastToRoom(in, in.ChatRoomID)
-		} else {
-			wc.sendToUser(in, in.To, in.ChatRoomID)
+	in.Timestamp = stored.Timestamp
+	in.Status = stored.Status
+
+	// Distribute message; room messages are queued for every offline member
+	if in.To != uuid.Nil {
+		wc.sendToUser(*in, in.To, in.ChatRoomID)
+		return
+	}
+	for _, member := range room.MemberIDs() {
+		if member != in.From {
+			wc.sendToUser(*in, member, in.ChatRoomID)
 		}
 	}
 }
 
-func (wc *WSHandler) uploadFileToS3(base64Data string, userID uuid.UUID) (string, error) {
-	parts := strings.SplitN(base64Data, ",", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid base64 data")
+// acknowledgeResend acks a message frame again when a message with its id is
+// already stored, for clients that resend frames whose ack they missed. It
+// reports whether the frame was handled.
+func (wc *WSHandler) acknowledgeResend(client *Client, in *Envelope) bool {
+	since := time.Now().Add(-wc.resendWindow).UnixMilli()
+	stored, err := wc.store.FindByID(context.Background(), in.ChatRoomID, in.ID, since)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return false
 	}
-
-	fileType := strings.TrimSuffix(strings.Split(parts[0], ";")[0], "data:")
-	data, err := base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
-		return "", fmt.Errorf("base64 decode failed: %w", err)
+		wc.log.Error("look up message:", err)
+		return false
+	}
+	if stored.From != in.From {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "message id is already used"))
+		return true
 	}
+	wc.send(client, ackFrame(stored))
+	return true
+}
 
-	fileName := fmt.Sprintf("%s/%d.%s", userID.String(), time.Now().UnixNano(), strings.TrimPrefix(fileType, "image/"))
+// handleReceipt moves a stored message to delivered or read and tells the
+// rest of the room about it.
+func (wc *WSHandler) handleReceipt(client *Client, in *Envelope) {
+	if in.ID == "" || in.Timestamp == 0 {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "receipt needs the message id and timestamp"))
+		return
+	}
+	if in.Status == "" {
+		in.Status = model.StatusRead
+	}
+	if in.Status != model.StatusDelivered && in.Status != model.StatusRead {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, fmt.Sprintf("invalid receipt status %q", in.Status)))
+		return
+	}
 
-	_, err = wc.s3.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      aws.String(wc.s3Bucket),
-		Key:         aws.String(fileName),
-		Body:        bytes.NewReader(data),
-		ContentType: aws.String(fileType),
-	})
-	if err != nil {
-		return "", fmt.Errorf("s3 upload failed: %w", err)
+	if err := wc.store.MarkRead(context.Background(), in.ChatRoomID, in.Timestamp, in.ID, in.Status); err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			// a delivered receipt arriving after the read one changes nothing
+			if _, err := wc.store.Get(context.Background(), in.ChatRoomID, in.Timestamp, in.ID); err == nil {
+				return
+			}
+			wc.send(client, errorFrame(in.ID, in.ChatRoomID, "receipt does not match a stored message"))
+			return
+		}
+		wc.log.Error("store receipt:", err)
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "receipt could not be stored"))
+		return
+	}
+	wc.relay(*in)
+}
+
+// handleMessageUpdate applies an edit, delete or reaction and pushes the new
+// state of the message to everyone connected to the room.
+func (wc *WSHandler) handleMessageUpdate(client *Client, in *Envelope) {
+	if in.ID == "" || in.Timestamp == 0 {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, fmt.Sprintf("%s needs the message id and timestamp", in.Type)))
+		return
+	}
+	if !client.Role.CanPost() {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "observers can not post in this chat room"))
+		return
 	}
 
-	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", wc.s3Bucket, fileName), nil
+	ctx := context.Background()
+	var msg *model.Message
+	var err error
+	switch in.Type {
+	case FrameEdit:
+		msg, err = wc.messages.Edit(ctx, client.UserID, in.ChatRoomID, in.Timestamp, in.ID, in.Body)
+	case FrameDelete:
+		msg, err = wc.messages.Delete(ctx, client.UserID, in.ChatRoomID, in.Timestamp, in.ID)
+	case FrameReaction:
+		msg, err = wc.messages.React(ctx, client.UserID, in.ChatRoomID, in.Timestamp, in.ID, in.Reaction, in.Remove)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMessageNotFound),
+			errors.Is(err, service.ErrNotMessageAuthor),
+			errors.Is(err, service.ErrMessageDeleted),
+			errors.Is(err, service.ErrEmptyMessage),
+			errors.Is(err, service.ErrInvalidReaction),
+			errors.Is(err, service.ErrMessageBlocked):
+			wc.send(client, errorFrame(in.ID, in.ChatRoomID, err.Error()))
+		default:
+			wc.log.Errorf("%s message: %v", in.Type, err)
+			wc.send(client, errorFrame(in.ID, in.ChatRoomID, "message could not be updated"))
+		}
+		return
+	}
+	switch in.Type {
+	case FrameEdit:
+		wc.indexMessage(msg)
+	case FrameDelete:
+		if err := wc.index.Remove(ctx, msg.ChatRoomId, msg.ID); err != nil {
+			wc.log.Error("unindex message:", err)
+		}
+	}
+	if in.Type != FrameReaction {
+		wc.reviseUnread(ctx, client, msg)
+	}
+	wc.broadcastToRoom(updateFrame(in.Type, msg, client.UserID), in.ChatRoomID)
 }
 
-func (wc *WSHandler) sendToUser(msg Message, userID uuid.UUID, roomID uuid.UUID) {
-	key := wc.HubKey(userID, roomID)
-	if client, ok := wc.Hub.Load(key); ok {
-		if err := client.(*Client).Conn.WriteJSON(msg); err != nil {
-			wc.log.Errorf("Error sending to user %s: %v", userID, err)
+// reviseUnread brings the message up to date in the unread backlog of the
+// other members of the room: an edited message is replayed with its new
+// body, a deleted one not at all.
+func (wc *WSHandler) reviseUnread(ctx context.Context, client *Client, msg *model.Message) {
+	room, err := wc.chatSrv.GetChatRoomForParticipant(ctx, client.UserID, msg.ChatRoomId)
+	if err != nil {
+		wc.log.Error("load chat room:", err)
+		return
+	}
+	revise := func(raw []byte) ([]byte, bool) {
+		var frame Envelope
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			return nil, false
 		}
-	} else {
-		if err := wc.storeUnreadMessage(roomID, userID, msg); err != nil {
-			wc.log.Errorf("Failed to store unread message: %v", err)
+		if frame.Type != FrameMessage || frame.ID != msg.ID || frame.Timestamp != msg.Timestamp {
+			return nil, false
+		}
+		if msg.DeletedAt != 0 {
+			return nil, true
+		}
+		frame.Body = msg.Body
+		frame.EditedAt = msg.EditedAt
+		revised, err := json.Marshal(frame)
+		if err != nil {
+			return nil, false
+		}
+		return revised, true
+	}
+	for _, member := range room.MemberIDs() {
+		if member == client.UserID {
+			continue
+		}
+		if _, err := wc.unread.Revise(ctx, msg.ChatRoomId, member, revise); err != nil {
+			wc.log.Errorf("Failed to revise unread backlog of user %s: %v", member, err)
 		}
 	}
 }
 
-// Redis operations
-func (wc *WSHandler) storeUnreadMessage(chatRoomID, userID uuid.UUID, msg Message) error {
+// PostMessage stores a message the server writes on behalf of a member, such
+// as an order delivery, and sends it to every member of the room, the sender's
+// other sockets included. ID and, if it matters, Timestamp are set by the
+// caller.
+func (wc *WSHandler) PostMessage(ctx context.Context, room *model.ChatRoom, msg *model.Message) error {
+	msg.ChatRoomId = room.ChatRoomID
+	msg.Status = model.StatusSent
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UTC().UnixMilli()
+	}
+	if err := wc.store.Save(ctx, msg); err != nil {
+		return fmt.Errorf("store message: %w", err)
+	}
+	wc.indexMessage(msg)
+
+	frame := Envelope{
+		Version:      ProtocolVersion,
+		Type:         FrameMessage,
+		ID:           msg.ID,
+		ChatRoomID:   msg.ChatRoomId,
+		From:         msg.From,
+		Body:         msg.Body,
+		AttachmentID: msg.AttachmentID,
+		Status:       msg.Status,
+		Timestamp:    msg.Timestamp,
+	}
+	wc.refreshAttachmentURL(&frame)
+	for _, member := range room.MemberIDs() {
+		wc.sendToUser(frame, member, room.ChatRoomID)
+	}
+	return nil
+}
+
+// indexMessage makes a stored message searchable. A failure only costs
+// search results, so it is logged and the message still goes out.
+func (wc *WSHandler) indexMessage(msg *model.Message) {
+	if msg.Body == "" {
+		return
+	}
+	if err := wc.index.Index(context.Background(), msg); err != nil {
+		wc.log.Error("index message:", err)
+	}
+}
+
+// relay forwards ephemeral frames such as typing indicators and receipts.
+// Unlike sendToUser they are dropped rather than queued when no socket is
+// online.
+func (wc *WSHandler) relay(frame Envelope) {
+	if frame.To == uuid.Nil {
+		wc.broadcastToRoom(frame, frame.ChatRoomID)
+		return
+	}
 	ctx := context.Background()
-	key := fmt.Sprintf("unread:%s:%s", chatRoomID.String(), userID.String())
-	data, err := json.Marshal(msg)
+	online, err := wc.broker.IsOnline(ctx, frame.To, frame.ChatRoomID)
 	if err != nil {
-		return fmt.Errorf("marshal error: %w", err)
+		wc.log.Errorf("Failed to check online state of user %s: %v", frame.To, err)
+		return
 	}
+	if !online {
+		return
+	}
+	if err := wc.broker.Publish(ctx, frame.ChatRoomID, frame.To, frame); err != nil {
+		wc.log.Errorf("Error relaying %s frame to user %s: %v", frame.Type, frame.To, err)
+	}
+}
 
-	return wc.redis.RPush(ctx, key, data).Err()
+// refreshAttachmentURL replaces the download URL of a queued frame, which has
+// most likely expired while the recipient was away.
+func (wc *WSHandler) refreshAttachmentURL(frame *Envelope) {
+	if frame.AttachmentID == nil {
+		return
+	}
+	downloadURL, err := wc.attachSrv.DownloadURLById(context.Background(), *frame.AttachmentID)
+	if err != nil {
+		wc.log.Errorf("Failed to refresh attachment %s: %v", frame.AttachmentID, err)
+		return
+	}
+	frame.File = downloadURL
 }
 
-func (wc *WSHandler) getAndClearUnreadMessages(chatRoomID, userID uuid.UUID) ([]Message, error) {
+func (wc *WSHandler) rejectConnection(conn *websocket.Conn, roomID uuid.UUID, reason string) {
+	wc.writeFrame(conn, errorFrame("", roomID, reason))
+	if err := conn.Close(); err != nil {
+		wc.log.Error("close connection:", err.Error())
+	}
+}
+
+// writeFrame writes directly to a connection that has no write pump yet.
+func (wc *WSHandler) writeFrame(conn *websocket.Conn, frame Envelope) {
+	_ = conn.SetWriteDeadline(time.Now().Add(wc.writeTimeout))
+	if err := conn.WriteJSON(frame); err != nil {
+		wc.log.Error("write:", err.Error())
+	}
+}
+
+// sendToUser publishes a message frame to the user, who may be following the
+// room from the event stream without a socket, and queues it when no socket
+// of the user is online in the room.
+func (wc *WSHandler) sendToUser(msg Envelope, userID uuid.UUID, roomID uuid.UUID) {
 	ctx := context.Background()
-	key := fmt.Sprintf("unread:%s:%s", chatRoomID.String(), userID.String())
+	published := true
+	if err := wc.broker.Publish(ctx, roomID, userID, msg); err != nil {
+		wc.log.Errorf("Error publishing to user %s: %v", userID, err)
+		published = false
+	}
+	online, err := wc.broker.IsOnline(ctx, userID, roomID)
+	if err != nil {
+		wc.log.Errorf("Failed to check online state of user %s: %v", userID, err)
+	}
+	if online && published {
+		return
+	}
+	if err := wc.storeUnreadMessage(roomID, userID, msg); err != nil {
+		wc.log.Errorf("Failed to store unread message: %v", err)
+	}
+}
 
-	pipe := wc.redis.TxPipeline()
-	getCmd := pipe.LRange(ctx, key, 0, -1)
-	pipe.Del(ctx, key)
-	_, err := pipe.Exec(ctx)
+func (wc *WSHandler) storeUnreadMessage(chatRoomID, userID uuid.UUID, msg Envelope) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+	return wc.unread.Push(context.Background(), chatRoomID, userID, data)
+}
 
-	if err != nil && err != redis.Nil {
-		return nil, fmt.Errorf("redis transaction failed: %w", err)
+func (wc *WSHandler) getUnreadMessages(chatRoomID, userID uuid.UUID) ([]Envelope, error) {
+	pending, err := wc.unread.Pending(context.Background(), chatRoomID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	var messages []Message
-	for _, data := range getCmd.Val() {
-		var msg Message
+	var messages []Envelope
+	for _, data := range pending {
+		var msg Envelope
 		if err := json.Unmarshal([]byte(data), &msg); err != nil {
 			wc.log.Warnf("Failed to unmarshal message: %v", err)
 			continue
@@ -264,41 +671,54 @@ func (wc *WSHandler) getAndClearUnreadMessages(chatRoomID, userID uuid.UUID) ([]
 	return messages, nil
 }
 
-func (wc *WSHandler) broadcastToRoom(msg Message, roomID uuid.UUID) {
+// handleBacklogAck drops the unread frames the client confirmed, up to and
+// including the acked timestamp.
+func (wc *WSHandler) handleBacklogAck(client *Client, in *Envelope) {
+	if in.Timestamp == 0 {
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "ack needs the timestamp of the last received message"))
+		return
+	}
+	if _, err := wc.unread.Ack(context.Background(), client.ChatRoomID, client.UserID, in.Timestamp); err != nil {
+		wc.log.Error("unread ack:", err)
+		wc.send(client, errorFrame(in.ID, in.ChatRoomID, "ack could not be stored"))
+	}
+}
+
+func (wc *WSHandler) broadcastToRoom(msg Envelope, roomID uuid.UUID) {
+	if err := wc.broker.Publish(context.Background(), roomID, uuid.Nil, msg); err != nil {
+		wc.log.Errorf("Error broadcasting message to room %s: %v", roomID, err)
+	}
+}
+
+// MembershipChanged tells the room that a member joined or left. A member
+// who left is disconnected from the room on whichever node holds the socket.
+func (wc *WSHandler) MembershipChanged(member *model.RoomMember, left bool) {
+	wc.broadcastToRoom(memberFrame(member, left), member.ChatRoomID)
+}
+
+// deliverLocal writes a room event to the clients connected to this node.
+func (wc *WSHandler) deliverLocal(event RoomEvent) {
+	roomID := event.Frame.ChatRoomID
+	if event.To != uuid.Nil {
+		if client, ok := wc.Hub.Load(wc.HubKey(event.To, roomID)); ok {
+			wc.send(client.(*Client), event.Frame)
+		}
+		return
+	}
 	wc.Hub.Range(func(k, v interface{}) bool {
 		client := v.(*Client)
 		if client.ChatRoomID == roomID {
-			if err := client.Conn.WriteJSON(msg); err != nil {
-				wc.log.Errorf("Error broadcasting message to client %s: %v", client.UserID, err)
-			}
+			wc.send(client, event.Frame)
 		}
 		return true
 	})
+	if event.Frame.Type == FrameMember && event.Frame.Left {
+		if client, ok := wc.Hub.Load(wc.HubKey(event.Frame.Member.UserID, roomID)); ok {
+			client.(*Client).close(websocket.ClosePolicyViolation, "removed from chat room")
+		}
+	}
 }
 
 func (wc *WSHandler) HubKey(userID, chatRoomID uuid.UUID) string {
 	return fmt.Sprintf("%s|%s", userID.String(), chatRoomID.String())
 }
-
-func (wc *WSHandler) putMessage(tableName string, msg *model.Message) error {
-	item := map[string]types.AttributeValue{
-		"chat_room_id": &types.AttributeValueMemberS{Value: msg.ChatRoomId.String()},
-		"time_stamp":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", msg.Timestamp)},
-		"to":           &types.AttributeValueMemberS{Value: msg.To.String()},
-		"from":         &types.AttributeValueMemberS{Value: msg.From.String()},
-		"body":         &types.AttributeValueMemberS{Value: msg.Body},
-	}
-
-	if msg.ImageUrl != "" {
-		item["image_url"] = &types.AttributeValueMemberS{Value: msg.ImageUrl}
-	}
-
-	_, err := wc.dynamodb.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      item,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to put message into DynamoDB: %w", err)
-	}
-	return nil
-}
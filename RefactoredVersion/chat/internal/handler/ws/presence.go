@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	presenceSweepInterval = 30 * time.Second
+	// touchInterval limits how often a busy socket refreshes its presence.
+	touchInterval = 10 * time.Second
+)
+
+func (wc *WSHandler) connectPresence(client *Client) {
+	cameOnline, err := wc.presence.Connect(context.Background(), client.UserID, client.ConnID)
+	if err != nil {
+		wc.log.Error("presence connect:", err)
+		return
+	}
+	if cameOnline {
+		wc.publishPresence(client.UserID)
+	}
+}
+
+func (wc *WSHandler) disconnectPresence(client *Client) {
+	wentOffline, err := wc.presence.Disconnect(context.Background(), client.UserID, client.ConnID)
+	if err != nil {
+		wc.log.Error("presence disconnect:", err)
+		return
+	}
+	if wentOffline {
+		wc.publishPresence(client.UserID)
+	}
+}
+
+// touch keeps the socket online, in its room and in the user's presence, on
+// any sign of life: a pong, a ping frame or any other inbound frame. It is
+// only called from the socket's read loop.
+func (wc *WSHandler) touch(client *Client) {
+	now := time.Now()
+	if now.Sub(client.lastTouch) < touchInterval {
+		return
+	}
+	client.lastTouch = now
+	ctx := context.Background()
+	if err := wc.presence.Heartbeat(ctx, client.UserID, client.ConnID); err != nil {
+		wc.log.Error("presence heartbeat:", err)
+	}
+	if err := wc.broker.Touch(ctx, client.UserID, client.ChatRoomID, client.ConnID); err != nil {
+		wc.log.Error("broker touch:", err)
+	}
+}
+
+// publishPresence pushes the user's current presence to the other members
+// of every room the user is in.
+func (wc *WSHandler) publishPresence(userID uuid.UUID) {
+	ctx := context.Background()
+	presence, err := wc.presence.Get(ctx, userID)
+	if err != nil {
+		wc.log.Error("presence get:", err)
+		return
+	}
+	rooms, err := wc.chatSrv.GetAllChatRoomByUserId(ctx, userID)
+	if err != nil {
+		wc.log.Error("presence rooms:", err)
+		return
+	}
+	for _, room := range rooms {
+		wc.relay(presenceFrame(room.ChatRoomID, userID, presence))
+	}
+}
+
+// sendPeerPresence tells a freshly connected client which of the other
+// members of the room are online.
+func (wc *WSHandler) sendPeerPresence(client *Client, room *model.ChatRoom) {
+	for _, peer := range room.MemberIDs() {
+		if peer == client.UserID {
+			continue
+		}
+		presence, err := wc.presence.Get(context.Background(), peer)
+		if err != nil {
+			wc.log.Error("presence get:", err)
+			return
+		}
+		frame := presenceFrame(room.ChatRoomID, peer, presence)
+		frame.To = client.UserID
+		wc.send(client, frame)
+	}
+}
+
+// sweepPresence reports users whose sockets stopped heartbeating without a
+// clean disconnect, typically because the node holding them went away.
+func (wc *WSHandler) sweepPresence(stop <-chan struct{}) {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			offline, err := wc.presence.Sweep(context.Background())
+			if err != nil {
+				wc.log.Error("presence sweep:", err)
+				continue
+			}
+			for _, userID := range offline {
+				wc.publishPresence(userID)
+			}
+		}
+	}
+}
+
+func presenceFrame(roomID, userID uuid.UUID, presence *model.Presence) Envelope {
+	return Envelope{
+		Version:    ProtocolVersion,
+		Type:       FramePresence,
+		ChatRoomID: roomID,
+		From:       userID,
+		Presence:   presence,
+		Timestamp:  time.Now().UTC().UnixMilli(),
+	}
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ProtocolVersion is the envelope version spoken on /ws/chat. Frames with a
+// zero version are treated as version 1 so older clients keep working.
+const ProtocolVersion = 1
+
+type FrameType string
+
+const (
+	FrameMessage  FrameType = "message"
+	FrameAck      FrameType = "ack"
+	FrameTyping   FrameType = "typing"
+	FrameRead     FrameType = "read"
+	FramePresence FrameType = "presence"
+	FrameError    FrameType = "error"
+	FramePing     FrameType = "ping"
+	FrameEdit     FrameType = "edit"
+	FrameDelete   FrameType = "delete"
+	FrameReaction FrameType = "reaction"
+	FrameMember   FrameType = "member"
+)
+
+// SystemUserID is the sender of frames generated by the server itself.
+var SystemUserID = uuid.MustParse("aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa")
+
+// Envelope is every frame exchanged on the chat socket, in both directions.
+// ID is generated by the client for message frames and echoed back in the
+// matching ack; Timestamp is assigned by the server once the message is
+// stored and, with ChatRoomID, identifies it for read receipts. Clients send
+// AttachmentID for files; File is only ever set by the server, to a
+// short-lived download URL. Edit, delete and reaction frames address an
+// existing message by ID and Timestamp, like read receipts.
+type Envelope struct {
+	Version      int                 `json:"v"`
+	Type         FrameType           `json:"type"`
+	ID           string              `json:"id,omitempty"`
+	ChatRoomID   uuid.UUID           `json:"chat_room_id"`
+	From         uuid.UUID           `json:"from"`
+	To           uuid.UUID           `json:"to"`
+	Body         string              `json:"body,omitempty"`
+	File         string              `json:"file,omitempty"`
+	AttachmentID *uuid.UUID          `json:"attachment_id,omitempty"`
+	Status       model.MessageStatus `json:"status,omitempty"`
+	Typing       bool                `json:"typing,omitempty"`
+	Presence     *model.Presence     `json:"presence,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	Timestamp    int64               `json:"timestamp,omitempty"`
+	EditedAt     int64               `json:"edited_at,omitempty"`
+	Deleted      bool                `json:"deleted,omitempty"`
+	// Reaction and Remove are sent by the client; Reactions is the full set
+	// the server pushes back after a change.
+	Reaction  string                 `json:"reaction,omitempty"`
+	Remove    bool                   `json:"remove,omitempty"`
+	Reactions map[string][]uuid.UUID `json:"reactions,omitempty"`
+	// Member and Left announce who joined or left the room.
+	Member *model.RoomMember `json:"member,omitempty"`
+	Left   bool              `json:"left,omitempty"`
+}
+
+func (e *Envelope) normalize() {
+	if e.Version == 0 {
+		e.Version = ProtocolVersion
+	}
+	if e.Type == "" {
+		e.Type = FrameMessage
+	}
+}
+
+func errorFrame(id string, roomID uuid.UUID, message string) Envelope {
+	return Envelope{
+		Version:    ProtocolVersion,
+		Type:       FrameError,
+		ID:         id,
+		ChatRoomID: roomID,
+		From:       SystemUserID,
+		Error:      message,
+	}
+}
+
+func ackFrame(msg *model.Message) Envelope {
+	return Envelope{
+		Version:    ProtocolVersion,
+		Type:       FrameAck,
+		ID:         msg.ID,
+		ChatRoomID: msg.ChatRoomId,
+		From:       SystemUserID,
+		To:         msg.From,
+		Status:     msg.Status,
+		Timestamp:  msg.Timestamp,
+	}
+}
+
+// updateFrame tells the room that a stored message changed. It carries the
+// new state of the message rather than the change itself.
+func updateFrame(frameType FrameType, msg *model.Message, actor uuid.UUID) Envelope {
+	return Envelope{
+		Version:    ProtocolVersion,
+		Type:       frameType,
+		ID:         msg.ID,
+		ChatRoomID: msg.ChatRoomId,
+		From:       actor,
+		Body:       msg.Body,
+		Status:     msg.Status,
+		Timestamp:  msg.Timestamp,
+		EditedAt:   msg.EditedAt,
+		Deleted:    msg.DeletedAt != 0,
+		Reactions:  msg.Reactions,
+	}
+}
+
+func memberFrame(member *model.RoomMember, left bool) Envelope {
+	return Envelope{
+		Version:    ProtocolVersion,
+		Type:       FrameMember,
+		ChatRoomID: member.ChatRoomID,
+		From:       SystemUserID,
+		Member:     member,
+		Left:       left,
+		Timestamp:  time.Now().UTC().UnixMilli(),
+	}
+}
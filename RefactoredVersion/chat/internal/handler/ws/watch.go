@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RoomWatch follows the room channels of one user over a Redis subscription
+// of its own, for readers outside the socket hub such as the SSE stream.
+type RoomWatch struct {
+	broker *Broker
+	userID uuid.UUID
+	pubSub *redis.PubSub
+	rooms  map[uuid.UUID]bool
+}
+
+func (b *Broker) Watch(ctx context.Context, userID uuid.UUID) *RoomWatch {
+	return &RoomWatch{
+		broker: b,
+		userID: userID,
+		pubSub: b.redis.Subscribe(ctx),
+		rooms:  make(map[uuid.UUID]bool),
+	}
+}
+
+// Follow subscribes to the rooms not followed yet and drops the ones that are
+// no longer in rooms.
+func (w *RoomWatch) Follow(ctx context.Context, rooms []uuid.UUID) error {
+	keep := make(map[uuid.UUID]bool, len(rooms))
+	var added, dropped []string
+	for _, roomID := range rooms {
+		keep[roomID] = true
+		if !w.rooms[roomID] {
+			added = append(added, w.broker.channel(roomID))
+		}
+	}
+	for roomID := range w.rooms {
+		if !keep[roomID] {
+			dropped = append(dropped, w.broker.channel(roomID))
+		}
+	}
+	if len(added) > 0 {
+		if err := w.pubSub.Subscribe(ctx, added...); err != nil {
+			return fmt.Errorf("subscribe rooms of %s: %w", w.userID, err)
+		}
+	}
+	if len(dropped) > 0 {
+		if err := w.pubSub.Unsubscribe(ctx, dropped...); err != nil {
+			return fmt.Errorf("unsubscribe rooms of %s: %w", w.userID, err)
+		}
+	}
+	w.rooms = keep
+	return nil
+}
+
+// Frames returns the frames sent to the followed rooms that are meant for
+// the user, until the watch is closed or ctx is done.
+func (w *RoomWatch) Frames(ctx context.Context) <-chan Envelope {
+	frames := make(chan Envelope)
+	go func() {
+		defer close(frames)
+		for msg := range w.pubSub.Channel() {
+			var event RoomEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				w.broker.log.Warnf("invalid room event on %s: %v", msg.Channel, err)
+				continue
+			}
+			if event.To != uuid.Nil && event.To != w.userID {
+				continue
+			}
+			select {
+			case frames <- event.Frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return frames
+}
+
+func (w *RoomWatch) Close() error {
+	return w.pubSub.Close()
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type AttachmentStatus string
+
+const (
+	// AttachmentPending is an attachment whose presigned upload has been
+	// issued but not yet checked against the object store.
+	AttachmentPending  AttachmentStatus = "pending"
+	AttachmentUploaded AttachmentStatus = "uploaded"
+)
+
+// Attachment is a file uploaded into a chat room. Messages only carry its id;
+// the object itself is served through short-lived download URLs.
+type Attachment struct {
+	ID          uuid.UUID        `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ChatRoomID  uuid.UUID        `gorm:"column:chat_room_id;type:uuid;not null;index" json:"chat_room_id"`
+	OwnerID     uuid.UUID        `gorm:"column:owner_id;type:uuid;not null" json:"owner_id"`
+	FileName    string           `gorm:"column:file_name;type:text;not null" json:"file_name"`
+	ContentType string           `gorm:"column:content_type;type:text;not null" json:"content_type"`
+	Size        int64            `gorm:"column:size;not null" json:"size"`
+	ObjectKey   string           `gorm:"column:object_key;type:text;not null" json:"-"`
+	Status      AttachmentStatus `gorm:"column:status;type:text;not null;default:'pending'" json:"status"`
+	CreatedAt   time.Time        `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (Attachment) TableName() string {
+	return "chat_attachment"
+}
+
+type AttachmentUploadRequest struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// AttachmentUpload is returned for a presigned upload: PUT the file to
+// UploadURL with the same Content-Type, then send AttachmentID in the message.
+type AttachmentUpload struct {
+	Attachment *Attachment `json:"attachment"`
+	UploadURL  string      `json:"upload_url"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+}
@@ -13,19 +13,136 @@ type ChatRoom struct {
 	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 	ServiceId      uuid.UUID `gorm:"column:service_id;type:uuid;not null" json:"service_id"`
 	OrderId        uuid.UUID `gorm:"column:order_id;type:uuid;not null" json:"order_id"`
+	UnreadCount    int64     `gorm:"-" json:"unread_count"`
+	// Members are the users beyond buyer and seller, see RoomMember.
+	Members []RoomMember `gorm:"foreignKey:ChatRoomID;references:ChatRoomID" json:"members,omitempty"`
 }
 
 func (ChatRoom) TableName() string {
 	return "chat_room"
 }
 
+// IsParticipant reports whether the user is a member of the room in any role.
+func (c ChatRoom) IsParticipant(userID uuid.UUID) bool {
+	_, ok := c.RoleOf(userID)
+	return ok
+}
+
+// RoleOf returns the user's role in the room. ParticipantOne is the buyer
+// and ParticipantTwo the seller.
+func (c ChatRoom) RoleOf(userID uuid.UUID) (RoomRole, bool) {
+	switch userID {
+	case uuid.Nil:
+		return "", false
+	case c.ParticipantOne:
+		return RoleBuyer, true
+	case c.ParticipantTwo:
+		return RoleSeller, true
+	}
+	for _, member := range c.Members {
+		if member.UserID == userID {
+			return member.Role, true
+		}
+	}
+	return "", false
+}
+
+// MemberIDs lists every member of the room, buyer and seller first.
+func (c ChatRoom) MemberIDs() []uuid.UUID {
+	ids := []uuid.UUID{c.ParticipantOne, c.ParticipantTwo}
+	for _, member := range c.Members {
+		if member.UserID != c.ParticipantOne && member.UserID != c.ParticipantTwo {
+			ids = append(ids, member.UserID)
+		}
+	}
+	return ids
+}
+
+type MessageStatus string
+
+const (
+	StatusSent      MessageStatus = "sent"
+	StatusDelivered MessageStatus = "delivered"
+	StatusRead      MessageStatus = "read"
+)
+
+// Message is a chat message as stored in the ChatMessages DynamoDB table or
+// the "Chat" Postgres table. Timestamp is in unix milliseconds and, with
+// ChatRoomId, forms the DynamoDB item key.
 type Message struct {
-	To         uuid.UUID `json:"to"`
-	From       uuid.UUID `json:"from"`
-	Timestamp  int64     `json:"timestamp"`
-	ChatRoomId uuid.UUID `json:"chat_room_id"`
-	Body       string    `json:"body"`
-	ImageUrl   string    `json:"image_url,omitempty"`
+	ID         string    `gorm:"column:message_id;type:text;primaryKey" json:"id"`
+	To         uuid.UUID `gorm:"column:recipient_id;type:uuid" json:"to"`
+	From       uuid.UUID `gorm:"column:sender_id;type:uuid;not null" json:"from"`
+	Timestamp  int64     `gorm:"column:time_stamp;not null;index:idx_chat_room_time,priority:2" json:"timestamp"`
+	ChatRoomId uuid.UUID `gorm:"column:chat_room_id;type:uuid;primaryKey;index:idx_chat_room_time,priority:1" json:"chat_room_id"`
+	Body       string    `gorm:"column:body;type:text;not null" json:"body"`
+	ImageUrl   string    `gorm:"column:image_url;type:text" json:"image_url,omitempty"`
+	// AttachmentID replaces ImageUrl for new messages; ImageUrl is then filled
+	// with a short-lived download URL when the message is read back.
+	AttachmentID *uuid.UUID    `gorm:"column:attachment_id;type:uuid" json:"attachment_id,omitempty"`
+	Status       MessageStatus `gorm:"column:status;type:text;not null;default:'sent'" json:"status"`
+	// EditedAt and DeletedAt are unix millis; a deleted message keeps its
+	// row but is returned without content.
+	EditedAt  int64         `gorm:"column:edited_at" json:"edited_at,omitempty"`
+	DeletedAt int64         `gorm:"column:deleted_at" json:"deleted_at,omitempty"`
+	Edits     []MessageEdit `gorm:"column:edits;type:jsonb;serializer:json" json:"edits,omitempty"`
+	// Reactions maps each emoji to the users that reacted with it.
+	Reactions map[string][]uuid.UUID `gorm:"column:reactions;type:jsonb;serializer:json" json:"reactions,omitempty"`
+	// Version counts the edits, deletes and reactions written to the message,
+	// so that concurrent changes do not overwrite each other.
+	Version int64 `gorm:"column:version;not null;default:0" json:"-"`
+}
+
+func (Message) TableName() string { return "Chat" }
+
+// MessageEdit is a previous version of an edited message body.
+type MessageEdit struct {
+	Body     string `json:"body"`
+	EditedAt int64  `json:"edited_at"`
+}
+
+// Redact drops the content of a deleted message before it leaves the server.
+func (m *Message) Redact() {
+	if m.DeletedAt == 0 {
+		return
+	}
+	m.Body = ""
+	m.ImageUrl = ""
+	m.AttachmentID = nil
+	m.Edits = nil
+	m.Reactions = nil
+}
+
+type MessagePage struct {
+	Messages     []*Message `json:"messages"`
+	NextBefore   int64      `json:"next_before,omitempty"`
+	NextBeforeID string     `json:"next_before_id,omitempty"`
+}
+
+// MessageCursor is a position in the history of a room. Messages are ordered
+// by timestamp and then id, so messages of the same millisecond are not
+// skipped at a page boundary. An empty ID takes every message older than
+// Timestamp.
+type MessageCursor struct {
+	Timestamp int64
+	ID        string
+}
+
+// Cursor returns the position right after the message, going back in time.
+func (m *Message) Cursor() MessageCursor {
+	return MessageCursor{Timestamp: m.Timestamp, ID: m.ID}
+}
+
+// After reports whether msg comes after the cursor going back in time, that
+// is whether it is older.
+func (c MessageCursor) After(msg *Message) bool {
+	if c.Timestamp == 0 {
+		return true
+	}
+	if c.ID == "" || msg.Timestamp != c.Timestamp {
+		return msg.Timestamp < c.Timestamp
+	}
+	return msg.ID < c.ID
 }
 
 type UserData struct {
@@ -285,22 +402,39 @@ type OrderPlaceRequest struct {
 	BuyerId   uuid.UUID `json:"buyerId"`
 	SellerId  uuid.UUID `json:"sellerId"`
 	ServiceId uuid.UUID `json:"serviceId"`
+	PackageId uuid.UUID `json:"packageId"`
 }
 type Order struct {
-	ID            uuid.UUID  `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()"`
-	OrderNumber   string     `gorm:"column:orderNumber;type:text;not null;uniqueIndex"`
-	Price         float64    `gorm:"column:price;not null"`
-	PaymentMethod string     `gorm:"column:paymentMethod;type:text;not null"`
-	Status        string     `gorm:"column:status;type:text;not null;default:'PENDING'"`
-	TransactionID *string    `gorm:"column:transactionId;type:text"`
-	Requirements  *string    `gorm:"column:requirements;type:text"`
-	PackageID     uuid.UUID  `gorm:"column:packageId;type:uuid;not null"`
-	SellerID      uuid.UUID  `gorm:"column:sellerId;type:uuid;not null"`
-	BuyerID       uuid.UUID  `gorm:"column:buyerId;type:uuid;not null"`
-	CreatedAt     time.Time  `gorm:"column:createdAt;autoCreateTime"`
-	UpdatedAt     time.Time  `gorm:"column:updatedAt;autoUpdateTime"`
-	CompletedAt   *time.Time `gorm:"column:completedAt"`
-	DueDate       *time.Time `gorm:"column:dueDate"`
+	ID            uuid.UUID   `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()"`
+	OrderNumber   string      `gorm:"column:order_number;type:text;not null;uniqueIndex"`
+	Price         float64     `gorm:"column:price;not null"`
+	PaymentMethod string      `gorm:"column:payment_method;type:text;not null"`
+	Status        OrderStatus `gorm:"column:status;type:text;not null;default:'PENDING'"`
+	TransactionID *string     `gorm:"column:transaction_id;type:text"`
+	Requirements  *string     `gorm:"column:requirements;type:text"`
+	PackageID     uuid.UUID   `gorm:"column:package_id;type:uuid;not null"`
+	SellerID      uuid.UUID   `gorm:"column:seller_id;type:uuid;not null"`
+	BuyerID       uuid.UUID   `gorm:"column:buyer_id;type:uuid;not null"`
+	CreatedAt     time.Time   `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt     time.Time   `gorm:"column:updated_at;autoUpdateTime"`
+	CompletedAt   *time.Time  `gorm:"column:completed_at"`
+	DueDate       *time.Time  `gorm:"column:due_date"`
+
+	// set by the order state machine, see OrderStatus
+	AcceptedAt      *time.Time `gorm:"column:accepted_at"`
+	DeliveredAt     *time.Time `gorm:"column:delivered_at"`
+	CancelledAt     *time.Time `gorm:"column:cancelled_at"`
+	StatusChangedBy *uuid.UUID `gorm:"column:status_changed_by;type:uuid"`
+	StatusChangedAt *time.Time `gorm:"column:status_changed_at"`
+
+	PackageSnapshot *PackageSnapshot `gorm:"column:package_snapshot;type:jsonb;serializer:json"`
+	RevisionsUsed   int              `gorm:"column:revisions_used;not null;default:0"`
+
+	// set by the order scheduler
+	IsLate        bool       `gorm:"column:is_late;not null;default:false"`
+	LateAt        *time.Time `gorm:"column:late_at"`
+	DueRemindedAt *time.Time `gorm:"column:due_reminded_at"`
+	AutoCompleted bool       `gorm:"column:auto_completed;not null;default:false"`
 
 	Package GigPackage `gorm:"foreignKey:PackageID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
 	Seller  User       `gorm:"foreignKey:SellerID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
@@ -313,18 +447,25 @@ func (Order) TableName() string { return "Order" }
 // Review maps to the "Review" table
 
 type Review struct {
-	ID        uuid.UUID `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()"`
-	Title     string    `gorm:"column:title;type:text;not null"`
-	Content   *string   `gorm:"column:content;type:text"`
-	Rating    int       `gorm:"column:rating;not null;default:5"`
-	IsPublic  bool      `gorm:"column:isPublic;not null;default:true"`
-	AuthorID  uuid.UUID `gorm:"column:authorId;type:uuid;not null;index"`
-	OrderID   uuid.UUID `gorm:"column:orderId;type:uuid;not null;uniqueIndex"`
-	CreatedAt time.Time `gorm:"column:createdAt;autoCreateTime"`
-	UpdatedAt time.Time `gorm:"column:updatedAt;autoUpdateTime"`
-
-	Author User  `gorm:"foreignKey:AuthorID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
-	Order  Order `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
+	ID        uuid.UUID `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Title     string    `gorm:"column:title;type:text;not null" json:"title"`
+	Content   *string   `gorm:"column:content;type:text" json:"content,omitempty"`
+	Rating    int       `gorm:"column:rating;not null;default:5" json:"rating"`
+	IsPublic  bool      `gorm:"column:isPublic;not null;default:true" json:"is_public"`
+	AuthorID  uuid.UUID `gorm:"column:authorId;type:uuid;not null;index" json:"author_id"`
+	OrderID   uuid.UUID `gorm:"column:orderId;type:uuid;not null;uniqueIndex" json:"order_id"`
+	CreatedAt time.Time `gorm:"column:createdAt;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updatedAt;autoUpdateTime" json:"updated_at"`
+
+	// copied from the order so reviews can be listed per gig and seller
+	GigID    uuid.UUID `gorm:"column:gigId;type:uuid;not null;index" json:"gig_id"`
+	SellerID uuid.UUID `gorm:"column:sellerId;type:uuid;not null;index" json:"seller_id"`
+
+	SellerReply *string    `gorm:"column:sellerReply;type:text" json:"seller_reply,omitempty"`
+	RepliedAt   *time.Time `gorm:"column:repliedAt" json:"replied_at,omitempty"`
+
+	Author User  `gorm:"foreignKey:AuthorID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"-"`
+	Order  Order `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"-"`
 }
 
 func (Review) TableName() string { return "Review" }
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type DeliveryResponse string
+
+const (
+	DeliveryPending           DeliveryResponse = "pending"
+	DeliveryAccepted          DeliveryResponse = "accepted"
+	DeliveryRevisionRequested DeliveryResponse = "revision_requested"
+)
+
+// OrderDelivery is one delivery of work by the seller. Number counts the
+// deliveries of the order from 1; only the latest one can still be pending.
+type OrderDelivery struct {
+	ID            uuid.UUID        `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrderID       uuid.UUID        `gorm:"column:order_id;type:uuid;not null;index" json:"order_id"`
+	Number        int              `gorm:"column:number;not null" json:"number"`
+	SellerID      uuid.UUID        `gorm:"column:seller_id;type:uuid;not null" json:"seller_id"`
+	Message       string           `gorm:"column:message;type:text" json:"message,omitempty"`
+	AttachmentIDs []uuid.UUID      `gorm:"column:attachment_ids;type:jsonb;serializer:json" json:"attachment_ids,omitempty"`
+	Response      DeliveryResponse `gorm:"column:response;type:text;not null;default:'pending'" json:"response"`
+	ResponseNote  string           `gorm:"column:response_note;type:text" json:"response_note,omitempty"`
+	RespondedAt   *time.Time       `gorm:"column:responded_at" json:"responded_at,omitempty"`
+	CreatedAt     time.Time        `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (OrderDelivery) TableName() string { return "OrderDelivery" }
+
+// DeliveryRequest is the body of a delivery. Attachments are uploaded to the
+// order's chat room beforehand and referenced by id.
+type DeliveryRequest struct {
+	Message       string      `json:"message"`
+	AttachmentIDs []uuid.UUID `json:"attachment_ids"`
+}
+
+// DeliveryResponseRequest is the body of accepting a delivery or asking for
+// a revision of it.
+type DeliveryResponseRequest struct {
+	Note string `json:"note"`
+}
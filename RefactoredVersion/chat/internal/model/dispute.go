@@ -0,0 +1,105 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type DisputeStatus string
+
+const (
+	DisputeOpen     DisputeStatus = "open"
+	DisputeResolved DisputeStatus = "resolved"
+)
+
+// DisputeRuling is the moderator's decision on a dispute. A full refund
+// cancels the order, a partial refund and a release complete it.
+type DisputeRuling string
+
+const (
+	RulingFullRefund    DisputeRuling = "full_refund"
+	RulingPartialRefund DisputeRuling = "partial_refund"
+	RulingRelease       DisputeRuling = "release"
+)
+
+func (r DisputeRuling) Valid() bool {
+	switch r {
+	case RulingFullRefund, RulingPartialRefund, RulingRelease:
+		return true
+	}
+	return false
+}
+
+// OrderDispute is a disagreement over an order, opened by its buyer or
+// seller and ruled on by the moderator it is assigned to. RefundAmount is
+// in cents and only set by a partial refund.
+type OrderDispute struct {
+	ID           uuid.UUID          `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrderID      uuid.UUID          `gorm:"column:order_id;type:uuid;not null;index" json:"order_id"`
+	OpenedBy     uuid.UUID          `gorm:"column:opened_by;type:uuid;not null" json:"opened_by"`
+	OpenedByRole RoomRole           `gorm:"column:opened_by_role;type:text;not null" json:"opened_by_role"`
+	Reason       string             `gorm:"column:reason;type:text;not null" json:"reason"`
+	Status       DisputeStatus      `gorm:"column:status;type:text;not null;default:'open'" json:"status"`
+	ModeratorID  *uuid.UUID         `gorm:"column:moderator_id;type:uuid" json:"moderator_id,omitempty"`
+	AssignedAt   *time.Time         `gorm:"column:assigned_at" json:"assigned_at,omitempty"`
+	Ruling       DisputeRuling      `gorm:"column:ruling;type:text" json:"ruling,omitempty"`
+	RefundAmount int64              `gorm:"column:refund_amount;not null;default:0" json:"refund_amount,omitempty"`
+	RulingNote   string             `gorm:"column:ruling_note;type:text" json:"ruling_note,omitempty"`
+	ResolvedAt   *time.Time         `gorm:"column:resolved_at" json:"resolved_at,omitempty"`
+	CreatedAt    time.Time          `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	Evidence     []*DisputeEvidence `gorm:"foreignKey:DisputeID" json:"evidence,omitempty"`
+	// SettlementError is set on a ruling whose payment could not be moved
+	// yet; the ruling stands and the scheduler retries the settlement.
+	SettlementError string `gorm:"-" json:"settlement_error,omitempty"`
+}
+
+func (OrderDispute) TableName() string { return "OrderDispute" }
+
+// DisputeEvidence is a message of the order's chat submitted to a dispute.
+// The message is copied as it was when submitted, so later edits and
+// deletes do not change the evidence.
+type DisputeEvidence struct {
+	ID               uuid.UUID  `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DisputeID        uuid.UUID  `gorm:"column:dispute_id;type:uuid;not null;index" json:"dispute_id"`
+	SubmittedBy      uuid.UUID  `gorm:"column:submitted_by;type:uuid;not null" json:"submitted_by"`
+	MessageID        string     `gorm:"column:message_id;type:text;not null" json:"message_id"`
+	MessageTimestamp int64      `gorm:"column:message_timestamp;not null" json:"message_timestamp"`
+	MessageFrom      uuid.UUID  `gorm:"column:message_from;type:uuid;not null" json:"message_from"`
+	Body             string     `gorm:"column:body;type:text" json:"body"`
+	AttachmentID     *uuid.UUID `gorm:"column:attachment_id;type:uuid" json:"attachment_id,omitempty"`
+	Note             string     `gorm:"column:note;type:text" json:"note,omitempty"`
+	CreatedAt        time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (DisputeEvidence) TableName() string { return "DisputeEvidence" }
+
+// EvidenceRef points at a message of the order's chat by its id and server
+// timestamp, as they appear in chat frames and history.
+type EvidenceRef struct {
+	MessageID string `json:"message_id"`
+	Timestamp int64  `json:"timestamp"`
+	Note      string `json:"note"`
+}
+
+type DisputeOpenRequest struct {
+	Reason   string        `json:"reason"`
+	Evidence []EvidenceRef `json:"evidence"`
+}
+
+type DisputeEvidenceRequest struct {
+	Evidence []EvidenceRef `json:"evidence"`
+}
+
+// DisputeAssignRequest assigns a dispute to a moderator, the caller when
+// ModeratorID is empty.
+type DisputeAssignRequest struct {
+	ModeratorID uuid.UUID `json:"moderator_id"`
+}
+
+// DisputeRulingRequest closes a dispute. RefundAmount, in cents, is required
+// for a partial refund and must be less than the order's price.
+type DisputeRulingRequest struct {
+	Ruling       DisputeRuling `json:"ruling"`
+	RefundAmount int64         `json:"refund_amount"`
+	Note         string        `json:"note"`
+}
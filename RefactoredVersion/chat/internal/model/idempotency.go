@@ -0,0 +1,28 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// OrderIdempotencyKey remembers the order placed for an Idempotency-Key of
+// a buyer. The primary key is what stops concurrent duplicates.
+type OrderIdempotencyKey struct {
+	BuyerID     uuid.UUID `gorm:"column:buyer_id;type:uuid;primaryKey"`
+	Key         string    `gorm:"column:key;type:text;primaryKey"`
+	RequestHash string    `gorm:"column:request_hash;type:text;not null"`
+	OrderID     uuid.UUID `gorm:"column:order_id;type:uuid;not null"`
+	ChatRoomID  uuid.UUID `gorm:"column:chat_room_id;type:uuid;not null"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime"`
+	ExpiresAt   time.Time `gorm:"column:expires_at;not null;index"`
+}
+
+func (OrderIdempotencyKey) TableName() string { return "OrderIdempotencyKey" }
+
+// PlacedOrder is the response of order placement. Replayed is set when it
+// was answered from an earlier request with the same Idempotency-Key.
+type PlacedOrder struct {
+	ChatRoomID uuid.UUID `json:"chat_room"`
+	Order      *Order    `json:"order"`
+	Replayed   bool      `json:"-"`
+}
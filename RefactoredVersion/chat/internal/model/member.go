@@ -0,0 +1,47 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type RoomRole string
+
+const (
+	RoleBuyer     RoomRole = "buyer"
+	RoleSeller    RoomRole = "seller"
+	RoleModerator RoomRole = "moderator"
+	RoleObserver  RoomRole = "observer"
+)
+
+func (r RoomRole) Valid() bool {
+	switch r {
+	case RoleBuyer, RoleSeller, RoleModerator, RoleObserver:
+		return true
+	}
+	return false
+}
+
+// CanPost reports whether the role may send, edit or react to messages.
+// Observers only read along.
+func (r RoomRole) CanPost() bool {
+	return r != RoleObserver
+}
+
+// RoomMember is a row of chat_room_member. The buyer and seller of a room
+// are its participant columns and have no row of their own; the table holds
+// everyone who joined later, such as dispute moderators.
+type RoomMember struct {
+	ChatRoomID uuid.UUID `gorm:"column:chat_room_id;type:uuid;primaryKey" json:"chat_room_id"`
+	UserID     uuid.UUID `gorm:"column:user_id;type:uuid;primaryKey;index" json:"user_id"`
+	Role       RoomRole  `gorm:"column:role;type:text;not null" json:"role"`
+	AddedBy    uuid.UUID `gorm:"column:added_by;type:uuid" json:"added_by"`
+	JoinedAt   time.Time `gorm:"column:joined_at;autoCreateTime" json:"joined_at"`
+}
+
+func (RoomMember) TableName() string { return "chat_room_member" }
+
+type RoomMemberRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+	Role   RoomRole  `json:"role"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ModerationAction string
+
+const (
+	ModerationAllow   ModerationAction = "allow"
+	ModerationMask    ModerationAction = "mask"
+	ModerationBlock   ModerationAction = "block"
+	ModerationFlagged ModerationAction = "flag"
+)
+
+func (a ModerationAction) Valid() bool {
+	switch a {
+	case ModerationAllow, ModerationMask, ModerationBlock, ModerationFlagged:
+		return true
+	}
+	return false
+}
+
+type FlagStatus string
+
+const (
+	FlagPending   FlagStatus = "pending"
+	FlagDismissed FlagStatus = "dismissed"
+	FlagConfirmed FlagStatus = "confirmed"
+)
+
+// ModerationFlag is an entry of the review queue: a message that a flag rule
+// matched, or that a block rule stopped. Body is the text as the sender
+// wrote it.
+type ModerationFlag struct {
+	ID         uuid.UUID        `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ChatRoomID uuid.UUID        `gorm:"column:chat_room_id;type:uuid;not null" json:"chat_room_id"`
+	MessageID  string           `gorm:"column:message_id;type:text;not null" json:"message_id"`
+	SenderID   uuid.UUID        `gorm:"column:sender_id;type:uuid;not null" json:"sender_id"`
+	Rule       string           `gorm:"column:rule;type:text;not null" json:"rule"`
+	Action     ModerationAction `gorm:"column:action;type:text;not null" json:"action"`
+	Body       string           `gorm:"column:body;type:text;not null" json:"body"`
+	Matched    string           `gorm:"column:matched;type:text" json:"matched,omitempty"`
+	Status     FlagStatus       `gorm:"column:status;type:text;not null;default:'pending'" json:"status"`
+	ReviewedBy *uuid.UUID       `gorm:"column:reviewed_by;type:uuid" json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time       `gorm:"column:reviewed_at" json:"reviewed_at,omitempty"`
+	Note       string           `gorm:"column:note;type:text" json:"note,omitempty"`
+	CreatedAt  time.Time        `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (ModerationFlag) TableName() string { return "chat_moderation_flag" }
+
+type FlagReviewRequest struct {
+	Status FlagStatus `json:"status"`
+	Note   string     `json:"note"`
+}
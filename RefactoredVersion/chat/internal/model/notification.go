@@ -0,0 +1,48 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	NotificationOrderPlaced        NotificationType = "order_placed"
+	NotificationOrderDueSoon       NotificationType = "order_due_soon"
+	NotificationOrderLate          NotificationType = "order_late"
+	NotificationOrderAutoCompleted NotificationType = "order_auto_completed"
+	NotificationOrderUpdated       NotificationType = "order_updated"
+	NotificationReviewReceived     NotificationType = "review_received"
+	NotificationReviewReplied      NotificationType = "review_replied"
+)
+
+// Notification is one entry of a user's feed. ID grows with every
+// notification and is the event id of the SSE stream, so a reconnecting
+// client resumes after the last one it saw. Notifications may commit out of
+// id order, so clients should not assume every id below one they saw was
+// delivered.
+type Notification struct {
+	ID        int64                  `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	UserID    uuid.UUID              `gorm:"column:user_id;type:uuid;not null" json:"user_id"`
+	Type      NotificationType       `gorm:"column:type;type:text;not null" json:"type"`
+	OrderID   *uuid.UUID             `gorm:"column:order_id;type:uuid" json:"order_id,omitempty"`
+	Payload   map[string]interface{} `gorm:"column:payload;type:jsonb;serializer:json" json:"payload"`
+	ReadAt    *time.Time             `gorm:"column:read_at" json:"read_at,omitempty"`
+	CreatedAt time.Time              `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (Notification) TableName() string { return "Notification" }
+
+type NotificationPage struct {
+	Notifications []*Notification `json:"notifications"`
+	Unread        int64           `json:"unread"`
+	NextBefore    int64           `json:"next_before,omitempty"`
+}
+
+// NotificationReadAllRequest limits read-all to the notifications up to and
+// including UpTo, so ones that arrived after the client rendered its list
+// stay unread. Zero marks all of them.
+type NotificationReadAllRequest struct {
+	UpTo int64 `json:"up_to"`
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type OrderEventType string
+
+const (
+	OrderEventCreated    OrderEventType = "created"
+	OrderEventTransition OrderEventType = "transition"
+	OrderEventLate       OrderEventType = "late"
+	OrderEventReminder   OrderEventType = "reminder"
+	// dispute steps that leave the order's status alone; opening and ruling
+	// on a dispute are transitions
+	OrderEventDisputeEvidence OrderEventType = "dispute_evidence"
+	OrderEventDisputeAssigned OrderEventType = "dispute_assigned"
+	// the payment of a completed or cancelled order could not be settled and
+	// is left to the scheduler
+	OrderEventSettlementDeferred OrderEventType = "settlement_deferred"
+)
+
+// FieldChange is the old and new value of one column of an order.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// OrderEvent is one entry of an order's audit trail. It is written in the
+// same transaction as the change it describes. ActorID is empty for changes
+// made by the system.
+type OrderEvent struct {
+	ID         uuid.UUID              `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrderID    uuid.UUID              `gorm:"column:order_id;type:uuid;not null;index" json:"order_id"`
+	ActorID    *uuid.UUID             `gorm:"column:actor_id;type:uuid" json:"actor_id,omitempty"`
+	Type       OrderEventType         `gorm:"column:type;type:text;not null" json:"type"`
+	FromStatus OrderStatus            `gorm:"column:from_status;type:text" json:"from_status,omitempty"`
+	ToStatus   OrderStatus            `gorm:"column:to_status;type:text" json:"to_status,omitempty"`
+	Diff       map[string]FieldChange `gorm:"column:diff;type:jsonb;serializer:json" json:"diff,omitempty"`
+	Note       string                 `gorm:"column:note;type:text" json:"note,omitempty"`
+	CreatedAt  time.Time              `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (OrderEvent) TableName() string { return "OrderEvent" }
+
+// OrderTransitionRequest is the optional body of the transition endpoints.
+type OrderTransitionRequest struct {
+	Note string `json:"note"`
+}
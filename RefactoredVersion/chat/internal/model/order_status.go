@@ -0,0 +1,82 @@
+package model
+
+type OrderStatus string
+
+const (
+	OrderPending           OrderStatus = "PENDING"
+	OrderAccepted          OrderStatus = "ACCEPTED"
+	OrderInProgress        OrderStatus = "IN_PROGRESS"
+	OrderDelivered         OrderStatus = "DELIVERED"
+	OrderRevisionRequested OrderStatus = "REVISION_REQUESTED"
+	OrderCompleted         OrderStatus = "COMPLETED"
+	OrderCancelled         OrderStatus = "CANCELLED"
+	OrderDisputed          OrderStatus = "DISPUTED"
+)
+
+// orderTransitions lists, for every status, the statuses an order may move
+// to and the parties allowed to move it there. Moderators are platform staff
+// and only settle disputes: a ruling completes or cancels the order.
+var orderTransitions = map[OrderStatus]map[OrderStatus][]RoomRole{
+	OrderPending: {
+		OrderAccepted:  {RoleSeller},
+		OrderCancelled: {RoleBuyer, RoleSeller},
+	},
+	OrderAccepted: {
+		OrderInProgress: {RoleSeller},
+		OrderCancelled:  {RoleBuyer, RoleSeller},
+	},
+	OrderInProgress: {
+		OrderDelivered: {RoleSeller},
+		OrderDisputed:  {RoleBuyer, RoleSeller},
+	},
+	OrderDelivered: {
+		OrderRevisionRequested: {RoleBuyer},
+		OrderCompleted:         {RoleBuyer},
+		OrderDisputed:          {RoleBuyer, RoleSeller},
+	},
+	OrderRevisionRequested: {
+		OrderInProgress: {RoleSeller},
+		OrderDelivered:  {RoleSeller},
+		OrderDisputed:   {RoleBuyer, RoleSeller},
+	},
+	OrderDisputed: {
+		OrderCompleted: {RoleModerator},
+		OrderCancelled: {RoleModerator},
+	},
+}
+
+func (s OrderStatus) Valid() bool {
+	switch s {
+	case OrderPending, OrderAccepted, OrderInProgress, OrderDelivered,
+		OrderRevisionRequested, OrderCompleted, OrderCancelled, OrderDisputed:
+		return true
+	}
+	return false
+}
+
+// OrderActiveStatuses are the statuses in which the seller still owes work,
+// so the due date applies.
+var OrderActiveStatuses = []OrderStatus{OrderPending, OrderAccepted, OrderInProgress, OrderRevisionRequested}
+
+// Terminal reports whether no transition leads out of the status.
+func (s OrderStatus) Terminal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+// CanTransition reports whether an order in status s may move to status to
+// at all, whoever asks.
+func (s OrderStatus) CanTransition(to OrderStatus) bool {
+	_, ok := orderTransitions[s][to]
+	return ok
+}
+
+// AllowedBy reports whether the party with the given role may move an order
+// from status s to status to.
+func (s OrderStatus) AllowedBy(to OrderStatus, role RoomRole) bool {
+	for _, allowed := range orderTransitions[s][to] {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
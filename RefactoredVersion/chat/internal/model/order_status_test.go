@@ -0,0 +1,86 @@
+package model
+
+import "testing"
+
+var allOrderStatuses = []OrderStatus{
+	OrderPending, OrderAccepted, OrderInProgress, OrderDelivered,
+	OrderRevisionRequested, OrderCompleted, OrderCancelled, OrderDisputed,
+}
+
+var allRoomRoles = []RoomRole{RoleBuyer, RoleSeller, RoleModerator, RoleObserver}
+
+func TestOrderTransitions(t *testing.T) {
+	// every step of the state machine with the roles that may take it;
+	// anything not listed is denied
+	allowed := []struct {
+		from, to OrderStatus
+		roles    []RoomRole
+	}{
+		{OrderPending, OrderAccepted, []RoomRole{RoleSeller}},
+		{OrderPending, OrderCancelled, []RoomRole{RoleBuyer, RoleSeller}},
+		{OrderAccepted, OrderInProgress, []RoomRole{RoleSeller}},
+		{OrderAccepted, OrderCancelled, []RoomRole{RoleBuyer, RoleSeller}},
+		{OrderInProgress, OrderDelivered, []RoomRole{RoleSeller}},
+		{OrderInProgress, OrderDisputed, []RoomRole{RoleBuyer, RoleSeller}},
+		{OrderDelivered, OrderRevisionRequested, []RoomRole{RoleBuyer}},
+		{OrderDelivered, OrderCompleted, []RoomRole{RoleBuyer}},
+		{OrderDelivered, OrderDisputed, []RoomRole{RoleBuyer, RoleSeller}},
+		{OrderRevisionRequested, OrderInProgress, []RoomRole{RoleSeller}},
+		{OrderRevisionRequested, OrderDelivered, []RoomRole{RoleSeller}},
+		{OrderRevisionRequested, OrderDisputed, []RoomRole{RoleBuyer, RoleSeller}},
+		{OrderDisputed, OrderCompleted, []RoomRole{RoleModerator}},
+		{OrderDisputed, OrderCancelled, []RoomRole{RoleModerator}},
+	}
+	want := make(map[OrderStatus]map[OrderStatus]map[RoomRole]bool)
+	for _, step := range allowed {
+		if want[step.from] == nil {
+			want[step.from] = make(map[OrderStatus]map[RoomRole]bool)
+		}
+		want[step.from][step.to] = make(map[RoomRole]bool)
+		for _, role := range step.roles {
+			want[step.from][step.to][role] = true
+		}
+	}
+
+	for _, from := range allOrderStatuses {
+		for _, to := range allOrderStatuses {
+			roles, step := want[from][to]
+			if got := from.CanTransition(to); got != step {
+				t.Errorf("%s.CanTransition(%s) = %v, want %v", from, to, got, step)
+			}
+			for _, role := range allRoomRoles {
+				if got := from.AllowedBy(to, role); got != roles[role] {
+					t.Errorf("%s.AllowedBy(%s, %s) = %v, want %v", from, to, role, got, roles[role])
+				}
+			}
+		}
+	}
+}
+
+func TestOrderStatusTerminal(t *testing.T) {
+	terminal := map[OrderStatus]bool{OrderCompleted: true, OrderCancelled: true}
+	for _, status := range allOrderStatuses {
+		if got := status.Terminal(); got != terminal[status] {
+			t.Errorf("%s.Terminal() = %v, want %v", status, got, terminal[status])
+		}
+		if !terminal[status] {
+			continue
+		}
+		for _, to := range allOrderStatuses {
+			if status.CanTransition(to) {
+				t.Errorf("terminal %s can move to %s", status, to)
+			}
+		}
+	}
+}
+
+func TestOrderStatusValid(t *testing.T) {
+	for _, status := range allOrderStatuses {
+		if !status.Valid() {
+			t.Errorf("%s.Valid() = false", status)
+		}
+	}
+	if OrderStatus("SHIPPED").Valid() {
+		t.Error("unknown status is valid")
+	}
+}
@@ -0,0 +1,43 @@
+package model
+
+import "github.com/google/uuid"
+
+// PackageSnapshot is the gig package as it was when the order was placed.
+// Later edits to the gig do not change what the buyer paid for.
+type PackageSnapshot struct {
+	GigID        uuid.UUID                `json:"gig_id"`
+	GigTitle     string                   `json:"gig_title"`
+	Title        string                   `json:"title"`
+	Description  string                   `json:"description"`
+	Price        float64                  `json:"price"`
+	DeliveryDays int                      `json:"delivery_days"`
+	Revisions    int                      `json:"revisions"`
+	Features     []PackageFeatureSnapshot `json:"features"`
+}
+
+type PackageFeatureSnapshot struct {
+	Title       string  `json:"title"`
+	Description *string `json:"description,omitempty"`
+	Included    bool    `json:"included"`
+}
+
+func NewPackageSnapshot(pkg *GigPackage) *PackageSnapshot {
+	snapshot := &PackageSnapshot{
+		GigID:        pkg.GigID,
+		GigTitle:     pkg.Gig.Title,
+		Title:        pkg.Title,
+		Description:  pkg.Description,
+		Price:        pkg.Price,
+		DeliveryDays: pkg.DeliveryTime,
+		Revisions:    pkg.Revisions,
+		Features:     make([]PackageFeatureSnapshot, 0, len(pkg.Features)),
+	}
+	for _, feature := range pkg.Features {
+		snapshot.Features = append(snapshot.Features, PackageFeatureSnapshot{
+			Title:       feature.Title,
+			Description: feature.Description,
+			Included:    feature.Included,
+		})
+	}
+	return snapshot
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"math"
+	"time"
+)
+
+type PaymentStatus string
+
+const (
+	// PaymentHeld means the buyer paid and the money sits in escrow.
+	PaymentHeld     PaymentStatus = "held"
+	PaymentReleased PaymentStatus = "released"
+	PaymentRefunded PaymentStatus = "refunded"
+	// PaymentSplit means part went back to the buyer and the rest to the
+	// seller, after a dispute was ruled a partial refund.
+	PaymentSplit PaymentStatus = "split"
+)
+
+// LedgerAccount is one side of a ledger entry. Buyer and seller accounts
+// belong to a user, escrow and the platform fee to the platform.
+type LedgerAccount string
+
+const (
+	AccountBuyer       LedgerAccount = "buyer"
+	AccountEscrow      LedgerAccount = "escrow"
+	AccountSeller      LedgerAccount = "seller"
+	AccountPlatformFee LedgerAccount = "platform_fee"
+)
+
+type LedgerPosting string
+
+const (
+	PostingHold    LedgerPosting = "hold"
+	PostingRelease LedgerPosting = "release"
+	PostingRefund  LedgerPosting = "refund"
+)
+
+// Payment is the money of one order. Amounts are in minor units of Currency;
+// Fee is what the platform keeps when the payment is released to the seller.
+// RefundAmount is set by a dispute ruled a partial refund: that much goes
+// back to the buyer, and the fee shrinks with the part the seller gets.
+type Payment struct {
+	ID           uuid.UUID      `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrderID      uuid.UUID      `gorm:"column:order_id;type:uuid;not null;uniqueIndex" json:"order_id"`
+	BuyerID      uuid.UUID      `gorm:"column:buyer_id;type:uuid;not null" json:"buyer_id"`
+	SellerID     uuid.UUID      `gorm:"column:seller_id;type:uuid;not null" json:"seller_id"`
+	Provider     string         `gorm:"column:provider;type:text;not null" json:"provider"`
+	Reference    string         `gorm:"column:reference;type:text;not null" json:"reference"`
+	Currency     string         `gorm:"column:currency;type:text;not null" json:"currency"`
+	Amount       int64          `gorm:"column:amount;not null" json:"amount"`
+	Fee          int64          `gorm:"column:fee;not null" json:"fee"`
+	RefundAmount int64          `gorm:"column:refund_amount;not null;default:0" json:"refund_amount,omitempty"`
+	Status       PaymentStatus  `gorm:"column:status;type:text;not null;default:'held'" json:"status"`
+	CreatedAt    time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	SettledAt    *time.Time     `gorm:"column:settled_at" json:"settled_at,omitempty"`
+	Entries      []*LedgerEntry `gorm:"foreignKey:PaymentID" json:"entries,omitempty"`
+}
+
+func (Payment) TableName() string { return "Payment" }
+
+// LedgerEntry is one line of a double-entry posting. Credits are positive
+// and debits negative, and the entries of a posting sum to zero.
+type LedgerEntry struct {
+	ID        uuid.UUID     `gorm:"column:id;type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	PaymentID uuid.UUID     `gorm:"column:payment_id;type:uuid;not null;index" json:"payment_id"`
+	PostingID uuid.UUID     `gorm:"column:posting_id;type:uuid;not null" json:"posting_id"`
+	Posting   LedgerPosting `gorm:"column:posting;type:text;not null" json:"posting"`
+	Account   LedgerAccount `gorm:"column:account;type:text;not null" json:"account"`
+	OwnerID   *uuid.UUID    `gorm:"column:owner_id;type:uuid" json:"owner_id,omitempty"`
+	Amount    int64         `gorm:"column:amount;not null" json:"amount"`
+	Currency  string        `gorm:"column:currency;type:text;not null" json:"currency"`
+	CreatedAt time.Time     `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (LedgerEntry) TableName() string { return "LedgerEntry" }
+
+// PaymentCharge asks the provider to take an order's amount from the buyer.
+type PaymentCharge struct {
+	OrderID  uuid.UUID
+	BuyerID  uuid.UUID
+	Amount   int64
+	Currency string
+}
+
+// PaymentTransfer asks the provider to move part of a charge out of escrow,
+// to the seller or back to the buyer. Key is stable across retries of the
+// same transfer.
+type PaymentTransfer struct {
+	Key       string
+	Reference string
+	UserID    uuid.UUID
+	Amount    int64
+	Currency  string
+}
+
+// MinorUnits converts a price to cents.
+func MinorUnits(price float64) int64 {
+	return int64(math.Round(price * 100))
+}
@@ -0,0 +1,14 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Presence is whether a user has any chat socket open, and when they were
+// last seen connected.
+type Presence struct {
+	UserID   uuid.UUID  `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
@@ -0,0 +1,25 @@
+package model
+
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// ReviewRequest is the buyer's review of a completed order.
+type ReviewRequest struct {
+	Rating  int    `json:"rating"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// ReviewReplyRequest is the seller's public answer to a review.
+type ReviewReplyRequest struct {
+	Reply string `json:"reply"`
+}
+
+type ReviewPage struct {
+	Reviews []*Review `json:"reviews"`
+	Total   int64     `json:"total"`
+	Limit   int       `json:"limit"`
+	Offset  int       `json:"offset"`
+}
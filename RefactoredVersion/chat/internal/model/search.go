@@ -0,0 +1,13 @@
+package model
+
+import "github.com/google/uuid"
+
+// SearchHit is a message matching a chat search. Snippet is HTML escaped
+// with the matched terms wrapped in <mark>.
+type SearchHit struct {
+	ChatRoomID uuid.UUID `json:"chat_room_id"`
+	MessageID  string    `json:"message_id"`
+	From       uuid.UUID `json:"from"`
+	Timestamp  int64     `json:"timestamp"`
+	Snippet    string    `json:"snippet"`
+}
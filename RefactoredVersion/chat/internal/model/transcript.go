@@ -0,0 +1,61 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Transcript is the exported conversation of an order. Its JSON encoding is
+// what the export signature covers.
+type Transcript struct {
+	ChatRoomID   uuid.UUID           `json:"chat_room_id"`
+	Order        TranscriptOrder     `json:"order"`
+	Participants []RoomMember        `json:"participants"`
+	Messages     []TranscriptMessage `json:"messages"`
+	ExportedBy   uuid.UUID           `json:"exported_by"`
+	ExportedAt   time.Time           `json:"exported_at"`
+}
+
+type TranscriptOrder struct {
+	ID            uuid.UUID   `json:"id"`
+	OrderNumber   string      `json:"order_number"`
+	Status        OrderStatus `json:"status"`
+	Price         float64     `json:"price"`
+	PaymentMethod string      `json:"payment_method"`
+	PackageID     uuid.UUID   `json:"package_id"`
+	BuyerID       uuid.UUID   `json:"buyer_id"`
+	SellerID      uuid.UUID   `json:"seller_id"`
+	Requirements  *string     `json:"requirements,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	DueDate       *time.Time  `json:"due_date,omitempty"`
+	CompletedAt   *time.Time  `json:"completed_at,omitempty"`
+}
+
+type TranscriptMessage struct {
+	ID         string                `json:"id"`
+	From       uuid.UUID             `json:"from"`
+	To         uuid.UUID             `json:"to"`
+	SentAt     time.Time             `json:"sent_at"`
+	Timestamp  int64                 `json:"timestamp"`
+	Body       string                `json:"body"`
+	Status     MessageStatus         `json:"status"`
+	EditedAt   int64                 `json:"edited_at,omitempty"`
+	Deleted    bool                  `json:"deleted,omitempty"`
+	Attachment *TranscriptAttachment `json:"attachment,omitempty"`
+}
+
+type TranscriptAttachment struct {
+	ID          uuid.UUID `json:"id"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	URL         string    `json:"url,omitempty"`
+}
+
+// SignedTranscript carries the transcript with an HMAC over its JSON
+// encoding, so a stored export can be checked against tampering.
+type SignedTranscript struct {
+	Transcript *Transcript `json:"transcript"`
+	Algorithm  string      `json:"algorithm"`
+	Signature  string      `json:"signature"`
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type AttachmentRepo struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewAttachmentRepo(log *logrus.Logger, db *gorm.DB) *AttachmentRepo {
+	return &AttachmentRepo{
+		log: log,
+		db:  db,
+	}
+}
+
+func (r AttachmentRepo) Create(ctx context.Context, attachment *model.Attachment) error {
+	if err := r.db.WithContext(ctx).Create(attachment).Error; err != nil {
+		r.log.WithField("chat_room_id", attachment.ChatRoomID).Errorf("Failed to create attachment: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r AttachmentRepo) GetById(ctx context.Context, id uuid.UUID) (*model.Attachment, error) {
+	var attachment model.Attachment
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.Attachment{}).
+		Where("id = ?", id).
+		First(&attachment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (r AttachmentRepo) GetByIds(ctx context.Context, ids []uuid.UUID) ([]*model.Attachment, error) {
+	var attachments []*model.Attachment
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.Attachment{}).
+		Where("id IN ?", ids).
+		Find(&attachments).Error
+	if err != nil {
+		r.log.Errorf("Failed to fetch attachments: %v", err)
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (r AttachmentRepo) MarkUploaded(ctx context.Context, id uuid.UUID, size int64) error {
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.Attachment{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status": model.AttachmentUploaded,
+			"size":   size,
+		}).Error
+	if err != nil {
+		r.log.WithField("attachment_id", id).Errorf("Failed to mark attachment uploaded: %v", err)
+		return err
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ChatRepository struct {
@@ -28,7 +29,9 @@ func (r ChatRepository) GetAllChatRoomByUserId(ctx context.Context, userId uuid.
 	err := r.db.
 		WithContext(ctx).
 		Model(&model.ChatRoom{}).
+		Preload("Members").
 		Where("participant_one = ? OR participant_two = ?", userId, userId).
+		Or("chat_room_id IN (?)", r.db.Model(&model.RoomMember{}).Select("chat_room_id").Where("user_id = ?", userId)).
 		Find(&chatRooms).Error
 
 	if err != nil {
@@ -42,3 +45,61 @@ func (r ChatRepository) GetAllChatRoomByUserId(ctx context.Context, userId uuid.
 
 	return chatRooms, nil
 }
+
+func (r ChatRepository) GetChatRoomById(ctx context.Context, roomId uuid.UUID) (*model.ChatRoom, error) {
+	var chatRoom model.ChatRoom
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.ChatRoom{}).
+		Preload("Members").
+		Where("chat_room_id = ?", roomId).
+		First(&chatRoom).Error
+	if err != nil {
+		r.log.WithField("chat_room_id", roomId).Errorf("Failed to fetch chat room: %v", err)
+		return nil, err
+	}
+	return &chatRoom, nil
+}
+
+func (r ChatRepository) GetChatRoomByOrderId(ctx context.Context, orderId uuid.UUID) (*model.ChatRoom, error) {
+	var chatRoom model.ChatRoom
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.ChatRoom{}).
+		Preload("Members").
+		Where("order_id = ?", orderId).
+		First(&chatRoom).Error
+	if err != nil {
+		r.log.WithField("order_id", orderId).Errorf("Failed to fetch chat room: %v", err)
+		return nil, err
+	}
+	return &chatRoom, nil
+}
+
+// SaveMember adds the member, or changes the role of an existing one.
+func (r ChatRepository) SaveMember(ctx context.Context, member *model.RoomMember) error {
+	err := r.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "chat_room_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role", "added_by"}),
+		}).
+		Create(member).Error
+	if err != nil {
+		r.log.WithField("chat_room_id", member.ChatRoomID).Errorf("Failed to save room member: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r ChatRepository) DeleteMember(ctx context.Context, roomId, userId uuid.UUID) (bool, error) {
+	result := r.db.
+		WithContext(ctx).
+		Where("chat_room_id = ? AND user_id = ?", roomId, userId).
+		Delete(&model.RoomMember{})
+	if result.Error != nil {
+		r.log.WithField("chat_room_id", roomId).Errorf("Failed to delete room member: %v", result.Error)
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
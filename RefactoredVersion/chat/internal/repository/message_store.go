@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageChanged   = errors.New("message was changed by someone else")
+	ErrDuplicateMessage = errors.New("room already has a message with this id")
+)
+
+// MessageStore persists chat messages. A message is addressed by its room,
+// the server timestamp (unix millis) and the client generated id; backends
+// use whichever of timestamp and id make up their key.
+type MessageStore interface {
+	// Save stores a new message. It returns ErrDuplicateMessage when the room
+	// already has a message with the id, as far as the backend can tell: the
+	// DynamoDB table is keyed by timestamp and does not look at ids.
+	Save(ctx context.Context, msg *model.Message) error
+	// Get loads a single message, or returns ErrMessageNotFound.
+	Get(ctx context.Context, roomID uuid.UUID, timestamp int64, id string) (*model.Message, error)
+	// FindByID looks up a message of the room by its client generated id
+	// among the ones stored at or after since, so that a resent frame is
+	// recognised. It returns ErrMessageNotFound when there is none.
+	FindByID(ctx context.Context, roomID uuid.UUID, id string, since int64) (*model.Message, error)
+	// Update writes the body, edits, reactions and delete marker of msg, for
+	// edits, deletes and reactions, and moves msg to the next version. The
+	// status is left alone. It returns ErrMessageChanged when the stored
+	// message is no longer at msg.Version and ErrMessageNotFound when it is
+	// gone.
+	Update(ctx context.Context, msg *model.Message) error
+	// ListByRoom returns up to limit messages after the cursor before, newest
+	// first, ordered by timestamp and then id. A zero before starts from the
+	// latest message.
+	ListByRoom(ctx context.Context, roomID uuid.UUID, before model.MessageCursor, limit int) ([]*model.Message, error)
+	// MarkRead moves a message to the delivered or read status. It never moves
+	// a read message back to delivered and returns ErrMessageNotFound when
+	// there is nothing to update.
+	MarkRead(ctx context.Context, roomID uuid.UUID, timestamp int64, id string, status model.MessageStatus) error
+	Delete(ctx context.Context, roomID uuid.UUID, timestamp int64, id string) error
+}
+
+// NewMessageStore picks the backend from chat.store: dynamodb (default),
+// postgres or memory.
+func NewMessageStore(log *logrus.Logger, v *viper.Viper, dynamoClient *dynamodb.Client, db *gorm.DB) MessageStore {
+	switch backend := v.GetString("chat.store"); backend {
+	case "", "dynamodb":
+		return NewDynamoMessageStore(log, dynamoClient, v.GetString("aws.dynamodb.tableName"))
+	case "postgres":
+		return NewPostgresMessageStore(log, db)
+	case "memory":
+		return NewMemoryMessageStore()
+	default:
+		log.Fatalf("Unknown chat.store backend %q", backend)
+		return nil
+	}
+}
@@ -0,0 +1,399 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultChatMessagesTable = "ChatMessages"
+	// maxSaveAttempts bounds how many milliseconds Save moves a message on
+	// when its timestamp is taken.
+	maxSaveAttempts = 16
+)
+
+// updatedMessageAttributes are the attributes Update writes; the ones msg
+// leaves empty are removed from the item.
+var updatedMessageAttributes = []string{"body", "edited_at", "deleted_at", "edits", "reactions"}
+
+// DynamoMessageStore keeps messages in a table keyed by chat_room_id and
+// time_stamp.
+type DynamoMessageStore struct {
+	log          *logrus.Logger
+	dynamoClient *dynamodb.Client
+	tableName    string
+}
+
+func NewDynamoMessageStore(log *logrus.Logger, dynamoClient *dynamodb.Client, tableName string) *DynamoMessageStore {
+	if tableName == "" {
+		tableName = defaultChatMessagesTable
+	}
+	return &DynamoMessageStore{
+		log:          log,
+		dynamoClient: dynamoClient,
+		tableName:    tableName,
+	}
+}
+
+// Save never overwrites a stored message. When another message of the room
+// already has the timestamp, msg moves to the next free millisecond, so the
+// caller reads the stored timestamp back from msg.
+func (r DynamoMessageStore) Save(ctx context.Context, msg *model.Message) error {
+	for attempt := 0; ; attempt++ {
+		_, err := r.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(r.tableName),
+			Item:                itemFromMessage(msg),
+			ConditionExpression: aws.String("attribute_not_exists(time_stamp)"),
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) && attempt < maxSaveAttempts {
+			msg.Timestamp++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to put message into DynamoDB: %w", err)
+		}
+		return nil
+	}
+}
+
+func (r DynamoMessageStore) Get(ctx context.Context, roomID uuid.UUID, timestamp int64, id string) (*model.Message, error) {
+	out, err := r.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(r.tableName),
+		Key:            r.key(roomID, timestamp),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message from DynamoDB: %w", err)
+	}
+	if out.Item == nil || stringAttr(out.Item, "message_id") != id {
+		return nil, ErrMessageNotFound
+	}
+	return messageFromItem(out.Item)
+}
+
+// FindByID queries the room from since on and filters on the id, so its cost
+// grows with the messages of the room in that window.
+func (r DynamoMessageStore) FindByID(ctx context.Context, roomID uuid.UUID, id string, since int64) (*model.Message, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("chat_room_id = :room AND time_stamp >= :since"),
+		FilterExpression:       aws.String("message_id = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":room":  &types.AttributeValueMemberS{Value: roomID.String()},
+			":since": &types.AttributeValueMemberN{Value: strconv.FormatInt(since, 10)},
+			":id":    &types.AttributeValueMemberS{Value: id},
+		},
+		ScanIndexForward: aws.Bool(false),
+		ConsistentRead:   aws.Bool(true),
+	}
+	for {
+		out, err := r.dynamoClient.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up message in DynamoDB: %w", err)
+		}
+		if len(out.Items) > 0 {
+			return messageFromItem(out.Items[0])
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil, ErrMessageNotFound
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// Update sets the changeable attributes in place, so a status written by
+// MarkRead in between is kept. Items stored before versions were added have
+// no version attribute and count as version 0.
+func (r DynamoMessageStore) Update(ctx context.Context, msg *model.Message) error {
+	item := itemFromMessage(msg)
+	names := map[string]string{"#version": "version"}
+	values := map[string]types.AttributeValue{
+		":id":      &types.AttributeValueMemberS{Value: msg.ID},
+		":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(msg.Version, 10)},
+		":next":    &types.AttributeValueMemberN{Value: strconv.FormatInt(msg.Version+1, 10)},
+	}
+	set := []string{"#version = :next"}
+	var remove []string
+	for _, name := range updatedMessageAttributes {
+		names["#"+name] = name
+		if value, ok := item[name]; ok {
+			values[":"+name] = value
+			set = append(set, "#"+name+" = :"+name)
+		} else {
+			remove = append(remove, "#"+name)
+		}
+	}
+	expression := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		expression += " REMOVE " + strings.Join(remove, ", ")
+	}
+
+	_, err := r.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                           aws.String(r.tableName),
+		Key:                                 r.key(msg.ChatRoomId, msg.Timestamp),
+		UpdateExpression:                    aws.String(expression),
+		ConditionExpression:                 aws.String("message_id = :id AND (attribute_not_exists(#version) OR #version = :version)"),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		if stringAttr(conditionFailed.Item, "message_id") != msg.ID {
+			return ErrMessageNotFound
+		}
+		return ErrMessageChanged
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update message in DynamoDB: %w", err)
+	}
+	msg.Version++
+	return nil
+}
+
+// ListByRoom only needs the timestamp of the cursor, since Save keeps the
+// timestamps of a room unique.
+func (r DynamoMessageStore) ListByRoom(ctx context.Context, roomID uuid.UUID, before model.MessageCursor, limit int) ([]*model.Message, error) {
+	keyCondition := "chat_room_id = :room"
+	values := map[string]types.AttributeValue{
+		":room": &types.AttributeValueMemberS{Value: roomID.String()},
+	}
+	if before.Timestamp > 0 {
+		keyCondition += " AND time_stamp < :before"
+		values[":before"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(before.Timestamp, 10)}
+	}
+
+	out, err := r.dynamoClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(r.tableName),
+		KeyConditionExpression:    aws.String(keyCondition),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		r.log.WithField("chat_room_id", roomID).Errorf("Failed to query messages: %v", err)
+		return nil, fmt.Errorf("failed to query messages from DynamoDB: %w", err)
+	}
+
+	messages := make([]*model.Message, 0, len(out.Items))
+	for _, item := range out.Items {
+		msg, err := messageFromItem(item)
+		if err != nil {
+			r.log.WithField("chat_room_id", roomID).Warnf("Skipping malformed message: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (r DynamoMessageStore) MarkRead(ctx context.Context, roomID uuid.UUID, timestamp int64, id string, status model.MessageStatus) error {
+	condition := "message_id = :id"
+	values := map[string]types.AttributeValue{
+		":id":     &types.AttributeValueMemberS{Value: id},
+		":status": &types.AttributeValueMemberS{Value: string(status)},
+	}
+	if status == model.StatusDelivered {
+		// never move a read message back to delivered
+		condition += " AND #status <> :read"
+		values[":read"] = &types.AttributeValueMemberS{Value: string(model.StatusRead)}
+	}
+
+	_, err := r.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(r.tableName),
+		Key:                       r.key(roomID, timestamp),
+		UpdateExpression:          aws.String("SET #status = :status"),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#status": "status"},
+		ExpressionAttributeValues: values,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update message status in DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func (r DynamoMessageStore) Delete(ctx context.Context, roomID uuid.UUID, timestamp int64, id string) error {
+	_, err := r.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(r.tableName),
+		Key:                 r.key(roomID, timestamp),
+		ConditionExpression: aws.String("message_id = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete message from DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func (r DynamoMessageStore) key(roomID uuid.UUID, timestamp int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chat_room_id": &types.AttributeValueMemberS{Value: roomID.String()},
+		"time_stamp":   &types.AttributeValueMemberN{Value: strconv.FormatInt(timestamp, 10)},
+	}
+}
+
+func itemFromMessage(msg *model.Message) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"chat_room_id": &types.AttributeValueMemberS{Value: msg.ChatRoomId.String()},
+		"time_stamp":   &types.AttributeValueMemberN{Value: strconv.FormatInt(msg.Timestamp, 10)},
+		"to":           &types.AttributeValueMemberS{Value: msg.To.String()},
+		"from":         &types.AttributeValueMemberS{Value: msg.From.String()},
+		"body":         &types.AttributeValueMemberS{Value: msg.Body},
+		"message_id":   &types.AttributeValueMemberS{Value: msg.ID},
+		"status":       &types.AttributeValueMemberS{Value: string(msg.Status)},
+	}
+
+	if msg.ImageUrl != "" {
+		item["image_url"] = &types.AttributeValueMemberS{Value: msg.ImageUrl}
+	}
+	if msg.AttachmentID != nil {
+		item["attachment_id"] = &types.AttributeValueMemberS{Value: msg.AttachmentID.String()}
+	}
+	if msg.EditedAt != 0 {
+		item["edited_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(msg.EditedAt, 10)}
+	}
+	if msg.DeletedAt != 0 {
+		item["deleted_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(msg.DeletedAt, 10)}
+	}
+	if msg.Version != 0 {
+		item["version"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(msg.Version, 10)}
+	}
+	if len(msg.Edits) > 0 {
+		edits := make([]types.AttributeValue, 0, len(msg.Edits))
+		for _, edit := range msg.Edits {
+			edits = append(edits, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"body":      &types.AttributeValueMemberS{Value: edit.Body},
+				"edited_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(edit.EditedAt, 10)},
+			}})
+		}
+		item["edits"] = &types.AttributeValueMemberL{Value: edits}
+	}
+	// string sets cannot be empty, so emojis nobody reacts with any more are left out
+	reactions := make(map[string]types.AttributeValue, len(msg.Reactions))
+	for emoji, users := range msg.Reactions {
+		if len(users) == 0 {
+			continue
+		}
+		ids := make([]string, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.String())
+		}
+		reactions[emoji] = &types.AttributeValueMemberSS{Value: ids}
+	}
+	if len(reactions) > 0 {
+		item["reactions"] = &types.AttributeValueMemberM{Value: reactions}
+	}
+	return item
+}
+
+func messageFromItem(item map[string]types.AttributeValue) (*model.Message, error) {
+	msg := &model.Message{
+		ID:       stringAttr(item, "message_id"),
+		Body:     stringAttr(item, "body"),
+		ImageUrl: stringAttr(item, "image_url"),
+		Status:   model.MessageStatus(stringAttr(item, "status")),
+	}
+
+	var err error
+	if msg.ChatRoomId, err = uuid.Parse(stringAttr(item, "chat_room_id")); err != nil {
+		return nil, fmt.Errorf("chat_room_id: %w", err)
+	}
+	if msg.From, err = uuid.Parse(stringAttr(item, "from")); err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	// "to" is the nil uuid for room broadcasts and may be absent on old items
+	if to := stringAttr(item, "to"); to != "" {
+		if msg.To, err = uuid.Parse(to); err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+	}
+	if attachment := stringAttr(item, "attachment_id"); attachment != "" {
+		attachmentID, err := uuid.Parse(attachment)
+		if err != nil {
+			return nil, fmt.Errorf("attachment_id: %w", err)
+		}
+		msg.AttachmentID = &attachmentID
+	}
+	ts, ok := item["time_stamp"].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, fmt.Errorf("time_stamp is missing")
+	}
+	if msg.Timestamp, err = strconv.ParseInt(ts.Value, 10, 64); err != nil {
+		return nil, fmt.Errorf("time_stamp: %w", err)
+	}
+	if msg.EditedAt, err = numberAttr(item, "edited_at"); err != nil {
+		return nil, fmt.Errorf("edited_at: %w", err)
+	}
+	if msg.DeletedAt, err = numberAttr(item, "deleted_at"); err != nil {
+		return nil, fmt.Errorf("deleted_at: %w", err)
+	}
+	if msg.Version, err = numberAttr(item, "version"); err != nil {
+		return nil, fmt.Errorf("version: %w", err)
+	}
+	if edits, ok := item["edits"].(*types.AttributeValueMemberL); ok {
+		for _, value := range edits.Value {
+			edit, ok := value.(*types.AttributeValueMemberM)
+			if !ok {
+				continue
+			}
+			editedAt, err := numberAttr(edit.Value, "edited_at")
+			if err != nil {
+				return nil, fmt.Errorf("edits: %w", err)
+			}
+			msg.Edits = append(msg.Edits, model.MessageEdit{Body: stringAttr(edit.Value, "body"), EditedAt: editedAt})
+		}
+	}
+	if reactions, ok := item["reactions"].(*types.AttributeValueMemberM); ok {
+		msg.Reactions = make(map[string][]uuid.UUID, len(reactions.Value))
+		for emoji, value := range reactions.Value {
+			users, ok := value.(*types.AttributeValueMemberSS)
+			if !ok {
+				continue
+			}
+			for _, user := range users.Value {
+				userID, err := uuid.Parse(user)
+				if err != nil {
+					return nil, fmt.Errorf("reactions: %w", err)
+				}
+				msg.Reactions[emoji] = append(msg.Reactions[emoji], userID)
+			}
+		}
+	}
+	return msg, nil
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func numberAttr(item map[string]types.AttributeValue, name string) (int64, error) {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(v.Value, 10, 64)
+}
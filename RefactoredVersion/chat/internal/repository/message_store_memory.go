@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+)
+
+// MemoryMessageStore keeps messages in process. It is meant for local runs
+// and tests; nothing survives a restart.
+type MemoryMessageStore struct {
+	mu    sync.RWMutex
+	rooms map[uuid.UUID][]*model.Message
+}
+
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{rooms: make(map[uuid.UUID][]*model.Message)}
+}
+
+// Save rejects a second message with the same id in the room, like the
+// primary key of the Postgres table.
+func (s *MemoryMessageStore) Save(_ context.Context, msg *model.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.rooms[msg.ChatRoomId] {
+		if stored.ID == msg.ID {
+			return ErrDuplicateMessage
+		}
+	}
+
+	messages := append(s.rooms[msg.ChatRoomId], cloneMessage(msg))
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Timestamp != messages[j].Timestamp {
+			return messages[i].Timestamp < messages[j].Timestamp
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	s.rooms[msg.ChatRoomId] = messages
+	return nil
+}
+
+func (s *MemoryMessageStore) ListByRoom(_ context.Context, roomID uuid.UUID, before model.MessageCursor, limit int) ([]*model.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := s.rooms[roomID]
+	page := make([]*model.Message, 0, limit)
+	for i := len(messages) - 1; i >= 0 && len(page) < limit; i-- {
+		if !before.After(messages[i]) {
+			continue
+		}
+		page = append(page, cloneMessage(messages[i]))
+	}
+	return page, nil
+}
+
+func (s *MemoryMessageStore) Get(_ context.Context, roomID uuid.UUID, timestamp int64, id string) (*model.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msg := s.find(roomID, timestamp, id)
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+	return cloneMessage(msg), nil
+}
+
+func (s *MemoryMessageStore) FindByID(_ context.Context, roomID uuid.UUID, id string, since int64) (*model.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, msg := range s.rooms[roomID] {
+		if msg.ID == id && msg.Timestamp >= since {
+			return cloneMessage(msg), nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+func (s *MemoryMessageStore) Update(_ context.Context, msg *model.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.find(msg.ChatRoomId, msg.Timestamp, msg.ID)
+	if stored == nil {
+		return ErrMessageNotFound
+	}
+	if stored.Version != msg.Version {
+		return ErrMessageChanged
+	}
+	msg.Version++
+	status := stored.Status
+	*stored = *cloneMessage(msg)
+	stored.Status = status
+	return nil
+}
+
+func (s *MemoryMessageStore) MarkRead(_ context.Context, roomID uuid.UUID, timestamp int64, id string, status model.MessageStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.find(roomID, timestamp, id)
+	if msg == nil || (status == model.StatusDelivered && msg.Status == model.StatusRead) {
+		return ErrMessageNotFound
+	}
+	msg.Status = status
+	return nil
+}
+
+func (s *MemoryMessageStore) Delete(_ context.Context, roomID uuid.UUID, timestamp int64, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := s.rooms[roomID]
+	for i, msg := range messages {
+		if msg.Timestamp == timestamp && msg.ID == id {
+			s.rooms[roomID] = append(messages[:i], messages[i+1:]...)
+			return nil
+		}
+	}
+	return ErrMessageNotFound
+}
+
+func (s *MemoryMessageStore) find(roomID uuid.UUID, timestamp int64, id string) *model.Message {
+	for _, msg := range s.rooms[roomID] {
+		if msg.Timestamp == timestamp && msg.ID == id {
+			return msg
+		}
+	}
+	return nil
+}
+
+// cloneMessage copies a message deep enough that callers cannot change the
+// stored edits and reactions behind the lock.
+func cloneMessage(msg *model.Message) *model.Message {
+	clone := *msg
+	clone.Edits = append([]model.MessageEdit(nil), msg.Edits...)
+	if msg.Reactions != nil {
+		clone.Reactions = make(map[string][]uuid.UUID, len(msg.Reactions))
+		for emoji, users := range msg.Reactions {
+			clone.Reactions[emoji] = append([]uuid.UUID(nil), users...)
+		}
+	}
+	return &clone
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func saveMessages(t *testing.T, store MessageStore, roomID uuid.UUID, timestamps ...int64) []*model.Message {
+	t.Helper()
+	messages := make([]*model.Message, 0, len(timestamps))
+	for _, ts := range timestamps {
+		msg := &model.Message{
+			ID:         uuid.NewString(),
+			ChatRoomId: roomID,
+			From:       uuid.New(),
+			Body:       "hello",
+			Timestamp:  ts,
+			Status:     model.StatusSent,
+		}
+		if err := store.Save(context.Background(), msg); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestMemoryMessageStoreListByRoom(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	saveMessages(t, store, roomID, 30, 10, 20, 40)
+	saveMessages(t, store, uuid.New(), 50)
+
+	page, err := store.ListByRoom(context.Background(), roomID, model.MessageCursor{}, 2)
+	if err != nil {
+		t.Fatalf("ListByRoom: %v", err)
+	}
+	if len(page) != 2 || page[0].Timestamp != 40 || page[1].Timestamp != 30 {
+		t.Fatalf("first page = %v, want timestamps 40, 30", timestamps(page))
+	}
+
+	page, err = store.ListByRoom(context.Background(), roomID, page[1].Cursor(), 10)
+	if err != nil {
+		t.Fatalf("ListByRoom: %v", err)
+	}
+	if len(page) != 2 || page[0].Timestamp != 20 || page[1].Timestamp != 10 {
+		t.Fatalf("second page = %v, want timestamps 20, 10", timestamps(page))
+	}
+}
+
+func TestMemoryMessageStoreListByRoomSameMillisecond(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	saved := saveMessages(t, store, roomID, 10, 20, 20, 20, 30)
+
+	seen := make(map[string]bool)
+	var before model.MessageCursor
+	for pages := 0; ; pages++ {
+		if pages > len(saved) {
+			t.Fatal("paging does not end")
+		}
+		page, err := store.ListByRoom(context.Background(), roomID, before, 2)
+		if err != nil {
+			t.Fatalf("ListByRoom: %v", err)
+		}
+		for _, msg := range page {
+			if seen[msg.ID] {
+				t.Fatalf("message %s at %d returned twice", msg.ID, msg.Timestamp)
+			}
+			seen[msg.ID] = true
+		}
+		if len(page) < 2 {
+			break
+		}
+		before = page[len(page)-1].Cursor()
+	}
+	for _, msg := range saved {
+		if !seen[msg.ID] {
+			t.Errorf("message %s at %d was skipped", msg.ID, msg.Timestamp)
+		}
+	}
+}
+
+func TestMemoryMessageStoreSaveDuplicate(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	msg := saveMessages(t, store, roomID, 100)[0]
+
+	again := *msg
+	again.Timestamp = 200
+	if err := store.Save(context.Background(), &again); !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("Save of a used id = %v, want ErrDuplicateMessage", err)
+	}
+	// ids only need to be unique within a room
+	again.ChatRoomId = uuid.New()
+	if err := store.Save(context.Background(), &again); err != nil {
+		t.Fatalf("Save in another room: %v", err)
+	}
+}
+
+func TestMemoryMessageStoreGetAndFindByID(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	msg := saveMessages(t, store, roomID, 100)[0]
+
+	got, err := store.Get(context.Background(), roomID, msg.Timestamp, msg.ID)
+	if err != nil || got.ID != msg.ID {
+		t.Fatalf("Get = %v, %v, want %s", got, err, msg.ID)
+	}
+	if _, err := store.Get(context.Background(), roomID, msg.Timestamp, "other"); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("Get of an unknown id = %v, want ErrMessageNotFound", err)
+	}
+
+	got, err = store.FindByID(context.Background(), roomID, msg.ID, 50)
+	if err != nil || got.Timestamp != msg.Timestamp {
+		t.Fatalf("FindByID = %v, %v, want timestamp %d", got, err, msg.Timestamp)
+	}
+	if _, err := store.FindByID(context.Background(), roomID, msg.ID, 101); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("FindByID before the window = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestMemoryMessageStoreMarkRead(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	msg := saveMessages(t, store, roomID, 100)[0]
+	ctx := context.Background()
+
+	if err := store.MarkRead(ctx, roomID, msg.Timestamp, msg.ID, model.StatusRead); err != nil {
+		t.Fatalf("MarkRead read: %v", err)
+	}
+	if err := store.MarkRead(ctx, roomID, msg.Timestamp, msg.ID, model.StatusDelivered); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("MarkRead delivered after read = %v, want ErrMessageNotFound", err)
+	}
+	got, err := store.Get(ctx, roomID, msg.Timestamp, msg.ID)
+	if err != nil || got.Status != model.StatusRead {
+		t.Fatalf("status = %v, %v, want read", got, err)
+	}
+}
+
+func TestMemoryMessageStoreUpdateAndDelete(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	msg := saveMessages(t, store, roomID, 100)[0]
+	ctx := context.Background()
+
+	msg.Body = "edited"
+	if err := store.Update(ctx, msg); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	// changing the caller's copy must not reach the stored message
+	msg.Body = "changed afterwards"
+	got, err := store.Get(ctx, roomID, msg.Timestamp, msg.ID)
+	if err != nil || got.Body != "edited" {
+		t.Fatalf("body = %v, %v, want edited", got, err)
+	}
+
+	if err := store.Delete(ctx, roomID, msg.Timestamp, msg.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, roomID, msg.Timestamp, msg.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("second Delete = %v, want ErrMessageNotFound", err)
+	}
+	if err := store.Update(ctx, msg); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("Update of a deleted message = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func timestamps(messages []*model.Message) []int64 {
+	out := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		out = append(out, msg.Timestamp)
+	}
+	return out
+}
+
+func TestMemoryMessageStoreUpdateConflict(t *testing.T) {
+	store := NewMemoryMessageStore()
+	roomID := uuid.New()
+	msg := saveMessages(t, store, roomID, 100)[0]
+	ctx := context.Background()
+
+	stale, err := store.Get(ctx, roomID, msg.Timestamp, msg.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	msg.Body = "first"
+	if err := store.Update(ctx, msg); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if msg.Version != 1 {
+		t.Fatalf("version after Update = %d, want 1", msg.Version)
+	}
+	stale.Body = "second"
+	if err := store.Update(ctx, stale); !errors.Is(err, ErrMessageChanged) {
+		t.Fatalf("Update of a stale copy = %v, want ErrMessageChanged", err)
+	}
+
+	// Update leaves the status to MarkRead
+	if err := store.MarkRead(ctx, roomID, msg.Timestamp, msg.ID, model.StatusRead); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	msg.Body = "third"
+	if err := store.Update(ctx, msg); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got, err := store.Get(ctx, roomID, msg.Timestamp, msg.ID)
+	if err != nil || got.Body != "third" || got.Status != model.StatusRead {
+		t.Fatalf("message = %v, %v, want body third and status read", got, err)
+	}
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresMessageStore keeps messages in the "Chat" table, for setups that
+// run without AWS.
+type PostgresMessageStore struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewPostgresMessageStore(log *logrus.Logger, db *gorm.DB) *PostgresMessageStore {
+	return &PostgresMessageStore{
+		log: log,
+		db:  db,
+	}
+}
+
+func (s *PostgresMessageStore) Save(ctx context.Context, msg *model.Message) error {
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(msg)
+	if result.Error != nil {
+		s.log.WithField("chat_room_id", msg.ChatRoomId).Errorf("Failed to save message: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicateMessage
+	}
+	return nil
+}
+
+func (s *PostgresMessageStore) Get(ctx context.Context, roomID uuid.UUID, timestamp int64, id string) (*model.Message, error) {
+	var msg model.Message
+	err := s.db.
+		WithContext(ctx).
+		Where("chat_room_id = ? AND message_id = ?", roomID, id).
+		First(&msg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		s.log.WithField("chat_room_id", roomID).Errorf("Failed to fetch message: %v", err)
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// FindByID ignores since, as the id is part of the primary key.
+func (s *PostgresMessageStore) FindByID(ctx context.Context, roomID uuid.UUID, id string, _ int64) (*model.Message, error) {
+	return s.Get(ctx, roomID, 0, id)
+}
+
+func (s *PostgresMessageStore) Update(ctx context.Context, msg *model.Message) error {
+	next := *msg
+	next.Version++
+	result := s.db.
+		WithContext(ctx).
+		Model(&model.Message{}).
+		Where("chat_room_id = ? AND message_id = ? AND version = ?", msg.ChatRoomId, msg.ID, msg.Version).
+		Select("body", "edited_at", "deleted_at", "edits", "reactions", "version").
+		Updates(&next)
+	if result.Error != nil {
+		s.log.WithField("chat_room_id", msg.ChatRoomId).Errorf("Failed to update message: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := s.Get(ctx, msg.ChatRoomId, msg.Timestamp, msg.ID); err != nil {
+			return err
+		}
+		return ErrMessageChanged
+	}
+	msg.Version = next.Version
+	return nil
+}
+
+// ListByRoom pages by (time_stamp, message_id), as timestamps are not unique
+// within a room here.
+func (s *PostgresMessageStore) ListByRoom(ctx context.Context, roomID uuid.UUID, before model.MessageCursor, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.Message{}).
+		Where("chat_room_id = ?", roomID)
+	switch {
+	case before.Timestamp > 0 && before.ID != "":
+		query = query.Where("(time_stamp, message_id) < (?, ?)", before.Timestamp, before.ID)
+	case before.Timestamp > 0:
+		query = query.Where("time_stamp < ?", before.Timestamp)
+	}
+	err := query.
+		Order("time_stamp DESC, message_id DESC").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		s.log.WithField("chat_room_id", roomID).Errorf("Failed to fetch messages: %v", err)
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (s *PostgresMessageStore) MarkRead(ctx context.Context, roomID uuid.UUID, timestamp int64, id string, status model.MessageStatus) error {
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.Message{}).
+		Where("chat_room_id = ? AND message_id = ?", roomID, id)
+	if status == model.StatusDelivered {
+		query = query.Where("status <> ?", model.StatusRead)
+	}
+	result := query.Update("status", status)
+	if result.Error != nil {
+		s.log.WithField("chat_room_id", roomID).Errorf("Failed to update message status: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+func (s *PostgresMessageStore) Delete(ctx context.Context, roomID uuid.UUID, timestamp int64, id string) error {
+	result := s.db.
+		WithContext(ctx).
+		Where("chat_room_id = ? AND message_id = ?", roomID, id).
+		Delete(&model.Message{})
+	if result.Error != nil {
+		s.log.WithField("chat_room_id", roomID).Errorf("Failed to delete message: %v", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
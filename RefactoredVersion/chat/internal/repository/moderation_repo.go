@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+type ModerationRepo struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewModerationRepo(log *logrus.Logger, db *gorm.DB) *ModerationRepo {
+	return &ModerationRepo{
+		log: log,
+		db:  db,
+	}
+}
+
+func (r ModerationRepo) CreateFlags(ctx context.Context, flags []*model.ModerationFlag) error {
+	if err := r.db.WithContext(ctx).Create(flags).Error; err != nil {
+		r.log.Errorf("Failed to create moderation flags: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListFlags returns the newest flags first. An empty status lists all of them.
+func (r ModerationRepo) ListFlags(ctx context.Context, status model.FlagStatus, roomId uuid.UUID, limit, offset int) ([]*model.ModerationFlag, error) {
+	var flags []*model.ModerationFlag
+	query := r.db.
+		WithContext(ctx).
+		Model(&model.ModerationFlag{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if roomId != uuid.Nil {
+		query = query.Where("chat_room_id = ?", roomId)
+	}
+	err := query.
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&flags).Error
+	if err != nil {
+		r.log.Errorf("Failed to fetch moderation flags: %v", err)
+		return nil, err
+	}
+	return flags, nil
+}
+
+// ReviewFlag records the decision on a pending flag and returns the updated
+// flag, or gorm.ErrRecordNotFound when there is no pending flag with the id.
+func (r ModerationRepo) ReviewFlag(ctx context.Context, id, reviewerId uuid.UUID, status model.FlagStatus, note string) (*model.ModerationFlag, error) {
+	now := time.Now().UTC()
+	result := r.db.
+		WithContext(ctx).
+		Model(&model.ModerationFlag{}).
+		Where("id = ? AND status = ?", id, model.FlagPending).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewed_by": reviewerId,
+			"reviewed_at": now,
+			"note":        note,
+		})
+	if result.Error != nil {
+		r.log.Errorf("Failed to review moderation flag: %v", result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var flag model.ModerationFlag
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&flag).Error; err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+type NotificationRepo struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewNotificationRepo(log *logrus.Logger, db *gorm.DB) *NotificationRepo {
+	return &NotificationRepo{
+		log: log,
+		db:  db,
+	}
+}
+
+func (r NotificationRepo) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	if err := r.db.WithContext(ctx).Create(notification).Error; err != nil {
+		r.log.Errorf("failed to create notification for %s: %v", notification.UserID, err)
+		return err
+	}
+	return nil
+}
+
+// ListNotifications returns up to limit notifications of a user older than
+// before, newest first. A zero before starts from the latest one.
+func (r NotificationRepo) ListNotifications(ctx context.Context, userId uuid.UUID, unreadOnly bool, before int64, limit int) ([]*model.Notification, error) {
+	notifications := make([]*model.Notification, 0)
+	query := r.db.
+		WithContext(ctx).
+		Model(&model.Notification{}).
+		Where("user_id = ?", userId)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+	err := query.
+		Order("id DESC").
+		Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		r.log.Errorf("failed to list notifications of %s: %v", userId, err)
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// NotificationsSince returns up to limit notifications of a user for
+// replaying a feed: those with an id above after, and those other than after
+// itself created at or after since. They come in id order, starting above
+// cursor.
+func (r NotificationRepo) NotificationsSince(ctx context.Context, userId uuid.UUID, after int64, since time.Time, cursor int64, limit int) ([]*model.Notification, error) {
+	var notifications []*model.Notification
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.Notification{}).
+		Where("user_id = ? AND id > ? AND id <> ?", userId, cursor, after).
+		Where("id > ? OR created_at >= ?", after, since).
+		Order("id ASC").
+		Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		r.log.Errorf("failed to replay notifications of %s: %v", userId, err)
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (r NotificationRepo) CountUnread(ctx context.Context, userId uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Count(&count).Error
+	if err != nil {
+		r.log.Errorf("failed to count unread notifications of %s: %v", userId, err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkRead marks the user's unread notifications up to and including upTo
+// as read, or only the one with id upTo when single is set. It returns how
+// many changed.
+func (r NotificationRepo) MarkRead(ctx context.Context, userId uuid.UUID, upTo int64, single bool, at time.Time) (int64, error) {
+	query := r.db.
+		WithContext(ctx).
+		Model(&model.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId)
+	if single {
+		query = query.Where("id = ?", upTo)
+	} else if upTo > 0 {
+		query = query.Where("id <= ?", upTo)
+	}
+	result := query.Update("read_at", at)
+	if result.Error != nil {
+		r.log.Errorf("failed to mark notifications of %s read: %v", userId, result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// GetNotification returns one notification of a user, or
+// gorm.ErrRecordNotFound.
+func (r NotificationRepo) GetNotification(ctx context.Context, userId uuid.UUID, id int64) (*model.Notification, error) {
+	var notification model.Notification
+	err := r.db.
+		WithContext(ctx).
+		Where("id = ? AND user_id = ?", id, userId).
+		First(&notification).Error
+	if err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
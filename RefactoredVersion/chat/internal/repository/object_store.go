@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"time"
+)
+
+var (
+	ErrObjectNotFound      = errors.New("object not found")
+	ErrPresignUnsupported  = errors.New("object store does not support presigned uploads")
+	ErrInvalidSignedObject = errors.New("signed object url is invalid or expired")
+)
+
+// ObjectStore holds attachment bytes. S3 and any S3 compatible store such as
+// MinIO go through S3ObjectStore; LocalObjectStore keeps files on disk.
+type ObjectStore interface {
+	// PresignPut returns a URL the client can PUT exactly size bytes of
+	// contentType to, or ErrPresignUnsupported.
+	PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, error)
+	Put(ctx context.Context, key, contentType string, size int64, body io.ReadSeeker) error
+	// Stat returns the stored size and content type, or ErrObjectNotFound.
+	Stat(ctx context.Context, key string) (int64, string, error)
+	// Head returns up to the first n bytes of an object, or
+	// ErrObjectNotFound.
+	Head(ctx context.Context, key string, n int64) ([]byte, error)
+	PresignGet(ctx context.Context, key, fileName string, ttl time.Duration) (string, error)
+}
+
+// NewObjectStore picks the backend from attachments.store: s3 (default) or local.
+func NewObjectStore(log *logrus.Logger, v *viper.Viper, s3Client *s3.Client) ObjectStore {
+	switch backend := v.GetString("attachments.store"); backend {
+	case "", "s3":
+		return NewS3ObjectStore(s3Client, v.GetString("aws.s3.bucketName"))
+	case "local":
+		return NewLocalObjectStore(
+			v.GetString("attachments.local.dir"),
+			v.GetString("attachments.local.public-url"),
+			v.GetString("attachments.local.signing-key"),
+		)
+	default:
+		log.Fatalf("Unknown attachments.store backend %q", backend)
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// DeliverOrder moves the order to DELIVERED and stores the delivery, which
+// gets the next number of the order, in the same transaction.
+func (r OrderRepo) DeliverOrder(ctx context.Context, delivery *model.OrderDelivery, from model.OrderStatus, at time.Time, note string) (*model.Order, error) {
+	columns := map[string]interface{}{"delivered_at": at}
+	return r.transition(ctx, delivery.OrderID, from, model.OrderDelivered, delivery.SellerID, at, columns, note, func(tx *gorm.DB, order *model.Order) error {
+		var count int64
+		if err := tx.Model(&model.OrderDelivery{}).Where("order_id = ?", order.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		delivery.Number = int(count) + 1
+		delivery.Response = model.DeliveryPending
+		delivery.CreatedAt = at
+		return tx.Create(delivery).Error
+	})
+}
+
+// RespondToDelivery records the buyer's answer to the pending delivery and
+// moves the order on, in one transaction. It fails with ErrStaleDelivery when
+// the delivery is not pending.
+func (r OrderRepo) RespondToDelivery(ctx context.Context, orderId, deliveryId, actorId uuid.UUID, from, to model.OrderStatus, response model.DeliveryResponse, at time.Time, columns map[string]interface{}, note string) (*model.Order, *model.OrderDelivery, error) {
+	var delivery model.OrderDelivery
+	order, err := r.transition(ctx, orderId, from, to, actorId, at, columns, note, func(tx *gorm.DB, order *model.Order) error {
+		result := tx.
+			Model(&model.OrderDelivery{}).
+			Where("id = ? AND order_id = ? AND response = ?", deliveryId, orderId, model.DeliveryPending).
+			Updates(map[string]interface{}{
+				"response":      response,
+				"response_note": note,
+				"responded_at":  at,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrStaleDelivery
+		}
+		return tx.Where("id = ?", deliveryId).First(&delivery).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, &delivery, nil
+}
+
+// GetDeliveries returns the deliveries of an order, oldest first.
+func (r OrderRepo) GetDeliveries(ctx context.Context, orderId uuid.UUID) ([]*model.OrderDelivery, error) {
+	var deliveries []*model.OrderDelivery
+	err := r.gormClient.
+		WithContext(ctx).
+		Model(&model.OrderDelivery{}).
+		Where("order_id = ?", orderId).
+		Order("number ASC").
+		Find(&deliveries).Error
+	if err != nil {
+		r.log.Errorf("failed to get deliveries of order %s: %v", orderId, err)
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// ErrDisputeClosed means the dispute was ruled on already, or is assigned to
+// another moderator than the one ruling.
+var ErrDisputeClosed = errors.New("dispute is no longer open")
+
+// OpenDispute moves the order to DISPUTED and stores the dispute with its
+// first evidence in the same transaction. The reason is kept as the note of
+// the step.
+func (r OrderRepo) OpenDispute(ctx context.Context, dispute *model.OrderDispute, from model.OrderStatus, at time.Time) (*model.Order, error) {
+	return r.transition(ctx, dispute.OrderID, from, model.OrderDisputed, dispute.OpenedBy, at, nil, dispute.Reason, func(tx *gorm.DB, order *model.Order) error {
+		dispute.Status = model.DisputeOpen
+		dispute.CreatedAt = at
+		return tx.Create(dispute).Error
+	})
+}
+
+// AddDisputeEvidence stores further evidence of an open dispute and records
+// it in the order's timeline.
+func (r OrderRepo) AddDisputeEvidence(ctx context.Context, dispute *model.OrderDispute, actorId uuid.UUID, evidence []*model.DisputeEvidence) error {
+	err := r.gormClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked model.OrderDispute
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", dispute.ID).
+			First(&locked).Error
+		if err != nil {
+			return err
+		}
+		if locked.Status != model.DisputeOpen {
+			return ErrDisputeClosed
+		}
+		if err := tx.Create(evidence).Error; err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(evidence))
+		for _, e := range evidence {
+			ids = append(ids, e.MessageID)
+		}
+		return writeOrderEvent(tx, &model.OrderEvent{
+			OrderID: dispute.OrderID,
+			ActorID: &actorId,
+			Type:    model.OrderEventDisputeEvidence,
+			Diff:    map[string]model.FieldChange{"evidence": {To: ids}},
+		})
+	})
+	if err != nil && !errors.Is(err, ErrDisputeClosed) {
+		r.log.Errorf("failed to add evidence to dispute %s: %v", dispute.ID, err)
+	}
+	return err
+}
+
+// AssignDispute hands an open dispute to a moderator, or over to another
+// one, and records it in the order's timeline.
+func (r OrderRepo) AssignDispute(ctx context.Context, disputeId, moderatorId, actorId uuid.UUID, at time.Time) (*model.OrderDispute, error) {
+	var dispute model.OrderDispute
+	err := r.gormClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", disputeId).
+			First(&dispute).Error
+		if err != nil {
+			return err
+		}
+		if dispute.Status != model.DisputeOpen {
+			return ErrDisputeClosed
+		}
+		before := dispute.ModeratorID
+		err = tx.
+			Model(&model.OrderDispute{}).
+			Where("id = ?", disputeId).
+			Updates(map[string]interface{}{
+				"moderator_id": moderatorId,
+				"assigned_at":  at,
+			}).Error
+		if err != nil {
+			return err
+		}
+		dispute.ModeratorID = &moderatorId
+		dispute.AssignedAt = &at
+		return writeOrderEvent(tx, &model.OrderEvent{
+			OrderID: dispute.OrderID,
+			ActorID: &actorId,
+			Type:    model.OrderEventDisputeAssigned,
+			Diff:    map[string]model.FieldChange{"moderator_id": {From: before, To: moderatorId}},
+		})
+	})
+	if err != nil {
+		if !errors.Is(err, ErrDisputeClosed) && !errors.Is(err, gorm.ErrRecordNotFound) {
+			r.log.Errorf("failed to assign dispute %s: %v", disputeId, err)
+		}
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+// ResolveDispute records the ruling of the assigned moderator and moves the
+// order out of DISPUTED in one transaction. A refund amount is set on the
+// order's payment while it is still held, for the settlement to split it.
+func (r OrderRepo) ResolveDispute(ctx context.Context, dispute *model.OrderDispute, to model.OrderStatus, at time.Time, columns map[string]interface{}, note string) (*model.Order, error) {
+	return r.transition(ctx, dispute.OrderID, model.OrderDisputed, to, *dispute.ModeratorID, at, columns, note, func(tx *gorm.DB, order *model.Order) error {
+		result := tx.
+			Model(&model.OrderDispute{}).
+			Where("id = ? AND status = ? AND moderator_id = ?", dispute.ID, model.DisputeOpen, *dispute.ModeratorID).
+			Updates(map[string]interface{}{
+				"status":        model.DisputeResolved,
+				"ruling":        dispute.Ruling,
+				"refund_amount": dispute.RefundAmount,
+				"ruling_note":   dispute.RulingNote,
+				"resolved_at":   at,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDisputeClosed
+		}
+		dispute.Status = model.DisputeResolved
+		dispute.ResolvedAt = &at
+		if dispute.RefundAmount == 0 {
+			return nil
+		}
+		return tx.
+			Model(&model.Payment{}).
+			Where("order_id = ? AND status = ?", order.ID, model.PaymentHeld).
+			Update("refund_amount", dispute.RefundAmount).Error
+	})
+}
+
+// GetDispute returns a dispute with its evidence, or gorm.ErrRecordNotFound.
+func (r OrderRepo) GetDispute(ctx context.Context, id uuid.UUID) (*model.OrderDispute, error) {
+	var dispute model.OrderDispute
+	err := r.disputes(ctx).Where("id = ?", id).First(&dispute).Error
+	if err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+// GetDisputesByOrder returns the disputes of an order, oldest first.
+func (r OrderRepo) GetDisputesByOrder(ctx context.Context, orderId uuid.UUID) ([]*model.OrderDispute, error) {
+	var disputes []*model.OrderDispute
+	err := r.disputes(ctx).
+		Where("order_id = ?", orderId).
+		Order("created_at ASC").
+		Find(&disputes).Error
+	if err != nil {
+		r.log.Errorf("failed to get disputes of order %s: %v", orderId, err)
+		return nil, err
+	}
+	return disputes, nil
+}
+
+// ListDisputes returns the oldest disputes first. An empty status lists all
+// of them, and a nil moderatorId disputes of every moderator.
+func (r OrderRepo) ListDisputes(ctx context.Context, status model.DisputeStatus, moderatorId *uuid.UUID, limit, offset int) ([]*model.OrderDispute, error) {
+	var disputes []*model.OrderDispute
+	query := r.disputes(ctx)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if moderatorId != nil {
+		query = query.Where("moderator_id = ?", *moderatorId)
+	}
+	err := query.
+		Order("created_at ASC").
+		Limit(limit).
+		Offset(offset).
+		Find(&disputes).Error
+	if err != nil {
+		r.log.Errorf("failed to list disputes: %v", err)
+		return nil, err
+	}
+	return disputes, nil
+}
+
+func (r OrderRepo) disputes(ctx context.Context) *gorm.DB {
+	return r.gormClient.
+		WithContext(ctx).
+		Model(&model.OrderDispute{}).
+		Preload("Evidence", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		})
+}
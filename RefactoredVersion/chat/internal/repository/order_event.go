@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// orderAuditColumns are the columns of an order that its timeline tracks,
+// keyed by column name.
+func orderAuditColumns(order *model.Order) map[string]interface{} {
+	return map[string]interface{}{
+		"status":           order.Status,
+		"price":            order.Price,
+		"payment_method":   order.PaymentMethod,
+		"transaction_id":   order.TransactionID,
+		"requirements":     order.Requirements,
+		"package_id":       order.PackageID,
+		"package_snapshot": order.PackageSnapshot,
+		"due_date":         order.DueDate,
+		"accepted_at":      order.AcceptedAt,
+		"delivered_at":     order.DeliveredAt,
+		"completed_at":     order.CompletedAt,
+		"cancelled_at":     order.CancelledAt,
+		"revisions_used":   order.RevisionsUsed,
+		"is_late":          order.IsLate,
+		"late_at":          order.LateAt,
+		"due_reminded_at":  order.DueRemindedAt,
+		"auto_completed":   order.AutoCompleted,
+	}
+}
+
+// orderDiff returns the audited columns that differ between two versions of
+// an order. A nil before lists every column that is set on after.
+func orderDiff(before, after *model.Order) map[string]model.FieldChange {
+	var old map[string]interface{}
+	if before != nil {
+		old = orderAuditColumns(before)
+	}
+	diff := make(map[string]model.FieldChange)
+	for column, value := range orderAuditColumns(after) {
+		// compare the JSON encodings, which is what the timeline shows and
+		// which ignores time zones and monotonic clock readings
+		newJSON, _ := json.Marshal(value)
+		var oldValue interface{}
+		if old != nil {
+			oldValue = old[column]
+		}
+		oldJSON, _ := json.Marshal(oldValue)
+		if string(oldJSON) == string(newJSON) {
+			continue
+		}
+		diff[column] = model.FieldChange{From: oldValue, To: value}
+	}
+	return diff
+}
+
+func writeOrderEvent(tx *gorm.DB, event *model.OrderEvent) error {
+	return tx.Create(event).Error
+}
+
+// AddOrderEvent records an event that comes with no change of the order
+// itself.
+func (r OrderRepo) AddOrderEvent(ctx context.Context, event *model.OrderEvent) error {
+	if err := writeOrderEvent(r.gormClient.WithContext(ctx), event); err != nil {
+		r.log.Errorf("failed to record %s of order %s: %v", event.Type, event.OrderID, err)
+		return err
+	}
+	return nil
+}
+
+// GetOrderEvents returns the timeline of an order, oldest first.
+func (r OrderRepo) GetOrderEvents(ctx context.Context, orderId uuid.UUID) ([]*model.OrderEvent, error) {
+	var events []*model.OrderEvent
+	err := r.gormClient.
+		WithContext(ctx).
+		Model(&model.OrderEvent{}).
+		Where("order_id = ?", orderId).
+		Order("created_at ASC").
+		Find(&events).Error
+	if err != nil {
+		r.log.Errorf("failed to get events of order %s: %v", orderId, err)
+		return nil, err
+	}
+	return events, nil
+}
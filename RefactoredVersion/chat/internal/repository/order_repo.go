@@ -10,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.uber.org/fx"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"math/rand"
 	"time"
 )
@@ -17,8 +18,28 @@ import (
 var RepoModule = fx.Module("repository", fx.Provide(
 	NewOrderRepo,
 	NewChatRepository,
+	NewMessageStore,
+	NewObjectStore,
+	NewAttachmentRepo,
+	NewSearchIndex,
+	NewModerationRepo,
+	NewPaymentRepo,
+	NewPaymentProvider,
+	NewReviewRepo,
+	NewNotificationRepo,
 ))
 
+var (
+	// ErrStaleOrderStatus means the order was no longer in the expected
+	// status when a transition was written.
+	ErrStaleOrderStatus = errors.New("order status changed concurrently")
+	// ErrDuplicateIdempotencyKey means another order holds the key.
+	ErrDuplicateIdempotencyKey = errors.New("idempotency key is already used")
+	// ErrStaleDelivery means the delivery was answered already or is not
+	// the latest one of the order.
+	ErrStaleDelivery = errors.New("delivery is no longer awaiting a response")
+)
+
 type OrderRepo struct {
 	log          *logrus.Logger
 	dynamoClient *dynamodb.Client
@@ -37,83 +58,168 @@ func NewOrderRepo(
 	}
 }
 
-func (r OrderRepo) FindOrCreate(req *model.OrderPlaceRequest) (*model.Order, error) {
-	var order model.Order
-	err := r.gormClient.
-		Model(model.Order{}).
-		Where("buyer_id = ? AND seller_id = ? AND package_id = ?", req.BuyerId, req.SellerId, req.ServiceId).
-		First(&order).Error
+// PlaceOrder creates the order, its payment and its chat room in one
+// transaction. With an idempotency key, the key is claimed first; when it is
+// already held by a live entry nothing is created and
+// ErrDuplicateIdempotencyKey is returned, and GetIdempotencyKey tells which
+// order it belongs to. A concurrent request with the same key waits for this
+// transaction and then sees the key as taken.
+func (r OrderRepo) PlaceOrder(ctx context.Context, order *model.Order, room *model.ChatRoom, key *model.OrderIdempotencyKey, payment *model.Payment) error {
+	if order.ID == uuid.Nil {
+		order.ID = uuid.New()
+	}
+	if order.OrderNumber == "" {
+		order.OrderNumber = r.GenerateOrderNumber()
+	}
+	if room.ChatRoomID == uuid.Nil {
+		room.ChatRoomID = uuid.New()
+	}
+	room.OrderId = order.ID
 
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		order = model.Order{
-			BuyerID:       req.BuyerId,
-			SellerID:      req.SellerId,
-			PackageID:     req.ServiceId,
-			OrderNumber:   r.GenerateOrderNumber(),
-			Price:         0.0,
-			PaymentMethod: "NOT_SET",
-			Status:        "PENDING",
+	err := r.gormClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if key != nil {
+			err := tx.
+				Where("buyer_id = ? AND key = ? AND expires_at < ?", key.BuyerID, key.Key, time.Now().UTC()).
+				Delete(&model.OrderIdempotencyKey{}).Error
+			if err != nil {
+				return err
+			}
+			key.OrderID = order.ID
+			key.ChatRoomID = room.ChatRoomID
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrDuplicateIdempotencyKey
+			}
 		}
-		if err := r.gormClient.
-			Model(model.Order{}).
-			Create(&order).Error; err != nil {
-			return nil, err
+
+		if err := tx.Model(model.Order{}).Create(order).Error; err != nil {
+			return err
+		}
+		if err := writeOrderEvent(tx, &model.OrderEvent{
+			OrderID:  order.ID,
+			ActorID:  &order.BuyerID,
+			Type:     model.OrderEventCreated,
+			ToStatus: order.Status,
+			Diff:     orderDiff(nil, order),
+		}); err != nil {
+			return err
 		}
-	} else if err != nil {
-		return nil, err // Some other DB error
+		if payment != nil {
+			payment.OrderID = order.ID
+			if err := tx.Create(payment).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(room).Error
+	})
+	if err != nil && !errors.Is(err, ErrDuplicateIdempotencyKey) {
+		r.log.Errorf("failed to place order: %v", err)
 	}
-
-	return &order, nil
-
+	return err
 }
-func (r OrderRepo) UpdateOrderStatus(orderNumber string, packageId uuid.UUID, status string) error {
+
+// GetIdempotencyKey returns the live entry of a buyer's key, or
+// gorm.ErrRecordNotFound.
+func (r OrderRepo) GetIdempotencyKey(ctx context.Context, buyerId uuid.UUID, key string) (*model.OrderIdempotencyKey, error) {
+	var entry model.OrderIdempotencyKey
 	err := r.gormClient.
-		WithContext(context.TODO()).
-		Model(&model.Order{}).
-		Where("order_number = ? AND package_id = ?", orderNumber, packageId).
-		Updates(map[string]interface{}{
-			"status": status,
-		}).Error
+		WithContext(ctx).
+		Where("buyer_id = ? AND key = ? AND expires_at >= ?", buyerId, key, time.Now().UTC()).
+		First(&entry).Error
 	if err != nil {
-		r.log.Error(err.Error)
-		return err
+		return nil, err
 	}
-	return nil
+	return &entry, nil
 }
 
-func (r OrderRepo) CompleteOrder(orderNumber string, packageId uuid.UUID, status string) error {
-	err := r.gormClient.
-		WithContext(context.TODO()).
-		Model(&model.Order{}).
-		Where("order_number = ? AND package_id = ?", orderNumber, packageId).
-		Updates(map[string]interface{}{
-			"status":       status,
-			"completed_at": time.Now(),
-		}).Error
-	if err != nil {
-		r.log.Error(err.Error)
-		return err
-	}
-	return nil
+// TransitionOrder moves the order from status from to status to, stamping
+// the actor, the time and any extra columns of the transition, and records
+// the change in the order's timeline in the same transaction. The order row
+// is locked while the status is checked, so of two concurrent transitions
+// only one applies; the other gets ErrStaleOrderStatus. A nil actorId marks
+// a change made by the system.
+func (r OrderRepo) TransitionOrder(ctx context.Context, id uuid.UUID, from, to model.OrderStatus, actorId uuid.UUID, at time.Time, columns map[string]interface{}, note string) (*model.Order, error) {
+	return r.transition(ctx, id, from, to, actorId, at, columns, note, nil)
 }
 
-func (r OrderRepo) UpdateOrderPartial(orderNumber string, packageId uuid.UUID, updates map[string]interface{}) error {
-	if len(updates) == 0 {
-		return errors.New("no fields to update")
+// transition is TransitionOrder with a hook that writes further rows of the
+// step, such as a delivery, in the same transaction.
+func (r OrderRepo) transition(ctx context.Context, id uuid.UUID, from, to model.OrderStatus, actorId uuid.UUID, at time.Time, columns map[string]interface{}, note string, with func(tx *gorm.DB, order *model.Order) error) (*model.Order, error) {
+	updates := map[string]interface{}{
+		"status":            to,
+		"status_changed_at": at,
+	}
+	var actor *uuid.UUID
+	if actorId != uuid.Nil {
+		actor = &actorId
+		updates["status_changed_by"] = actorId
+	}
+	for column, value := range columns {
+		updates[column] = value
 	}
 
-	err := r.gormClient.
-		WithContext(context.TODO()).
-		Model(&model.Order{}).
-		Where("order_number = ? AND package_id = ?", orderNumber, packageId).
-		Updates(updates).Error
+	var after *model.Order
+	err := r.gormClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var before model.Order
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			First(&before).Error
+		if err != nil {
+			return err
+		}
+		if before.Status != from {
+			return ErrStaleOrderStatus
+		}
+		if err := tx.Model(&model.Order{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
 
+		after = &model.Order{}
+		if err := tx.Where("id = ?", id).First(after).Error; err != nil {
+			return err
+		}
+		if with != nil {
+			if err := with(tx, after); err != nil {
+				return err
+			}
+		}
+		return writeOrderEvent(tx, &model.OrderEvent{
+			OrderID:    id,
+			ActorID:    actor,
+			Type:       model.OrderEventTransition,
+			FromStatus: from,
+			ToStatus:   to,
+			Diff:       orderDiff(&before, after),
+			Note:       note,
+		})
+	})
 	if err != nil {
-		r.log.Errorf("failed to update order partially: %v", err)
-		return err
+		if !errors.Is(err, ErrStaleOrderStatus) && !errors.Is(err, ErrStaleDelivery) {
+			r.log.Errorf("failed to move order %s from %s to %s: %v", id, from, to, err)
+		}
+		return nil, err
 	}
+	return after, nil
+}
 
-	return nil
+// GetGigPackage loads a package with its gig and features.
+func (r OrderRepo) GetGigPackage(ctx context.Context, id uuid.UUID) (*model.GigPackage, error) {
+	var pkg model.GigPackage
+	err := r.gormClient.
+		WithContext(ctx).
+		Preload("Gig").
+		Preload("Features").
+		Where("id = ?", id).
+		First(&pkg).Error
+	if err != nil {
+		r.log.Errorf("failed to get gig package %s: %v", id, err)
+		return nil, err
+	}
+	return &pkg, nil
 }
 
 func (r OrderRepo) GenerateOrderNumber() string {
@@ -131,22 +237,6 @@ func (r OrderRepo) GenerateOrderNumber() string {
 	return fmt.Sprintf("SN%s-%s", timestamp, string(suffix))
 }
 
-func (r OrderRepo) CancelOrder(orderNumber string, packageId uuid.UUID) error {
-	err := r.gormClient.
-		WithContext(context.TODO()).
-		Model(&model.Order{}).
-		Where("order_number = ? AND package_id = ?", orderNumber, packageId).
-		Updates(map[string]interface{}{
-			"status":       "CANCELLED",
-			"cancelled_at": time.Now(),
-		}).Error
-
-	if err != nil {
-		r.log.Errorf("failed to cancel order: %v", err)
-		return err
-	}
-	return nil
-}
 func (r OrderRepo) GetAllOrderByUserId(id uuid.UUID) ([]*model.Order, error) {
 	var orders []*model.Order
 	err := r.gormClient.
@@ -160,3 +250,17 @@ func (r OrderRepo) GetAllOrderByUserId(id uuid.UUID) ([]*model.Order, error) {
 	}
 	return orders, nil
 }
+
+func (r OrderRepo) GetOrderById(id uuid.UUID) (*model.Order, error) {
+	var order model.Order
+	err := r.gormClient.
+		WithContext(context.TODO()).
+		Model(&model.Order{}).
+		Where("id = ?", id).
+		First(&order).Error
+	if err != nil {
+		r.log.Errorf("failed to get order %s: %v", id, err.Error())
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// FindOverdueOrders returns orders the seller still works on whose due date
+// has passed and that are not flagged late yet.
+func (r OrderRepo) FindOverdueOrders(ctx context.Context, now time.Time, limit int) ([]*model.Order, error) {
+	return r.findOrders(ctx, "due_date", limit, "status IN ? AND due_date < ? AND is_late = ?", model.OrderActiveStatuses, now, false)
+}
+
+// FindOrdersDueBefore returns orders the seller still works on that are due
+// between now and until and have not been reminded of.
+func (r OrderRepo) FindOrdersDueBefore(ctx context.Context, now, until time.Time, limit int) ([]*model.Order, error) {
+	return r.findOrders(ctx, "due_date", limit, "status IN ? AND due_date >= ? AND due_date < ? AND due_reminded_at IS NULL", model.OrderActiveStatuses, now, until)
+}
+
+// FindDeliveredBefore returns delivered orders the buyer has not answered
+// since cutoff.
+func (r OrderRepo) FindDeliveredBefore(ctx context.Context, cutoff time.Time, limit int) ([]*model.Order, error) {
+	return r.findOrders(ctx, "delivered_at", limit, "status = ? AND delivered_at < ?", model.OrderDelivered, cutoff)
+}
+
+// findOrders returns up to limit orders matching query, oldest by orderBy first.
+func (r OrderRepo) findOrders(ctx context.Context, orderBy string, limit int, query string, args ...interface{}) ([]*model.Order, error) {
+	var orders []*model.Order
+	err := r.gormClient.
+		WithContext(ctx).
+		Model(&model.Order{}).
+		Where(query, args...).
+		Order(orderBy + " ASC").
+		Limit(limit).
+		Find(&orders).Error
+	if err != nil {
+		r.log.Errorf("failed to find orders: %v", err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+// MarkLate flags an order as late. It reports false when the order was
+// flagged already or is no longer active.
+func (r OrderRepo) MarkLate(ctx context.Context, id uuid.UUID, at time.Time) (*model.Order, bool, error) {
+	columns := map[string]interface{}{"is_late": true, "late_at": at}
+	return r.flagOrder(ctx, id, model.OrderEventLate, "due date passed", columns, func(order *model.Order) bool {
+		return !order.IsLate && activeStatus(order.Status)
+	})
+}
+
+// MarkReminded records that the seller was reminded of the due date. It
+// reports false when the order was reminded of already.
+func (r OrderRepo) MarkReminded(ctx context.Context, id uuid.UUID, at time.Time) (*model.Order, bool, error) {
+	columns := map[string]interface{}{"due_reminded_at": at}
+	return r.flagOrder(ctx, id, model.OrderEventReminder, "due date is near", columns, func(order *model.Order) bool {
+		return order.DueRemindedAt == nil && activeStatus(order.Status)
+	})
+}
+
+// flagOrder sets columns of an order outside the status machine, on behalf
+// of the system, when check still holds for the locked row. The change is
+// recorded in the order's timeline.
+func (r OrderRepo) flagOrder(ctx context.Context, id uuid.UUID, eventType model.OrderEventType, note string, columns map[string]interface{}, check func(order *model.Order) bool) (*model.Order, bool, error) {
+	var after *model.Order
+	changed := false
+	err := r.gormClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var before model.Order
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			First(&before).Error
+		if err != nil {
+			return err
+		}
+		if !check(&before) {
+			after = &before
+			return nil
+		}
+		if err := tx.Model(&model.Order{}).Where("id = ?", id).Updates(columns).Error; err != nil {
+			return err
+		}
+		after = &model.Order{}
+		if err := tx.Where("id = ?", id).First(after).Error; err != nil {
+			return err
+		}
+		changed = true
+		return writeOrderEvent(tx, &model.OrderEvent{
+			OrderID:    id,
+			Type:       eventType,
+			FromStatus: before.Status,
+			ToStatus:   after.Status,
+			Diff:       orderDiff(&before, after),
+			Note:       note,
+		})
+	})
+	if err != nil {
+		r.log.Errorf("failed to record %s of order %s: %v", eventType, id, err)
+		return nil, false, err
+	}
+	return after, changed, nil
+}
+
+// GetPendingDelivery returns the delivery of the order that awaits the
+// buyer's answer, or gorm.ErrRecordNotFound.
+func (r OrderRepo) GetPendingDelivery(ctx context.Context, orderId uuid.UUID) (*model.OrderDelivery, error) {
+	var delivery model.OrderDelivery
+	err := r.gormClient.
+		WithContext(ctx).
+		Where("order_id = ? AND response = ?", orderId, model.DeliveryPending).
+		Order("number DESC").
+		First(&delivery).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		r.log.Errorf("failed to get pending delivery of order %s: %v", orderId, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func activeStatus(status model.OrderStatus) bool {
+	for _, active := range model.OrderActiveStatuses {
+		if status == active {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrPaymentDeclined = errors.New("payment was declined")
+	// ErrTransferExceedsCharge means payouts and refunds of a charge would
+	// add up to more than was charged.
+	ErrTransferExceedsCharge = errors.New("transfer exceeds what is left of the charge")
+)
+
+// PaymentProvider moves the actual money. The platform holds charged money
+// in escrow until the order is completed or cancelled. Transfers with the
+// key of an earlier successful one must succeed without moving money again.
+type PaymentProvider interface {
+	Name() string
+	// Charge takes the amount from the buyer and returns the provider's
+	// reference of the charge.
+	Charge(ctx context.Context, charge *model.PaymentCharge) (string, error)
+	// Payout sends part of a charge to the seller.
+	Payout(ctx context.Context, transfer *model.PaymentTransfer) error
+	// Refund sends part of a charge back to the buyer.
+	Refund(ctx context.Context, transfer *model.PaymentTransfer) error
+}
+
+// NewPaymentProvider picks the provider from payments.provider: local
+// (default), a fake that keeps charges in memory.
+func NewPaymentProvider(log *logrus.Logger, v *viper.Viper) PaymentProvider {
+	switch provider := v.GetString("payments.provider"); provider {
+	case "", "local":
+		return NewLocalPaymentProvider(log, v.GetInt64("payments.local.decline-above"))
+	default:
+		log.Fatalf("Unknown payments.provider %q", provider)
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+type localCharge struct {
+	amount      int64
+	transferred int64
+}
+
+// LocalPaymentProvider is a fake provider for development and tests. Every
+// charge succeeds unless it is above declineAbove (when set), and charges
+// are only remembered by the running process: transfers of charges it does
+// not know, such as ones made before a restart, are accepted as they are.
+type LocalPaymentProvider struct {
+	log          *logrus.Logger
+	declineAbove int64
+	mu           sync.Mutex
+	charges      map[string]*localCharge
+	transfers    map[string]bool
+}
+
+func NewLocalPaymentProvider(log *logrus.Logger, declineAbove int64) *LocalPaymentProvider {
+	return &LocalPaymentProvider{
+		log:          log,
+		declineAbove: declineAbove,
+		charges:      make(map[string]*localCharge),
+		transfers:    make(map[string]bool),
+	}
+}
+
+func (p *LocalPaymentProvider) Name() string { return "local" }
+
+func (p *LocalPaymentProvider) Charge(ctx context.Context, charge *model.PaymentCharge) (string, error) {
+	if charge.Amount <= 0 || (p.declineAbove > 0 && charge.Amount > p.declineAbove) {
+		return "", fmt.Errorf("%w: %d %s", ErrPaymentDeclined, charge.Amount, charge.Currency)
+	}
+	reference := "local_" + uuid.NewString()
+	p.mu.Lock()
+	p.charges[reference] = &localCharge{amount: charge.Amount}
+	p.mu.Unlock()
+	p.log.Infof("local payment: charged %d %s from %s for order %s (%s)", charge.Amount, charge.Currency, charge.BuyerID, charge.OrderID, reference)
+	return reference, nil
+}
+
+func (p *LocalPaymentProvider) Payout(ctx context.Context, transfer *model.PaymentTransfer) error {
+	return p.transfer("paid out", transfer)
+}
+
+func (p *LocalPaymentProvider) Refund(ctx context.Context, transfer *model.PaymentTransfer) error {
+	return p.transfer("refunded", transfer)
+}
+
+func (p *LocalPaymentProvider) transfer(action string, transfer *model.PaymentTransfer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.transfers[transfer.Key] {
+		return nil
+	}
+	if charge, ok := p.charges[transfer.Reference]; ok {
+		if charge.transferred+transfer.Amount > charge.amount {
+			return fmt.Errorf("%w: %s", ErrTransferExceedsCharge, transfer.Reference)
+		}
+		charge.transferred += transfer.Amount
+	}
+	p.transfers[transfer.Key] = true
+	p.log.Infof("local payment: %s %d %s of %s to %s", action, transfer.Amount, transfer.Currency, transfer.Reference, transfer.UserID)
+	return nil
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrPaymentSettled means the payment left escrow already.
+var ErrPaymentSettled = errors.New("payment is no longer held")
+
+type PaymentRepo struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewPaymentRepo(log *logrus.Logger, db *gorm.DB) *PaymentRepo {
+	return &PaymentRepo{
+		log: log,
+		db:  db,
+	}
+}
+
+// GetPaymentByOrder returns the payment of an order with its ledger entries,
+// or gorm.ErrRecordNotFound for orders placed before payments existed.
+func (r PaymentRepo) GetPaymentByOrder(ctx context.Context, orderId uuid.UUID) (*model.Payment, error) {
+	var payment model.Payment
+	err := r.db.
+		WithContext(ctx).
+		Preload("Entries", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Where("order_id = ?", orderId).
+		First(&payment).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		r.log.Errorf("failed to get payment of order %s: %v", orderId, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// SettlePayment moves a held payment to status and books the entries of the
+// settlement in one transaction. Of two concurrent settlements only one
+// applies; the other gets ErrPaymentSettled.
+func (r PaymentRepo) SettlePayment(ctx context.Context, payment *model.Payment, status model.PaymentStatus, at time.Time, entries []*model.LedgerEntry) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&model.Payment{}).
+			Where("id = ? AND status = ?", payment.ID, model.PaymentHeld).
+			Updates(map[string]interface{}{
+				"status":     status,
+				"settled_at": at,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPaymentSettled
+		}
+		return tx.Create(entries).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrPaymentSettled) {
+			r.log.Errorf("failed to settle payment %s as %s: %v", payment.ID, status, err)
+		}
+		return err
+	}
+	payment.Status = status
+	payment.SettledAt = &at
+	payment.Entries = append(payment.Entries, entries...)
+	return nil
+}
+
+// FindUnsettledPayments returns payments still held in escrow although their
+// order reached orderStatus, oldest first.
+func (r PaymentRepo) FindUnsettledPayments(ctx context.Context, orderStatus model.OrderStatus, limit int) ([]*model.Payment, error) {
+	var payments []*model.Payment
+	err := r.db.
+		WithContext(ctx).
+		Model(&model.Payment{}).
+		Joins(`JOIN "Order" ON "Order".id = "Payment".order_id`).
+		Where(`"Payment".status = ? AND "Order".status = ?`, model.PaymentHeld, orderStatus).
+		Order(`"Payment".created_at ASC`).
+		Limit(limit).
+		Find(&payments).Error
+	if err != nil {
+		r.log.Errorf("failed to find unsettled payments: %v", err)
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	// ErrDuplicateReview means the order has a review already.
+	ErrDuplicateReview = errors.New("order is already reviewed")
+	// ErrReviewReplied means the seller answered the review already.
+	ErrReviewReplied = errors.New("review already has a reply")
+)
+
+type ReviewRepo struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewReviewRepo(log *logrus.Logger, db *gorm.DB) *ReviewRepo {
+	return &ReviewRepo{
+		log: log,
+		db:  db,
+	}
+}
+
+// CreateReview stores the review of an order and recomputes the rating of
+// its gig in the same transaction. The gig row is locked first, so
+// concurrent reviews of one gig are counted one after the other.
+func (r ReviewRepo) CreateReview(ctx context.Context, review *model.Review) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var gig model.Gig
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", review.GigID).
+			First(&gig).Error
+		if err != nil {
+			return err
+		}
+		result := tx.
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "orderId"}}, DoNothing: true}).
+			Omit(clause.Associations).
+			Create(review)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDuplicateReview
+		}
+		return tx.Exec(`UPDATE "Gig" SET
+			"averageRating" = (SELECT COALESCE(AVG(rating), 0) FROM "Review" WHERE "gigId" = @gig),
+			"ratingCount" = (SELECT COUNT(*) FROM "Review" WHERE "gigId" = @gig),
+			"updatedAt" = @now
+			WHERE id = @gig`,
+			map[string]interface{}{"gig": review.GigID, "now": time.Now().UTC()},
+		).Error
+	})
+	if err != nil && !errors.Is(err, ErrDuplicateReview) {
+		r.log.Errorf("failed to create review of order %s: %v", review.OrderID, err)
+	}
+	return err
+}
+
+// ReplyToReview stores the seller's reply to a review once.
+func (r ReviewRepo) ReplyToReview(ctx context.Context, review *model.Review, reply string, at time.Time) error {
+	result := r.db.
+		WithContext(ctx).
+		Model(&model.Review{}).
+		Where(`id = ? AND "sellerReply" IS NULL`, review.ID).
+		Updates(map[string]interface{}{
+			"sellerReply": reply,
+			"repliedAt":   at,
+		})
+	if result.Error != nil {
+		r.log.Errorf("failed to reply to review %s: %v", review.ID, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReviewReplied
+	}
+	review.SellerReply = &reply
+	review.RepliedAt = &at
+	return nil
+}
+
+// GetReview returns a review, or gorm.ErrRecordNotFound.
+func (r ReviewRepo) GetReview(ctx context.Context, id uuid.UUID) (*model.Review, error) {
+	var review model.Review
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&review).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// ListPublicReviews returns a page of the public reviews of a gig or a
+// seller, newest first, and how many there are in total. column is gigId or
+// sellerId.
+func (r ReviewRepo) ListPublicReviews(ctx context.Context, column string, id uuid.UUID, limit, offset int) ([]*model.Review, int64, error) {
+	query := r.db.
+		WithContext(ctx).
+		Model(&model.Review{}).
+		Where(clause.Eq{Column: clause.Column{Name: column}, Value: id}).
+		Where(`"isPublic" = ?`, true).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		r.log.Errorf("failed to count reviews by %s %s: %v", column, id, err)
+		return nil, 0, err
+	}
+	reviews := make([]*model.Review, 0)
+	err := query.
+		Order(`"createdAt" DESC`).
+		Limit(limit).
+		Offset(offset).
+		Find(&reviews).Error
+	if err != nil {
+		r.log.Errorf("failed to list reviews by %s %s: %v", column, id, err)
+		return nil, 0, err
+	}
+	return reviews, total, nil
+}
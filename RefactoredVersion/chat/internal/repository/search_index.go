@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Snippets are produced with these markers around matches and only turned
+// into <mark> after the rest of the text has been escaped.
+const (
+	markStart = "\x02"
+	markStop  = "\x03"
+)
+
+// SearchIndex is the full-text index over chat message bodies. It is filled
+// from the socket write path and is independent of the MessageStore backend.
+type SearchIndex interface {
+	// Index adds the message, or replaces it after an edit.
+	Index(ctx context.Context, msg *model.Message) error
+	Remove(ctx context.Context, roomID uuid.UUID, id string) error
+	// Search returns up to limit matches in the given rooms, best first.
+	Search(ctx context.Context, roomIDs []uuid.UUID, query string, limit int) ([]*model.SearchHit, error)
+}
+
+// newBleveSearchIndex is set by search_index_bleve.go when the binary is
+// built with the bleve tag.
+var newBleveSearchIndex func(log *logrus.Logger, path string) (SearchIndex, error)
+
+// NewSearchIndex picks the backend from search.index: postgres (default),
+// bleve or memory.
+func NewSearchIndex(log *logrus.Logger, v *viper.Viper, db *gorm.DB) SearchIndex {
+	switch backend := v.GetString("search.index"); backend {
+	case "", "postgres":
+		return NewPostgresSearchIndex(log, db)
+	case "bleve":
+		if newBleveSearchIndex == nil {
+			log.Fatal("search.index is bleve but the binary was built without the bleve tag")
+		}
+		index, err := newBleveSearchIndex(log, v.GetString("search.bleve.path"))
+		if err != nil {
+			log.Fatalf("Failed to open bleve index: %v", err)
+		}
+		return index
+	case "memory":
+		return NewMemorySearchIndex()
+	default:
+		log.Fatalf("Unknown search.index backend %q", backend)
+		return nil
+	}
+}
+
+// searchTerms splits a query the way the Go side backends match it:
+// lower-cased runs of letters and digits.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlight marks every word of body that starts with one of the terms.
+func highlight(body string, terms []string) (string, bool) {
+	var b strings.Builder
+	matched := false
+	word := make([]rune, 0, 16)
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := string(word)
+		lower := strings.ToLower(w)
+		hit := false
+		for _, term := range terms {
+			if strings.HasPrefix(lower, term) {
+				hit = true
+				break
+			}
+		}
+		if hit {
+			matched = true
+			b.WriteString(markStart + w + markStop)
+		} else {
+			b.WriteString(w)
+		}
+		word = word[:0]
+	}
+	for _, r := range body {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+	return b.String(), matched
+}
+
+// snippetHTML escapes a marked snippet and turns the markers into <mark>.
+func snippetHTML(marked string) string {
+	escaped := html.EscapeString(marked)
+	escaped = strings.ReplaceAll(escaped, markStart, "<mark>")
+	return strings.ReplaceAll(escaped, markStop, "</mark>")
+}
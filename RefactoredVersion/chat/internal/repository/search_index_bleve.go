@@ -0,0 +1,127 @@
+//go:build bleve
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	newBleveSearchIndex = func(log *logrus.Logger, path string) (SearchIndex, error) {
+		return NewBleveSearchIndex(log, path)
+	}
+}
+
+// bleveDocument is what gets indexed per message. Room and From are keyword
+// fields so room filters match whole ids.
+type bleveDocument struct {
+	Room      string `json:"room"`
+	From      string `json:"from"`
+	Timestamp int64  `json:"timestamp"`
+	Body      string `json:"body"`
+}
+
+// BleveSearchIndex keeps an embedded index on disk, for running locally
+// without Postgres. Build with -tags bleve to include it.
+type BleveSearchIndex struct {
+	log   *logrus.Logger
+	index bleve.Index
+}
+
+func NewBleveSearchIndex(log *logrus.Logger, path string) (*BleveSearchIndex, error) {
+	if path == "" {
+		path = "chat-search.bleve"
+	}
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, bleveMapping())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BleveSearchIndex{log: log, index: index}, nil
+}
+
+func bleveMapping() *mapping.IndexMappingImpl {
+	idField := bleve.NewTextFieldMapping()
+	idField.Analyzer = keyword.Name
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("room", idField)
+	doc.AddFieldMappingsAt("from", idField)
+	doc.AddFieldMappingsAt("timestamp", bleve.NewNumericFieldMapping())
+	doc.AddFieldMappingsAt("body", bleve.NewTextFieldMapping())
+
+	mapping := bleve.NewIndexMapping()
+	mapping.DefaultMapping = doc
+	return mapping
+}
+
+func (s *BleveSearchIndex) Index(_ context.Context, msg *model.Message) error {
+	return s.index.Index(bleveDocID(msg.ChatRoomId, msg.ID), bleveDocument{
+		Room:      msg.ChatRoomId.String(),
+		From:      msg.From.String(),
+		Timestamp: msg.Timestamp,
+		Body:      msg.Body,
+	})
+}
+
+func (s *BleveSearchIndex) Remove(_ context.Context, roomID uuid.UUID, id string) error {
+	return s.index.Delete(bleveDocID(roomID, id))
+}
+
+func (s *BleveSearchIndex) Search(_ context.Context, roomIDs []uuid.UUID, text string, limit int) ([]*model.SearchHit, error) {
+	terms := searchTerms(text)
+	if len(terms) == 0 || len(roomIDs) == 0 {
+		return nil, nil
+	}
+
+	rooms := make([]query.Query, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		room := bleve.NewTermQuery(roomID.String())
+		room.SetField("room")
+		rooms = append(rooms, room)
+	}
+	match := bleve.NewMatchQuery(text)
+	match.SetField("body")
+	match.SetOperator(query.MatchQueryOperatorAnd)
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(match, bleve.NewDisjunctionQuery(rooms...)), limit, 0, false)
+	req.Fields = []string{"room", "from", "timestamp", "body"}
+	res, err := s.index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("search bleve index: %w", err)
+	}
+
+	hits := make([]*model.SearchHit, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		roomID, err := uuid.Parse(fmt.Sprint(hit.Fields["room"]))
+		if err != nil {
+			continue
+		}
+		from, _ := uuid.Parse(fmt.Sprint(hit.Fields["from"]))
+		timestamp, _ := hit.Fields["timestamp"].(float64)
+		marked, _ := highlight(fmt.Sprint(hit.Fields["body"]), terms)
+		hits = append(hits, &model.SearchHit{
+			ChatRoomID: roomID,
+			MessageID:  hit.ID[len(roomID.String())+1:],
+			From:       from,
+			Timestamp:  int64(timestamp),
+			Snippet:    snippetHTML(marked),
+		})
+	}
+	return hits, nil
+}
+
+func bleveDocID(roomID uuid.UUID, id string) string {
+	return roomID.String() + "/" + id
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+)
+
+// MemorySearchIndex matches messages containing words that start with every
+// query term. It is meant for local runs and tests.
+type MemorySearchIndex struct {
+	mu       sync.RWMutex
+	messages map[uuid.UUID]map[string]model.Message
+}
+
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{messages: make(map[uuid.UUID]map[string]model.Message)}
+}
+
+func (s *MemorySearchIndex) Index(_ context.Context, msg *model.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, ok := s.messages[msg.ChatRoomId]
+	if !ok {
+		room = make(map[string]model.Message)
+		s.messages[msg.ChatRoomId] = room
+	}
+	room[msg.ID] = model.Message{ID: msg.ID, ChatRoomId: msg.ChatRoomId, From: msg.From, Timestamp: msg.Timestamp, Body: msg.Body}
+	return nil
+}
+
+func (s *MemorySearchIndex) Remove(_ context.Context, roomID uuid.UUID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.messages[roomID], id)
+	return nil
+}
+
+func (s *MemorySearchIndex) Search(_ context.Context, roomIDs []uuid.UUID, query string, limit int) ([]*model.SearchHit, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hits []*model.SearchHit
+	for _, roomID := range roomIDs {
+		for _, msg := range s.messages[roomID] {
+			if !matchesAll(msg.Body, terms) {
+				continue
+			}
+			marked, _ := highlight(msg.Body, terms)
+			hits = append(hits, &model.SearchHit{
+				ChatRoomID: msg.ChatRoomId,
+				MessageID:  msg.ID,
+				From:       msg.From,
+				Timestamp:  msg.Timestamp,
+				Snippet:    snippetHTML(marked),
+			})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Timestamp > hits[j].Timestamp
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func matchesAll(body string, terms []string) bool {
+	for _, term := range terms {
+		if _, ok := highlight(body, []string{term}); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchEntry is a row of chat_search. Its document column is generated by
+// Postgres from body and never written from here.
+type searchEntry struct {
+	ChatRoomID uuid.UUID `gorm:"column:chat_room_id;type:uuid;primaryKey"`
+	MessageID  string    `gorm:"column:message_id;type:text;primaryKey"`
+	SenderID   uuid.UUID `gorm:"column:sender_id;type:uuid"`
+	Timestamp  int64     `gorm:"column:time_stamp"`
+	Body       string    `gorm:"column:body"`
+}
+
+func (searchEntry) TableName() string { return "chat_search" }
+
+// PostgresSearchIndex searches the chat_search table through its tsvector
+// column.
+type PostgresSearchIndex struct {
+	log *logrus.Logger
+	db  *gorm.DB
+}
+
+func NewPostgresSearchIndex(log *logrus.Logger, db *gorm.DB) *PostgresSearchIndex {
+	return &PostgresSearchIndex{
+		log: log,
+		db:  db,
+	}
+}
+
+func (s *PostgresSearchIndex) Index(ctx context.Context, msg *model.Message) error {
+	entry := searchEntry{
+		ChatRoomID: msg.ChatRoomId,
+		MessageID:  msg.ID,
+		SenderID:   msg.From,
+		Timestamp:  msg.Timestamp,
+		Body:       msg.Body,
+	}
+	err := s.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "chat_room_id"}, {Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"body"}),
+		}).
+		Create(&entry).Error
+	if err != nil {
+		s.log.WithField("chat_room_id", msg.ChatRoomId).Errorf("Failed to index message: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresSearchIndex) Remove(ctx context.Context, roomID uuid.UUID, id string) error {
+	err := s.db.
+		WithContext(ctx).
+		Where("chat_room_id = ? AND message_id = ?", roomID, id).
+		Delete(&searchEntry{}).Error
+	if err != nil {
+		s.log.WithField("chat_room_id", roomID).Errorf("Failed to remove message from index: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresSearchIndex) Search(ctx context.Context, roomIDs []uuid.UUID, query string, limit int) ([]*model.SearchHit, error) {
+	var rows []struct {
+		ChatRoomID uuid.UUID
+		MessageID  string
+		SenderID   uuid.UUID
+		TimeStamp  int64
+		Snippet    string
+	}
+	err := s.db.
+		WithContext(ctx).
+		Raw(`SELECT chat_room_id, message_id, sender_id, time_stamp,
+		        ts_headline('english', body, q, 'StartSel=`+markStart+`, StopSel=`+markStop+`, MaxFragments=2, MaxWords=20, MinWords=5') AS snippet
+		     FROM chat_search, websearch_to_tsquery('english', ?) q
+		     WHERE chat_room_id IN ? AND document @@ q
+		     ORDER BY ts_rank(document, q) DESC, time_stamp DESC
+		     LIMIT ?`, query, roomIDs, limit).
+		Scan(&rows).Error
+	if err != nil {
+		s.log.Errorf("Failed to search messages: %v", err)
+		return nil, err
+	}
+
+	hits := make([]*model.SearchHit, 0, len(rows))
+	for _, row := range rows {
+		hits = append(hits, &model.SearchHit{
+			ChatRoomID: row.ChatRoomID,
+			MessageID:  row.MessageID,
+			From:       row.SenderID,
+			Timestamp:  row.TimeStamp,
+			Snippet:    snippetHTML(row.Snippet),
+		})
+	}
+	return hits, nil
+}
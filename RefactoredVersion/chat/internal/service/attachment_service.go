@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment exceeds the maximum size")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAttachmentNotReady = errors.New("attachment has not been uploaded yet")
+	ErrAttachmentSize     = errors.New("uploaded attachment does not have its declared size")
+)
+
+// attachmentTypes maps the allowed MIME types to the extension used for the
+// object key.
+var attachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"text/plain":      ".txt",
+}
+
+type AttachmentService struct {
+	log         *logrus.Logger
+	repo        *repository.AttachmentRepo
+	store       repository.ObjectStore
+	chatSrv     *ChatService
+	maxSize     int64
+	uploadTTL   time.Duration
+	downloadTTL time.Duration
+}
+
+func NewAttachmentService(
+	log *logrus.Logger,
+	v *viper.Viper,
+	repo *repository.AttachmentRepo,
+	store repository.ObjectStore,
+	chatSrv *ChatService,
+) *AttachmentService {
+	v.SetDefault("attachments.max-size", 10<<20)
+	v.SetDefault("attachments.upload-url-ttl", 15*time.Minute)
+	v.SetDefault("attachments.download-url-ttl", 10*time.Minute)
+
+	return &AttachmentService{
+		log:         log,
+		repo:        repo,
+		store:       store,
+		chatSrv:     chatSrv,
+		maxSize:     v.GetInt64("attachments.max-size"),
+		uploadTTL:   v.GetDuration("attachments.upload-url-ttl"),
+		downloadTTL: v.GetDuration("attachments.download-url-ttl"),
+	}
+}
+
+// CreateUpload registers a pending attachment and returns a presigned URL the
+// client uploads the file to directly.
+func (s *AttachmentService) CreateUpload(ctx context.Context, userId, roomId uuid.UUID, req *model.AttachmentUploadRequest) (*model.AttachmentUpload, error) {
+	if _, err := s.chatSrv.GetChatRoomForParticipant(ctx, userId, roomId); err != nil {
+		return nil, err
+	}
+	contentType := baseMediaType(req.ContentType)
+	if err := s.validate(contentType, req.Size); err != nil {
+		return nil, err
+	}
+
+	attachment := s.newAttachment(userId, roomId, req.FileName, contentType, req.Size)
+	uploadURL, err := s.store.PresignPut(ctx, attachment.ObjectKey, contentType, req.Size, s.uploadTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, attachment); err != nil {
+		return nil, err
+	}
+	return &model.AttachmentUpload{
+		Attachment: attachment,
+		UploadURL:  uploadURL,
+		ExpiresAt:  time.Now().Add(s.uploadTTL),
+	}, nil
+}
+
+// Upload streams a multipart file to the object store. The content type is
+// sniffed from the file itself rather than trusted from the client.
+func (s *AttachmentService) Upload(ctx context.Context, userId, roomId uuid.UUID, fileName string, size int64, body io.ReadSeeker) (*model.Attachment, error) {
+	if _, err := s.chatSrv.GetChatRoomForParticipant(ctx, userId, roomId); err != nil {
+		return nil, err
+	}
+	if size > s.maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("read attachment: %w", err)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind attachment: %w", err)
+	}
+	contentType := baseMediaType(http.DetectContentType(head[:n]))
+	if err := s.validate(contentType, size); err != nil {
+		return nil, err
+	}
+
+	attachment := s.newAttachment(userId, roomId, fileName, contentType, size)
+	attachment.Status = model.AttachmentUploaded
+	if err := s.store.Put(ctx, attachment.ObjectKey, contentType, size, body); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, attachment); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Resolve returns an attachment the user may reference in a message of the
+// room. A pending presigned upload is checked against the object store and
+// promoted to uploaded on first use: it must have the declared size, and its
+// content type is sniffed from the stored bytes, as Upload does, since the
+// client set the one the store reports.
+func (s *AttachmentService) Resolve(ctx context.Context, userId, roomId, attachmentId uuid.UUID) (*model.Attachment, error) {
+	attachment, err := s.repo.GetById(ctx, attachmentId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attachment.OwnerID != userId || attachment.ChatRoomID != roomId {
+		return nil, ErrAttachmentNotFound
+	}
+	if attachment.Status == model.AttachmentUploaded {
+		return attachment, nil
+	}
+
+	size, contentType, err := s.store.Stat(ctx, attachment.ObjectKey)
+	if errors.Is(err, repository.ErrObjectNotFound) {
+		return nil, ErrAttachmentNotReady
+	}
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" && baseMediaType(contentType) != attachment.ContentType {
+		return nil, ErrAttachmentType
+	}
+	if size > s.maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	if size != attachment.Size {
+		return nil, ErrAttachmentSize
+	}
+	head, err := s.store.Head(ctx, attachment.ObjectKey, 512)
+	if errors.Is(err, repository.ErrObjectNotFound) {
+		return nil, ErrAttachmentNotReady
+	}
+	if err != nil {
+		return nil, err
+	}
+	if baseMediaType(http.DetectContentType(head)) != attachment.ContentType {
+		return nil, ErrAttachmentType
+	}
+	if err := s.repo.MarkUploaded(ctx, attachment.ID, size); err != nil {
+		return nil, err
+	}
+	attachment.Status = model.AttachmentUploaded
+	attachment.Size = size
+	return attachment, nil
+}
+
+func (s *AttachmentService) DownloadURL(ctx context.Context, attachment *model.Attachment) (string, error) {
+	return s.store.PresignGet(ctx, attachment.ObjectKey, attachment.FileName, s.downloadTTL)
+}
+
+// DownloadURLById is DownloadURL for callers that only hold the id, such as
+// replayed unread frames whose earlier URL may have expired.
+func (s *AttachmentService) DownloadURLById(ctx context.Context, attachmentId uuid.UUID) (string, error) {
+	attachment, err := s.repo.GetById(ctx, attachmentId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrAttachmentNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return s.DownloadURL(ctx, attachment)
+}
+
+// FillDownloadURLs sets ImageUrl of every message that references an
+// attachment to a fresh download URL.
+func (s *AttachmentService) FillDownloadURLs(ctx context.Context, messages []*model.Message) error {
+	var ids []uuid.UUID
+	for _, msg := range messages {
+		if msg.AttachmentID != nil {
+			ids = append(ids, *msg.AttachmentID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	attachments, err := s.repo.GetByIds(ctx, ids)
+	if err != nil {
+		return err
+	}
+	urls := make(map[uuid.UUID]string, len(attachments))
+	for _, attachment := range attachments {
+		url, err := s.DownloadURL(ctx, attachment)
+		if err != nil {
+			return err
+		}
+		urls[attachment.ID] = url
+	}
+	for _, msg := range messages {
+		if msg.AttachmentID != nil {
+			msg.ImageUrl = urls[*msg.AttachmentID]
+		}
+	}
+	return nil
+}
+
+// TranscriptAttachments describes the given attachments with download
+// links valid for ttl, for exports that outlive the usual download URL.
+func (s *AttachmentService) TranscriptAttachments(ctx context.Context, ids []uuid.UUID, ttl time.Duration) (map[uuid.UUID]*model.TranscriptAttachment, error) {
+	if len(ids) == 0 {
+		return map[uuid.UUID]*model.TranscriptAttachment{}, nil
+	}
+	attachments, err := s.repo.GetByIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	described := make(map[uuid.UUID]*model.TranscriptAttachment, len(attachments))
+	for _, attachment := range attachments {
+		url, err := s.store.PresignGet(ctx, attachment.ObjectKey, attachment.FileName, ttl)
+		if err != nil {
+			return nil, err
+		}
+		described[attachment.ID] = &model.TranscriptAttachment{
+			ID:          attachment.ID,
+			FileName:    attachment.FileName,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+			URL:         url,
+		}
+	}
+	return described, nil
+}
+
+// OpenLocal serves files of the local object store behind signed URLs.
+func (s *AttachmentService) OpenLocal(key, expires, signature string) (io.ReadCloser, error) {
+	local, ok := s.store.(*repository.LocalObjectStore)
+	if !ok {
+		return nil, repository.ErrObjectNotFound
+	}
+	return local.OpenSigned(key, expires, signature)
+}
+
+func (s *AttachmentService) validate(contentType string, size int64) error {
+	if _, ok := attachmentTypes[contentType]; !ok {
+		return ErrAttachmentType
+	}
+	if size <= 0 || size > s.maxSize {
+		return ErrAttachmentTooLarge
+	}
+	return nil
+}
+
+func (s *AttachmentService) newAttachment(userId, roomId uuid.UUID, fileName, contentType string, size int64) *model.Attachment {
+	id := uuid.New()
+	fileName = path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	if fileName == "." || fileName == "/" {
+		fileName = id.String() + attachmentTypes[contentType]
+	}
+	return &model.Attachment{
+		ID:          id,
+		ChatRoomID:  roomId,
+		OwnerID:     userId,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        size,
+		ObjectKey:   fmt.Sprintf("%s/%s/%s%s", roomId, userId, id, attachmentTypes[contentType]),
+		Status:      model.AttachmentPending,
+	}
+}
+
+func baseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
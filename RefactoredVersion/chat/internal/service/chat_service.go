@@ -2,29 +2,64 @@ package service
 
 import (
 	"context"
+	"errors"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"strings"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
+	defaultSearchLimit  = 20
+	maxSearchLimit      = 50
+)
+
+var (
+	ErrChatRoomNotFound = errors.New("chat room not found")
+	ErrNotParticipant   = errors.New("user is not a participant of the chat room")
+	ErrEmptySearchQuery = errors.New("search query can not be empty")
+	ErrInvalidRole      = errors.New("members can only join as moderator or observer")
+	ErrMemberNotAllowed = errors.New("not allowed to change the members of this chat room")
+	ErrFixedMember      = errors.New("buyer and seller can not leave the chat room")
+	ErrMemberNotFound   = errors.New("user is not a member of the chat room")
+	ErrRoleChange       = errors.New("only platform moderators can change the role of a member")
 )
 
 type ChatService struct {
-	log  *logrus.Logger
-	v    *viper.Viper
-	repo *repository.ChatRepository
+	log      *logrus.Logger
+	v        *viper.Viper
+	repo     *repository.ChatRepository
+	msgStore repository.MessageStore
+	unread   *UnreadService
+	index    repository.SearchIndex
 }
 
-func NewChatService(log *logrus.Logger, v *viper.Viper, repo *repository.ChatRepository) *ChatService {
+func NewChatService(log *logrus.Logger, v *viper.Viper, repo *repository.ChatRepository, msgStore repository.MessageStore, unread *UnreadService, index repository.SearchIndex) *ChatService {
 	return &ChatService{
-		log:  log,
-		v:    v,
-		repo: repo,
+		log:      log,
+		v:        v,
+		repo:     repo,
+		msgStore: msgStore,
+		unread:   unread,
+		index:    index,
 	}
 }
 
-func (s ChatService) GetChatRoomByOrderId(ctx context.Context, id uuid.UUID) {
-
+// GetChatRoomByOrderId loads the room of an order for one of its members.
+func (s ChatService) GetChatRoomByOrderId(ctx context.Context, userId, orderId uuid.UUID) (*model.ChatRoom, error) {
+	chatRoom, err := s.getOrderChatRoom(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if !chatRoom.IsParticipant(userId) {
+		return nil, ErrNotParticipant
+	}
+	return chatRoom, nil
 }
 
 func (s ChatService) GetAllChatRoomByUserId(ctx context.Context, id uuid.UUID) ([]*model.ChatRoom, error) {
@@ -32,5 +67,196 @@ func (s ChatService) GetAllChatRoomByUserId(ctx context.Context, id uuid.UUID) (
 	if err != nil {
 		return nil, err
 	}
+
+	counts, err := s.unread.Counts(ctx, id)
+	if err != nil {
+		s.log.Warnf("unread counts for %s: %v", id, err)
+		return chatsForUser, nil
+	}
+	for _, chatRoom := range chatsForUser {
+		chatRoom.UnreadCount = counts[chatRoom.ChatRoomID]
+	}
 	return chatsForUser, nil
 }
+
+// RoomIDs returns the ids of the rooms the user takes part in, as a
+// participant or as a member.
+func (s ChatService) RoomIDs(ctx context.Context, userId uuid.UUID) ([]uuid.UUID, error) {
+	rooms, err := s.repo.GetAllChatRoomByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(rooms))
+	for _, room := range rooms {
+		ids = append(ids, room.ChatRoomID)
+	}
+	return ids, nil
+}
+
+// GetChatRoomForParticipant loads the room and makes sure the user is part of it.
+func (s ChatService) GetChatRoomForParticipant(ctx context.Context, userId, roomId uuid.UUID) (*model.ChatRoom, error) {
+	chatRoom, err := s.getChatRoom(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	if !chatRoom.IsParticipant(userId) {
+		return nil, ErrNotParticipant
+	}
+	return chatRoom, nil
+}
+
+// GetRoomMessages pages backwards through the room history, newest first.
+func (s ChatService) GetRoomMessages(ctx context.Context, userId, roomId uuid.UUID, before model.MessageCursor, limit int) (*model.MessagePage, error) {
+	if _, err := s.GetChatRoomForParticipant(ctx, userId, roomId); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	messages, err := s.msgStore.ListByRoom(ctx, roomId, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range messages {
+		msg.Redact()
+	}
+	page := &model.MessagePage{Messages: messages}
+	if len(messages) == limit {
+		last := messages[len(messages)-1]
+		page.NextBefore = last.Timestamp
+		page.NextBeforeID = last.ID
+	}
+	return page, nil
+}
+
+// Search looks for messages across every room the user takes part in.
+func (s ChatService) Search(ctx context.Context, userId uuid.UUID, query string, limit int) ([]*model.SearchHit, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, ErrEmptySearchQuery
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	rooms, err := s.repo.GetAllChatRoomByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(rooms) == 0 {
+		return []*model.SearchHit{}, nil
+	}
+	roomIds := make([]uuid.UUID, 0, len(rooms))
+	for _, room := range rooms {
+		roomIds = append(roomIds, room.ChatRoomID)
+	}
+	hits, err := s.index.Search(ctx, roomIds, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	if hits == nil {
+		hits = []*model.SearchHit{}
+	}
+	return hits, nil
+}
+
+// AddMember lets a moderator or observer into a room. Platform moderators
+// may add anyone, including themselves, and change the role of existing
+// members; the moderator of the room may only add observers.
+func (s ChatService) AddMember(ctx context.Context, actorId, roomId uuid.UUID, platformModerator bool, req *model.RoomMemberRequest) (*model.RoomMember, error) {
+	if req.Role != model.RoleModerator && req.Role != model.RoleObserver {
+		return nil, ErrInvalidRole
+	}
+	if req.UserID == uuid.Nil {
+		req.UserID = actorId
+	}
+	chatRoom, err := s.getChatRoom(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	role, isMember := chatRoom.RoleOf(req.UserID)
+	if isMember && (role == model.RoleBuyer || role == model.RoleSeller) {
+		return nil, ErrFixedMember
+	}
+	if !platformModerator {
+		actorRole, ok := chatRoom.RoleOf(actorId)
+		if !ok {
+			return nil, ErrNotParticipant
+		}
+		// observers can read the whole history, so buyer and seller can not
+		// hand that out to anyone they like
+		if req.Role != model.RoleObserver || actorRole != model.RoleModerator {
+			return nil, ErrMemberNotAllowed
+		}
+		if isMember && role != req.Role {
+			return nil, ErrRoleChange
+		}
+	}
+
+	member := &model.RoomMember{
+		ChatRoomID: roomId,
+		UserID:     req.UserID,
+		Role:       req.Role,
+		AddedBy:    actorId,
+	}
+	if err := s.repo.SaveMember(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveMember takes a moderator or observer out of a room. Everyone may
+// leave on their own; otherwise the rules of AddMember apply.
+func (s ChatService) RemoveMember(ctx context.Context, actorId, roomId, userId uuid.UUID, platformModerator bool) (*model.RoomMember, error) {
+	chatRoom, err := s.getChatRoom(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := chatRoom.RoleOf(userId)
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+	if role == model.RoleBuyer || role == model.RoleSeller {
+		return nil, ErrFixedMember
+	}
+	if actorId != userId && !platformModerator {
+		actorRole, ok := chatRoom.RoleOf(actorId)
+		if !ok {
+			return nil, ErrNotParticipant
+		}
+		if role != model.RoleObserver || !actorRole.CanPost() {
+			return nil, ErrMemberNotAllowed
+		}
+	}
+
+	removed, err := s.repo.DeleteMember(ctx, roomId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrMemberNotFound
+	}
+	return &model.RoomMember{ChatRoomID: roomId, UserID: userId, Role: role}, nil
+}
+
+func (s ChatService) getOrderChatRoom(ctx context.Context, orderId uuid.UUID) (*model.ChatRoom, error) {
+	chatRoom, err := s.repo.GetChatRoomByOrderId(ctx, orderId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChatRoomNotFound
+	}
+	return chatRoom, err
+}
+
+func (s ChatService) getChatRoom(ctx context.Context, roomId uuid.UUID) (*model.ChatRoom, error) {
+	chatRoom, err := s.repo.GetChatRoomById(ctx, roomId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChatRoomNotFound
+	}
+	return chatRoom, err
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const maxDeliveryAttachments = 10
+
+var (
+	ErrEmptyDelivery        = errors.New("a delivery needs a message or attachments")
+	ErrTooManyAttachments   = fmt.Errorf("a delivery can have at most %d attachments", maxDeliveryAttachments)
+	ErrNoRevisionsLeft      = errors.New("no revisions left on this order")
+	ErrDeliveryNotPending   = errors.New("delivery is not the latest one awaiting a response")
+	ErrDeliveryRoomNotFound = errors.New("order has no chat room to deliver into")
+)
+
+// RoomPoster posts messages written by the server on behalf of a user into
+// a chat room. The websocket handler implements it.
+type RoomPoster interface {
+	PostMessage(ctx context.Context, room *model.ChatRoom, msg *model.Message) error
+}
+
+// DeliveryService runs the delivery loop of an order: the seller delivers,
+// the buyer accepts or asks for a revision, as long as the package has
+// revisions left. Every step is also posted into the order's chat room.
+type DeliveryService struct {
+	log        *logrus.Logger
+	repo       *repository.OrderRepo
+	orders     *OrderService
+	chatSrv    *ChatService
+	attachSrv  *AttachmentService
+	moderation *ModerationService
+	poster     RoomPoster
+}
+
+func NewDeliveryService(
+	log *logrus.Logger,
+	repo *repository.OrderRepo,
+	orders *OrderService,
+	chatSrv *ChatService,
+	attachSrv *AttachmentService,
+	moderation *ModerationService,
+	poster RoomPoster,
+) *DeliveryService {
+	return &DeliveryService{
+		log:        log,
+		repo:       repo,
+		orders:     orders,
+		chatSrv:    chatSrv,
+		attachSrv:  attachSrv,
+		moderation: moderation,
+		poster:     poster,
+	}
+}
+
+// Deliver hands in work for an order in progress or under revision. The
+// attachments must have been uploaded by the seller to the order's chat room.
+func (s *DeliveryService) Deliver(ctx context.Context, sellerId, orderId uuid.UUID, req *model.DeliveryRequest) (*model.OrderDelivery, error) {
+	order, role, err := s.orders.orderFor(sellerId, orderId, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStep(order.Status, model.OrderDelivered, role); err != nil {
+		return nil, err
+	}
+	message := strings.TrimSpace(req.Message)
+	if message == "" && len(req.AttachmentIDs) == 0 {
+		return nil, ErrEmptyDelivery
+	}
+	if len(req.AttachmentIDs) > maxDeliveryAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	room, err := s.room(ctx, sellerId, orderId)
+	if err != nil {
+		return nil, err
+	}
+	attachments := make([]*model.Attachment, 0, len(req.AttachmentIDs))
+	for _, id := range req.AttachmentIDs {
+		attachment, err := s.attachSrv.Resolve(ctx, sellerId, room.ChatRoomID, id)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if message != "" {
+		verdict, err := s.moderation.Review(ctx, &model.Message{ID: uuid.NewString(), ChatRoomId: room.ChatRoomID, From: sellerId, Body: message})
+		if err != nil {
+			return nil, err
+		}
+		message = verdict.Body
+	}
+
+	delivery := &model.OrderDelivery{
+		OrderID:       orderId,
+		SellerID:      sellerId,
+		Message:       message,
+		AttachmentIDs: req.AttachmentIDs,
+	}
+	delivered, err := s.repo.DeliverOrder(ctx, delivery, order.Status, time.Now().UTC(), message)
+	if err != nil {
+		return nil, stepError(err)
+	}
+	s.orders.changed(ctx, sellerId, delivered)
+
+	body := fmt.Sprintf("Delivery #%d", delivery.Number)
+	if message != "" {
+		body += "\n\n" + message
+	}
+	messages := []*model.Message{{From: sellerId, Body: body}}
+	for i, attachment := range attachments {
+		if i == 0 {
+			messages[0].AttachmentID = &attachment.ID
+			continue
+		}
+		messages = append(messages, &model.Message{From: sellerId, Body: attachment.FileName, AttachmentID: &attachment.ID})
+	}
+	s.post(ctx, room, messages...)
+	return delivery, nil
+}
+
+// Accept completes the order with the pending delivery and releases its
+// payment to the seller.
+func (s *DeliveryService) Accept(ctx context.Context, buyerId, orderId, deliveryId uuid.UUID, req *model.DeliveryResponseRequest) (*model.OrderDelivery, error) {
+	order, role, err := s.orders.orderFor(buyerId, orderId, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStep(order.Status, model.OrderCompleted, role); err != nil {
+		return nil, err
+	}
+
+	note, err := s.reviewNote(ctx, buyerId, orderId, req.Note)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	columns := map[string]interface{}{"completed_at": now}
+	completed, delivery, err := s.repo.RespondToDelivery(ctx, orderId, deliveryId, buyerId, order.Status, model.OrderCompleted, model.DeliveryAccepted, now, columns, note)
+	if err != nil {
+		return nil, stepError(err)
+	}
+	_ = s.orders.settle(ctx, completed)
+	s.orders.changed(ctx, buyerId, completed)
+
+	body := fmt.Sprintf("Accepted delivery #%d", delivery.Number)
+	if note != "" {
+		body += "\n\n" + note
+	}
+	s.postAs(ctx, buyerId, orderId, body)
+	return delivery, nil
+}
+
+// RequestRevision sends the pending delivery back to the seller. It uses up
+// one of the revisions of the package the order was placed with.
+func (s *DeliveryService) RequestRevision(ctx context.Context, buyerId, orderId, deliveryId uuid.UUID, req *model.DeliveryResponseRequest) (*model.OrderDelivery, error) {
+	order, role, err := s.orders.orderFor(buyerId, orderId, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStep(order.Status, model.OrderRevisionRequested, role); err != nil {
+		return nil, err
+	}
+	allowed, err := s.revisionsAllowed(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	if order.RevisionsUsed >= allowed {
+		return nil, fmt.Errorf("%w: %d of %d used", ErrNoRevisionsLeft, order.RevisionsUsed, allowed)
+	}
+	note, err := s.reviewNote(ctx, buyerId, orderId, req.Note)
+	if err != nil {
+		return nil, err
+	}
+
+	// the row is locked and the status checked again when this is written,
+	// so two concurrent requests can not both use the same revision
+	columns := map[string]interface{}{"revisions_used": order.RevisionsUsed + 1}
+	updated, delivery, err := s.repo.RespondToDelivery(ctx, orderId, deliveryId, buyerId, order.Status, model.OrderRevisionRequested, model.DeliveryRevisionRequested, time.Now().UTC(), columns, note)
+	if err != nil {
+		return nil, stepError(err)
+	}
+	s.orders.changed(ctx, buyerId, updated)
+
+	body := fmt.Sprintf("Requested a revision of delivery #%d (%d of %d revisions used)", delivery.Number, order.RevisionsUsed+1, allowed)
+	if note != "" {
+		body += "\n\n" + note
+	}
+	s.postAs(ctx, buyerId, orderId, body)
+	return delivery, nil
+}
+
+// Deliveries lists the deliveries of an order, oldest first.
+func (s *DeliveryService) Deliveries(ctx context.Context, userId, orderId uuid.UUID, platformModerator bool) ([]*model.OrderDelivery, error) {
+	if _, _, err := s.orders.orderFor(userId, orderId, platformModerator); err != nil {
+		return nil, err
+	}
+	return s.repo.GetDeliveries(ctx, orderId)
+}
+
+// revisionsAllowed reads the revision count from the package snapshot, and
+// from the package itself for orders placed before snapshots existed.
+func (s *DeliveryService) revisionsAllowed(ctx context.Context, order *model.Order) (int, error) {
+	if order.PackageSnapshot != nil {
+		return order.PackageSnapshot.Revisions, nil
+	}
+	pkg, err := s.repo.GetGigPackage(ctx, order.PackageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return pkg.Revisions, nil
+}
+
+func (s *DeliveryService) room(ctx context.Context, userId, orderId uuid.UUID) (*model.ChatRoom, error) {
+	room, err := s.chatSrv.GetChatRoomByOrderId(ctx, userId, orderId)
+	if errors.Is(err, ErrChatRoomNotFound) {
+		return nil, ErrDeliveryRoomNotFound
+	}
+	return room, err
+}
+
+// reviewNote runs the buyer's note through moderation, like the message of a
+// delivery, before it is stored with the delivery and posted to the room.
+func (s *DeliveryService) reviewNote(ctx context.Context, buyerId, orderId uuid.UUID, note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return "", nil
+	}
+	room, err := s.room(ctx, buyerId, orderId)
+	if err != nil {
+		return "", err
+	}
+	verdict, err := s.moderation.Review(ctx, &model.Message{ID: uuid.NewString(), ChatRoomId: room.ChatRoomID, From: buyerId, Body: note})
+	if err != nil {
+		return "", err
+	}
+	return verdict.Body, nil
+}
+
+// postAs posts a note of the buyer into the order's chat room. The step it
+// reports is already stored, so failures are only logged.
+func (s *DeliveryService) postAs(ctx context.Context, userId, orderId uuid.UUID, body string) {
+	room, err := s.room(ctx, userId, orderId)
+	if err != nil {
+		s.log.Errorf("post to chat room of order %s: %v", orderId, err)
+		return
+	}
+	s.post(ctx, room, &model.Message{From: userId, Body: body})
+}
+
+func (s *DeliveryService) post(ctx context.Context, room *model.ChatRoom, messages ...*model.Message) {
+	// messages of a room are keyed by their millisecond timestamp
+	base := time.Now().UTC().UnixMilli()
+	for i, msg := range messages {
+		msg.ID = uuid.NewString()
+		msg.Timestamp = base + int64(i)
+		if err := s.poster.PostMessage(ctx, room, msg); err != nil {
+			s.log.Errorf("post to chat room %s: %v", room.ChatRoomID, err)
+		}
+	}
+}
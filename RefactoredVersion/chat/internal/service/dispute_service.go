@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	maxDisputeEvidence  = 20
+	defaultDisputeLimit = 50
+	maxDisputeLimit     = 200
+)
+
+var (
+	ErrDisputeNotFound      = errors.New("dispute not found")
+	ErrDisputeClosed        = errors.New("dispute is no longer open")
+	ErrDisputeReason        = errors.New("a dispute needs a reason")
+	ErrTooMuchEvidence      = fmt.Errorf("at most %d pieces of evidence can be submitted at once", maxDisputeEvidence)
+	ErrDisputeModeratorOnly = errors.New("only moderators can assign and rule on disputes")
+	ErrModeratorIsParty     = errors.New("a party of the order can not moderate its dispute")
+	ErrDisputeNotAssigned   = errors.New("dispute has to be assigned before it is ruled on")
+	ErrNotAssignedModerator = errors.New("dispute is assigned to another moderator")
+	ErrInvalidRuling        = errors.New("ruling must be full_refund, partial_refund or release")
+	ErrInvalidRefundAmount  = errors.New("refund_amount of a partial refund must be above zero and below the order's price")
+)
+
+// DisputeService escalates disagreements over an order. The buyer or seller
+// opens a dispute with evidence from the order's chat, which moves the order
+// to DISPUTED; a moderator takes it on, joins the chat room and rules. The
+// ruling moves the order and its escrow: a full refund cancels the order, a
+// partial refund and a release complete it.
+type DisputeService struct {
+	log     *logrus.Logger
+	repo    *repository.OrderRepo
+	orders  *OrderService
+	chatSrv *ChatService
+	store   repository.MessageStore
+}
+
+func NewDisputeService(
+	log *logrus.Logger,
+	repo *repository.OrderRepo,
+	orders *OrderService,
+	chatSrv *ChatService,
+	store repository.MessageStore,
+) *DisputeService {
+	return &DisputeService{
+		log:     log,
+		repo:    repo,
+		orders:  orders,
+		chatSrv: chatSrv,
+		store:   store,
+	}
+}
+
+// Open opens a dispute against an order on behalf of its buyer or seller.
+func (s *DisputeService) Open(ctx context.Context, userId, orderId uuid.UUID, req *model.DisputeOpenRequest) (*model.OrderDispute, error) {
+	order, role, err := s.orders.orderFor(userId, orderId, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStep(order.Status, model.OrderDisputed, role); err != nil {
+		return nil, err
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, ErrDisputeReason
+	}
+
+	dispute := &model.OrderDispute{
+		ID:           uuid.New(),
+		OrderID:      orderId,
+		OpenedBy:     userId,
+		OpenedByRole: role,
+		Reason:       reason,
+	}
+	dispute.Evidence, err = s.evidence(ctx, userId, dispute, req.Evidence)
+	if err != nil {
+		return nil, err
+	}
+	disputed, err := s.repo.OpenDispute(ctx, dispute, order.Status, time.Now().UTC())
+	if err != nil {
+		return nil, stepError(err)
+	}
+	s.log.Infof("dispute %s opened on order %s by %s (%s)", dispute.ID, order.OrderNumber, userId, role)
+	s.orders.changed(ctx, userId, disputed)
+	return dispute, nil
+}
+
+// AddEvidence submits further messages of the order's chat to an open
+// dispute. Only the buyer and seller submit evidence.
+func (s *DisputeService) AddEvidence(ctx context.Context, userId, disputeId uuid.UUID, req *model.DisputeEvidenceRequest) (*model.OrderDispute, error) {
+	dispute, err := s.dispute(ctx, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := s.orders.orderFor(userId, dispute.OrderID, false); err != nil {
+		return nil, err
+	}
+	if dispute.Status != model.DisputeOpen {
+		return nil, ErrDisputeClosed
+	}
+	if len(req.Evidence) == 0 {
+		return dispute, nil
+	}
+	evidence, err := s.evidence(ctx, userId, dispute, req.Evidence)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.AddDisputeEvidence(ctx, dispute, userId, evidence); err != nil {
+		return nil, disputeError(err)
+	}
+	dispute.Evidence = append(dispute.Evidence, evidence...)
+	return dispute, nil
+}
+
+// Assign hands an open dispute to a moderator, the caller by default, and
+// lets them into the order's chat room as a moderator.
+func (s *DisputeService) Assign(ctx context.Context, actorId, disputeId uuid.UUID, platformModerator bool, req *model.DisputeAssignRequest) (*model.OrderDispute, error) {
+	if !platformModerator {
+		return nil, ErrDisputeModeratorOnly
+	}
+	moderatorId := req.ModeratorID
+	if moderatorId == uuid.Nil {
+		moderatorId = actorId
+	}
+	dispute, err := s.dispute(ctx, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	order, err := s.repo.GetOrderById(dispute.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if moderatorId == order.BuyerID || moderatorId == order.SellerID {
+		return nil, ErrModeratorIsParty
+	}
+
+	assigned, err := s.repo.AssignDispute(ctx, disputeId, moderatorId, actorId, time.Now().UTC())
+	if err != nil {
+		return nil, disputeError(err)
+	}
+	assigned.Evidence = dispute.Evidence
+
+	room, err := s.chatSrv.getOrderChatRoom(ctx, order.ID)
+	if err == nil {
+		_, err = s.chatSrv.AddMember(ctx, actorId, room.ChatRoomID, true, &model.RoomMemberRequest{UserID: moderatorId, Role: model.RoleModerator})
+	}
+	if err != nil && !errors.Is(err, ErrChatRoomNotFound) {
+		s.log.Errorf("add moderator %s to chat room of order %s: %v", moderatorId, order.ID, err)
+	}
+	return assigned, nil
+}
+
+// Rule closes a dispute with the decision of its assigned moderator and
+// settles the order's payment accordingly. The ruling stands when the
+// settlement fails; the failure is set on the returned dispute.
+func (s *DisputeService) Rule(ctx context.Context, moderatorId, disputeId uuid.UUID, platformModerator bool, req *model.DisputeRulingRequest) (*model.OrderDispute, error) {
+	if !platformModerator {
+		return nil, ErrDisputeModeratorOnly
+	}
+	if !req.Ruling.Valid() {
+		return nil, ErrInvalidRuling
+	}
+	dispute, err := s.dispute(ctx, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case dispute.Status != model.DisputeOpen:
+		return nil, ErrDisputeClosed
+	case dispute.ModeratorID == nil:
+		return nil, ErrDisputeNotAssigned
+	case *dispute.ModeratorID != moderatorId:
+		return nil, ErrNotAssignedModerator
+	}
+	order, err := s.repo.GetOrderById(dispute.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	to := model.OrderCompleted
+	columns := map[string]interface{}{"completed_at": now}
+	var refund int64
+	switch req.Ruling {
+	case model.RulingFullRefund:
+		to = model.OrderCancelled
+		columns = map[string]interface{}{"cancelled_at": now}
+	case model.RulingPartialRefund:
+		refund = req.RefundAmount
+		if refund <= 0 || refund >= model.MinorUnits(order.Price) {
+			return nil, ErrInvalidRefundAmount
+		}
+	}
+	if err := checkStep(order.Status, to, model.RoleModerator); err != nil {
+		return nil, err
+	}
+
+	dispute.Ruling = req.Ruling
+	dispute.RefundAmount = refund
+	dispute.RulingNote = strings.TrimSpace(req.Note)
+	note := "dispute ruled " + string(req.Ruling)
+	if refund > 0 {
+		note += fmt.Sprintf(" of %d cents", refund)
+	}
+	if dispute.RulingNote != "" {
+		note += ": " + dispute.RulingNote
+	}
+	resolved, err := s.repo.ResolveDispute(ctx, dispute, to, now, columns, note)
+	if err != nil {
+		return nil, disputeError(stepError(err))
+	}
+	s.log.Infof("dispute %s of order %s ruled %s by %s", dispute.ID, order.OrderNumber, req.Ruling, moderatorId)
+	if err := s.orders.settle(ctx, resolved); err != nil {
+		dispute.SettlementError = err.Error()
+	}
+	s.orders.changed(ctx, moderatorId, resolved)
+	return dispute, nil
+}
+
+// Disputes lists the disputes of an order, oldest first.
+func (s *DisputeService) Disputes(ctx context.Context, userId, orderId uuid.UUID, platformModerator bool) ([]*model.OrderDispute, error) {
+	if _, _, err := s.orders.orderFor(userId, orderId, platformModerator); err != nil {
+		return nil, err
+	}
+	return s.repo.GetDisputesByOrder(ctx, orderId)
+}
+
+// Dispute returns one dispute to a party of its order or a moderator.
+func (s *DisputeService) Dispute(ctx context.Context, userId, disputeId uuid.UUID, platformModerator bool) (*model.OrderDispute, error) {
+	dispute, err := s.dispute(ctx, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := s.orders.orderFor(userId, dispute.OrderID, platformModerator); err != nil {
+		return nil, err
+	}
+	return dispute, nil
+}
+
+// Queue lists disputes for moderators, oldest first. A nil moderatorId
+// lists the disputes of every moderator.
+func (s *DisputeService) Queue(ctx context.Context, platformModerator bool, status model.DisputeStatus, moderatorId *uuid.UUID, limit, offset int) ([]*model.OrderDispute, error) {
+	if !platformModerator {
+		return nil, ErrDisputeModeratorOnly
+	}
+	if limit <= 0 {
+		limit = defaultDisputeLimit
+	}
+	if limit > maxDisputeLimit {
+		limit = maxDisputeLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.repo.ListDisputes(ctx, status, moderatorId, limit, offset)
+}
+
+// evidence copies the referenced messages of the order's chat room.
+func (s *DisputeService) evidence(ctx context.Context, userId uuid.UUID, dispute *model.OrderDispute, refs []model.EvidenceRef) ([]*model.DisputeEvidence, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if len(refs) > maxDisputeEvidence {
+		return nil, ErrTooMuchEvidence
+	}
+	room, err := s.chatSrv.GetChatRoomByOrderId(ctx, userId, dispute.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	evidence := make([]*model.DisputeEvidence, 0, len(refs))
+	for _, ref := range refs {
+		msg, err := s.store.Get(ctx, room.ChatRoomID, ref.Timestamp, ref.MessageID)
+		if errors.Is(err, repository.ErrMessageNotFound) || err == nil && msg.DeletedAt != 0 {
+			return nil, fmt.Errorf("%w: %s", ErrMessageNotFound, ref.MessageID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, &model.DisputeEvidence{
+			DisputeID:        dispute.ID,
+			SubmittedBy:      userId,
+			MessageID:        msg.ID,
+			MessageTimestamp: msg.Timestamp,
+			MessageFrom:      msg.From,
+			Body:             msg.Body,
+			AttachmentID:     msg.AttachmentID,
+			Note:             strings.TrimSpace(ref.Note),
+		})
+	}
+	return evidence, nil
+}
+
+func (s *DisputeService) dispute(ctx context.Context, id uuid.UUID) (*model.OrderDispute, error) {
+	dispute, err := s.repo.GetDispute(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDisputeNotFound
+	}
+	return dispute, err
+}
+
+func disputeError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrDisputeClosed):
+		return ErrDisputeClosed
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrDisputeNotFound
+	}
+	return err
+}
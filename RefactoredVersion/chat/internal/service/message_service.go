@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxReactionBytes = 32
+	// maxMessageUpdateAttempts bounds how often a change is retried when the
+	// message keeps changing underneath it.
+	maxMessageUpdateAttempts = 5
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotMessageAuthor = errors.New("only the author can change this message")
+	ErrMessageDeleted   = errors.New("message has been deleted")
+	ErrEmptyMessage     = errors.New("message body is required")
+	ErrInvalidReaction  = errors.New("reaction must be an emoji")
+)
+
+// MessageService changes messages after they were sent: edits and deletes by
+// their author and reactions by either participant. Every change is written
+// back through MessageStore.Update against the version it was read at.
+type MessageService struct {
+	log        *logrus.Logger
+	store      repository.MessageStore
+	moderation *ModerationService
+}
+
+func NewMessageService(log *logrus.Logger, store repository.MessageStore, moderation *ModerationService) *MessageService {
+	return &MessageService{
+		log:        log,
+		store:      store,
+		moderation: moderation,
+	}
+}
+
+// Edit replaces the body of a message and keeps the previous one in its edit
+// history. The new body goes through moderation like a new message, except
+// that an edit does not count against the rate rules.
+func (s *MessageService) Edit(ctx context.Context, userId, roomId uuid.UUID, timestamp int64, id, body string) (*model.Message, error) {
+	msg, err := s.authored(ctx, userId, roomId, timestamp, id)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(body) == "" && msg.AttachmentID == nil {
+		return nil, ErrEmptyMessage
+	}
+	if body == msg.Body {
+		return msg, nil
+	}
+	verdict, err := s.moderation.ReviewEdit(ctx, &model.Message{ID: msg.ID, ChatRoomId: msg.ChatRoomId, From: msg.From, Body: body})
+	if err != nil {
+		return nil, err
+	}
+	body = verdict.Body
+
+	return s.change(ctx, roomId, timestamp, id, func(msg *model.Message) (bool, error) {
+		if err := checkAuthor(msg, userId); err != nil {
+			return false, err
+		}
+		if body == msg.Body {
+			return false, nil
+		}
+		now := time.Now().UTC().UnixMilli()
+		msg.Edits = append(msg.Edits, model.MessageEdit{Body: msg.Body, EditedAt: now})
+		msg.Body = body
+		msg.EditedAt = now
+		return true, nil
+	})
+}
+
+// Delete soft-deletes a message. The stored content is kept, but it is
+// redacted from everything returned to clients.
+func (s *MessageService) Delete(ctx context.Context, userId, roomId uuid.UUID, timestamp int64, id string) (*model.Message, error) {
+	msg, err := s.change(ctx, roomId, timestamp, id, func(msg *model.Message) (bool, error) {
+		if err := checkAuthor(msg, userId); err != nil {
+			return false, err
+		}
+		msg.DeletedAt = time.Now().UTC().UnixMilli()
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.Redact()
+	return msg, nil
+}
+
+// React adds the user's reaction to a message, or takes it back when remove
+// is set. Reacting twice with the same emoji is a no-op.
+func (s *MessageService) React(ctx context.Context, userId, roomId uuid.UUID, timestamp int64, id, reaction string, remove bool) (*model.Message, error) {
+	if !validReaction(reaction) {
+		return nil, ErrInvalidReaction
+	}
+	return s.change(ctx, roomId, timestamp, id, func(msg *model.Message) (bool, error) {
+		if msg.DeletedAt != 0 {
+			return false, ErrMessageDeleted
+		}
+
+		users := msg.Reactions[reaction]
+		index := -1
+		for i, user := range users {
+			if user == userId {
+				index = i
+				break
+			}
+		}
+		switch {
+		case remove && index >= 0:
+			users = append(users[:index], users[index+1:]...)
+		case !remove && index < 0:
+			users = append(users, userId)
+		default:
+			return false, nil
+		}
+
+		if msg.Reactions == nil {
+			msg.Reactions = make(map[string][]uuid.UUID)
+		}
+		if len(users) == 0 {
+			delete(msg.Reactions, reaction)
+		} else {
+			msg.Reactions[reaction] = users
+		}
+		return true, nil
+	})
+}
+
+// change loads the message, applies apply to it and writes it back. When
+// someone else changed the message in between, it starts over from the
+// stored message, so concurrent reactions and edits are all kept. apply
+// reports whether there is anything to write.
+func (s *MessageService) change(ctx context.Context, roomId uuid.UUID, timestamp int64, id string, apply func(msg *model.Message) (bool, error)) (*model.Message, error) {
+	for attempt := 0; ; attempt++ {
+		msg, err := s.get(ctx, roomId, timestamp, id)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := apply(msg)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return msg, nil
+		}
+		err = s.store.Update(ctx, msg)
+		if errors.Is(err, repository.ErrMessageChanged) && attempt < maxMessageUpdateAttempts {
+			continue
+		}
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+func (s *MessageService) authored(ctx context.Context, userId, roomId uuid.UUID, timestamp int64, id string) (*model.Message, error) {
+	msg, err := s.get(ctx, roomId, timestamp, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAuthor(msg, userId); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *MessageService) get(ctx context.Context, roomId uuid.UUID, timestamp int64, id string) (*model.Message, error) {
+	msg, err := s.store.Get(ctx, roomId, timestamp, id)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+// checkAuthor allows changes to a message by its author while it is not
+// deleted.
+func checkAuthor(msg *model.Message, userId uuid.UUID) error {
+	if msg.From != userId {
+		return ErrNotMessageAuthor
+	}
+	if msg.DeletedAt != 0 {
+		return ErrMessageDeleted
+	}
+	return nil
+}
+
+// validReaction accepts a single emoji, including skin tone modifiers and
+// ZWJ sequences, but no text.
+func validReaction(reaction string) bool {
+	if reaction == "" || len(reaction) > maxReactionBytes {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range reaction {
+		switch {
+		case unicode.Is(unicode.So, r):
+			hasSymbol = true
+		case unicode.In(r, unicode.Sk, unicode.Mn, unicode.Me, unicode.Cf):
+		default:
+			return false
+		}
+	}
+	return hasSymbol
+}
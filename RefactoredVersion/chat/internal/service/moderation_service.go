@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	moderationRateKeyPrefix = "moderation_rate:"
+	moderationMask          = "****"
+	defaultFlagLimit        = 50
+	maxFlagLimit            = 200
+)
+
+var (
+	ErrMessageBlocked    = errors.New("message was blocked by moderation")
+	ErrFlagNotFound      = errors.New("no pending moderation flag with this id")
+	ErrInvalidFlagStatus = errors.New("flag status must be dismissed or confirmed")
+)
+
+// ModerationRuleConfig is one entry of moderation.rules. Type is regex
+// (Pattern), keywords (Keywords, matched as whole words, case insensitive)
+// or rate (more than Limit messages by one user within Window).
+type ModerationRuleConfig struct {
+	Name     string                 `mapstructure:"name"`
+	Type     string                 `mapstructure:"type"`
+	Action   model.ModerationAction `mapstructure:"action"`
+	Pattern  string                 `mapstructure:"pattern"`
+	Keywords []string               `mapstructure:"keywords"`
+	Limit    int64                  `mapstructure:"limit"`
+	Window   time.Duration          `mapstructure:"window"`
+}
+
+// defaultModerationRules apply when moderation.rules is not configured.
+var defaultModerationRules = []ModerationRuleConfig{
+	{Name: "email", Type: "regex", Action: model.ModerationMask, Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+	{Name: "phone", Type: "regex", Action: model.ModerationMask, Pattern: `\+?\d[\d\s().-]{7,}\d`},
+	{Name: "wallet", Type: "regex", Action: model.ModerationFlagged, Pattern: `\b(0x[a-fA-F0-9]{40}|(bc1|[13])[a-km-zA-HJ-NP-Z1-9]{25,39})\b`},
+	{Name: "off-platform", Type: "keywords", Action: model.ModerationFlagged, Keywords: []string{"paypal", "venmo", "western union", "cash app", "whatsapp", "telegram", "pay outside"}},
+	{Name: "spike", Type: "rate", Action: model.ModerationBlock, Limit: 20, Window: 10 * time.Second},
+}
+
+// moderationRule is a compiled rule: pattern for regex and keyword rules,
+// limit and window for rate rules.
+type moderationRule struct {
+	name    string
+	action  model.ModerationAction
+	pattern *regexp.Regexp
+	limit   int64
+	window  time.Duration
+}
+
+// ModerationVerdict is the outcome of running a message through the rules.
+// Body is the text to store, with masked parts replaced.
+type ModerationVerdict struct {
+	Action model.ModerationAction
+	Body   string
+	Rule   string
+}
+
+// ModerationService runs chat messages through a chain of rules before
+// they are stored. Rules run in order: allow stops the chain and keeps the
+// message, mask hides the matched text, flag keeps the message but queues it
+// for review, and block rejects it (also queued, so admins see attempts).
+type ModerationService struct {
+	log     *logrus.Logger
+	redis   *redis.Client
+	repo    *repository.ModerationRepo
+	enabled bool
+	rules   []moderationRule
+}
+
+func NewModerationService(log *logrus.Logger, v *viper.Viper, rdb *redis.Client, repo *repository.ModerationRepo) *ModerationService {
+	v.SetDefault("moderation.enabled", true)
+
+	configs := defaultModerationRules
+	if v.IsSet("moderation.rules") {
+		configs = nil
+		if err := v.UnmarshalKey("moderation.rules", &configs); err != nil {
+			log.Fatalf("Invalid moderation.rules: %v", err)
+		}
+	}
+	rules := make([]moderationRule, 0, len(configs))
+	for _, config := range configs {
+		rule, err := compileModerationRule(config)
+		if err != nil {
+			log.Fatalf("Invalid moderation rule %q: %v", config.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return &ModerationService{
+		log:     log,
+		redis:   rdb,
+		repo:    repo,
+		enabled: v.GetBool("moderation.enabled"),
+		rules:   rules,
+	}
+}
+
+func compileModerationRule(config ModerationRuleConfig) (moderationRule, error) {
+	rule := moderationRule{name: config.Name, action: config.Action}
+	if config.Name == "" {
+		return rule, errors.New("name is required")
+	}
+	if !config.Action.Valid() {
+		return rule, fmt.Errorf("unknown action %q", config.Action)
+	}
+
+	var err error
+	switch config.Type {
+	case "regex":
+		rule.pattern, err = regexp.Compile(config.Pattern)
+	case "keywords":
+		if len(config.Keywords) == 0 {
+			return rule, errors.New("keywords are required")
+		}
+		quoted := make([]string, 0, len(config.Keywords))
+		for _, keyword := range config.Keywords {
+			quoted = append(quoted, regexp.QuoteMeta(strings.TrimSpace(keyword)))
+		}
+		rule.pattern, err = regexp.Compile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	case "rate":
+		if config.Limit <= 0 || config.Window <= 0 {
+			return rule, errors.New("limit and window must be positive")
+		}
+		if config.Action == model.ModerationMask {
+			return rule, errors.New("rate rules can not mask")
+		}
+		rule.limit = config.Limit
+		rule.window = config.Window
+	default:
+		return rule, fmt.Errorf("unknown type %q", config.Type)
+	}
+	return rule, err
+}
+
+// Review runs the message through the rules. Flagged and blocked messages
+// are written to the review queue before Review returns; a blocked message
+// comes back with ErrMessageBlocked.
+func (s *ModerationService) Review(ctx context.Context, msg *model.Message) (*ModerationVerdict, error) {
+	return s.review(ctx, msg, true)
+}
+
+// ReviewEdit runs the new body of an edited message through the rules like
+// Review, but skips the rate rules: an edit is not a new message.
+func (s *ModerationService) ReviewEdit(ctx context.Context, msg *model.Message) (*ModerationVerdict, error) {
+	return s.review(ctx, msg, false)
+}
+
+func (s *ModerationService) review(ctx context.Context, msg *model.Message, rates bool) (*ModerationVerdict, error) {
+	verdict := &ModerationVerdict{Action: model.ModerationAllow, Body: msg.Body}
+	if !s.enabled {
+		return verdict, nil
+	}
+
+	var flags []*model.ModerationFlag
+	for _, rule := range s.rules {
+		if !rates && rule.pattern == nil {
+			continue
+		}
+		matched, err := s.match(ctx, rule, msg.From, verdict.Body)
+		if err != nil {
+			return nil, err
+		}
+		if matched == "" {
+			continue
+		}
+
+		stop := false
+		switch rule.action {
+		case model.ModerationAllow:
+			stop = true
+		case model.ModerationMask:
+			verdict.Body = rule.pattern.ReplaceAllString(verdict.Body, moderationMask)
+			if verdict.Action == model.ModerationAllow {
+				verdict.Action = model.ModerationMask
+			}
+		case model.ModerationFlagged, model.ModerationBlock:
+			flags = append(flags, &model.ModerationFlag{
+				ChatRoomID: msg.ChatRoomId,
+				MessageID:  msg.ID,
+				SenderID:   msg.From,
+				Rule:       rule.name,
+				Action:     rule.action,
+				Body:       msg.Body,
+				Matched:    matched,
+				Status:     model.FlagPending,
+			})
+			verdict.Action = rule.action
+			verdict.Rule = rule.name
+			stop = rule.action == model.ModerationBlock
+		}
+		if stop {
+			break
+		}
+	}
+
+	if len(flags) > 0 {
+		if err := s.repo.CreateFlags(ctx, flags); err != nil {
+			return nil, err
+		}
+	}
+	if verdict.Action == model.ModerationBlock {
+		return verdict, ErrMessageBlocked
+	}
+	return verdict, nil
+}
+
+func (s *ModerationService) match(ctx context.Context, rule moderationRule, userId uuid.UUID, body string) (string, error) {
+	if rule.pattern != nil {
+		return strings.Join(rule.pattern.FindAllString(body, 5), ", "), nil
+	}
+
+	key := fmt.Sprintf("%s%s:%s", moderationRateKeyPrefix, rule.name, userId.String())
+	count, err := s.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return "", fmt.Errorf("moderation rate: %w", err)
+	}
+	if count == 1 {
+		if err := s.redis.Expire(ctx, key, rule.window).Err(); err != nil {
+			return "", fmt.Errorf("moderation rate: %w", err)
+		}
+	}
+	if count > rule.limit {
+		return fmt.Sprintf("%d messages within %s", count, rule.window), nil
+	}
+	return "", nil
+}
+
+// Flags lists the review queue, newest first.
+func (s *ModerationService) Flags(ctx context.Context, status model.FlagStatus, roomId uuid.UUID, limit, offset int) ([]*model.ModerationFlag, error) {
+	if limit <= 0 {
+		limit = defaultFlagLimit
+	}
+	if limit > maxFlagLimit {
+		limit = maxFlagLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.repo.ListFlags(ctx, status, roomId, limit, offset)
+}
+
+// ResolveFlag closes a pending flag as dismissed or confirmed.
+func (s *ModerationService) ResolveFlag(ctx context.Context, reviewerId, flagId uuid.UUID, req *model.FlagReviewRequest) (*model.ModerationFlag, error) {
+	if req.Status != model.FlagDismissed && req.Status != model.FlagConfirmed {
+		return nil, ErrInvalidFlagStatus
+	}
+	flag, err := s.repo.ReviewFlag(ctx, flagId, reviewerId, req.Status, req.Note)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFlagNotFound
+	}
+	return flag, err
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	notificationStreamPrefix = "notification_stream:"
+	defaultNotificationLimit = 20
+	maxNotificationLimit     = 100
+	notificationReplayBatch  = 100
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// NotificationService keeps the notification feed of each user. Every
+// notification is stored first and then appended to the Redis stream
+// notification_stream:{userId}, which live subscribers read. The stream is
+// capped and expires; anything older is replayed from the database.
+type NotificationService struct {
+	log       *logrus.Logger
+	redis     *redis.Client
+	repo      *repository.NotificationRepo
+	maxLen    int64
+	ttl       time.Duration
+	heartbeat time.Duration
+	overlap   time.Duration
+}
+
+func NewNotificationService(log *logrus.Logger, v *viper.Viper, rdb *redis.Client, repo *repository.NotificationRepo) *NotificationService {
+	v.SetDefault("notifications.stream-max-len", 1000)
+	v.SetDefault("notifications.stream-ttl", 7*24*time.Hour)
+	v.SetDefault("notifications.heartbeat", 15*time.Second)
+	v.SetDefault("notifications.replay-overlap", time.Minute)
+	return &NotificationService{
+		log:       log,
+		redis:     rdb,
+		repo:      repo,
+		maxLen:    v.GetInt64("notifications.stream-max-len"),
+		ttl:       v.GetDuration("notifications.stream-ttl"),
+		heartbeat: v.GetDuration("notifications.heartbeat"),
+		overlap:   v.GetDuration("notifications.replay-overlap"),
+	}
+}
+
+// Notify stores a notification for the user and pushes it to their stream.
+// A failed push is only logged, since the notification is replayed from the
+// database when the user reconnects.
+func (s *NotificationService) Notify(ctx context.Context, userId uuid.UUID, kind model.NotificationType, orderId *uuid.UUID, payload map[string]interface{}) (*model.Notification, error) {
+	notification := &model.Notification{
+		UserID:  userId,
+		Type:    kind,
+		OrderID: orderId,
+		Payload: payload,
+	}
+	if err := s.repo.CreateNotification(ctx, notification); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return nil, err
+	}
+	key := notificationStreamPrefix + userId.String()
+	pipe := s.redis.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":   notification.ID,
+			"data": data,
+		},
+	})
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.log.Errorf("push notification %d to %s: %v", notification.ID, key, err)
+	}
+	return notification, nil
+}
+
+// NotifyOrder notifies the user about an order, with the order summary as
+// payload.
+func (s *NotificationService) NotifyOrder(ctx context.Context, userId uuid.UUID, kind model.NotificationType, order *model.Order) {
+	payload := map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"status":       order.Status,
+		"buyer_id":     order.BuyerID,
+		"seller_id":    order.SellerID,
+		"price":        order.Price,
+	}
+	if order.DueDate != nil {
+		payload["due_date"] = order.DueDate
+	}
+	if _, err := s.Notify(ctx, userId, kind, &order.ID, payload); err != nil {
+		s.log.Errorf("failed to notify %s about order %s: %v", userId, order.OrderNumber, err)
+	}
+}
+
+// NotifyParties notifies the buyer and the seller of an order, except the
+// actor who caused the change. uuid.Nil as actor notifies both.
+func (s *NotificationService) NotifyParties(ctx context.Context, actorId uuid.UUID, kind model.NotificationType, order *model.Order) {
+	for _, userId := range []uuid.UUID{order.BuyerID, order.SellerID} {
+		if userId != actorId {
+			s.NotifyOrder(ctx, userId, kind, order)
+		}
+	}
+}
+
+// NotifyReview notifies the user about a review of one of their orders.
+func (s *NotificationService) NotifyReview(ctx context.Context, userId uuid.UUID, kind model.NotificationType, review *model.Review) {
+	payload := map[string]interface{}{
+		"review_id": review.ID,
+		"gig_id":    review.GigID,
+		"rating":    review.Rating,
+		"title":     review.Title,
+	}
+	if _, err := s.Notify(ctx, userId, kind, &review.OrderID, payload); err != nil {
+		s.log.Errorf("failed to notify %s about review %s: %v", userId, review.ID, err)
+	}
+}
+
+// List returns a page of the user's feed, newest first, with the number of
+// unread notifications. Pass the returned NextBefore as before for the next,
+// older page.
+func (s *NotificationService) List(ctx context.Context, userId uuid.UUID, unreadOnly bool, before int64, limit int) (*model.NotificationPage, error) {
+	if limit <= 0 {
+		limit = defaultNotificationLimit
+	}
+	limit = min(limit, maxNotificationLimit)
+	notifications, err := s.repo.ListNotifications(ctx, userId, unreadOnly, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := s.repo.CountUnread(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	page := &model.NotificationPage{Notifications: notifications, Unread: unread}
+	if len(notifications) == limit {
+		page.NextBefore = notifications[len(notifications)-1].ID
+	}
+	return page, nil
+}
+
+// MarkRead marks one notification of the user as read. Marking it again is
+// a no-op.
+func (s *NotificationService) MarkRead(ctx context.Context, userId uuid.UUID, id int64) (*model.Notification, error) {
+	if _, err := s.repo.MarkRead(ctx, userId, id, true, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	notification, err := s.repo.GetNotification(ctx, userId, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotificationNotFound
+	}
+	return notification, err
+}
+
+// MarkAllRead marks the user's notifications up to and including upTo as
+// read, or all of them when upTo is zero, and returns how many changed.
+func (s *NotificationService) MarkAllRead(ctx context.Context, userId uuid.UUID, upTo int64) (int64, error) {
+	return s.repo.MarkRead(ctx, userId, upTo, false, time.Now().UTC())
+}
+
+// Follow delivers the user's notifications after the one with id after,
+// then every new one as it arrives, until deliver or heartbeat fails or ctx
+// is done. With after zero only new notifications are delivered. heartbeat
+// is called when nothing arrived for notifications.heartbeat.
+//
+// Ids are taken when a notification is inserted but become visible when it
+// commits, so a lower id can show up after a higher one. The replay
+// therefore also covers notifications created up to
+// notifications.replay-overlap before after, which the client may already
+// have, and the stream is not filtered by id but against what the replay
+// delivered. The stream position is taken before the database is replayed,
+// and a notification is stored before it is pushed, so nothing falls
+// between the replay and the stream.
+func (s *NotificationService) Follow(ctx context.Context, userId uuid.UUID, after int64, deliver func(*model.Notification) error, heartbeat func() error) error {
+	key := notificationStreamPrefix + userId.String()
+	cursor := "0-0"
+	latest, err := s.redis.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	if err != nil {
+		return fmt.Errorf("read stream position: %w", err)
+	}
+	if len(latest) > 0 {
+		cursor = latest[0].ID
+	}
+
+	replayed, err := s.replay(ctx, userId, after, deliver)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		streams, err := s.redis.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{key, cursor},
+			Count:   notificationReplayBatch,
+			Block:   s.heartbeat,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			if err := heartbeat(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", key, err)
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				cursor = message.ID
+				notification, err := decodeNotification(message.Values)
+				if err != nil {
+					s.log.Errorf("skip malformed entry %s of %s: %v", message.ID, key, err)
+					continue
+				}
+				// every notification is in the stream once, so a replayed
+				// one is only needed until it comes by
+				if _, ok := replayed[notification.ID]; ok {
+					delete(replayed, notification.ID)
+					continue
+				}
+				if err := deliver(notification); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// replay delivers the notifications the client missed before it
+// reconnected after the one with id after, and returns the ids it delivered.
+func (s *NotificationService) replay(ctx context.Context, userId uuid.UUID, after int64, deliver func(*model.Notification) error) (map[int64]struct{}, error) {
+	replayed := make(map[int64]struct{})
+	if after <= 0 {
+		return replayed, nil
+	}
+	since := time.Now().UTC()
+	last, err := s.repo.GetNotification(ctx, userId, after)
+	if err == nil {
+		since = last.CreatedAt.Add(-s.overlap)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var cursor int64
+	for {
+		notifications, err := s.repo.NotificationsSince(ctx, userId, after, since, cursor, notificationReplayBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, notification := range notifications {
+			if err := deliver(notification); err != nil {
+				return nil, err
+			}
+			replayed[notification.ID] = struct{}{}
+			cursor = notification.ID
+		}
+		if len(notifications) < notificationReplayBatch {
+			return replayed, nil
+		}
+	}
+}
+
+func decodeNotification(values map[string]interface{}) (*model.Notification, error) {
+	raw, ok := values["data"].(string)
+	if !ok {
+		return nil, errors.New("entry has no data")
+	}
+	var notification model.Notification
+	if err := json.Unmarshal([]byte(raw), &notification); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
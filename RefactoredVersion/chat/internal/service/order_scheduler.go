@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"gorm.io/gorm"
+	"time"
+)
+
+const schedulerLeaderKey = "order_scheduler:leader"
+
+// renewLeaderScript extends the leader lock only while this node holds it.
+var renewLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaderScript drops the leader lock only while this node holds it.
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// OrderScheduler watches order deadlines in the background. On every tick it
+// reminds sellers of orders that are due soon, flags orders whose due date
+// has passed as late, and completes delivered orders the buyer has not
+// answered within the auto-complete window. It also retries payments that
+// could not be settled when their order was completed or cancelled. Every
+// replica runs the loop, but only the one holding the order_scheduler:leader
+// lock in Redis does the work.
+type OrderScheduler struct {
+	log               *logrus.Logger
+	redis             *redis.Client
+	repo              *repository.OrderRepo
+	payments          *PaymentService
+	notifications     *NotificationService
+	nodeID            string
+	enabled           bool
+	interval          time.Duration
+	reminderBefore    time.Duration
+	autoCompleteAfter time.Duration
+	batchSize         int
+	leader            bool
+	stop              chan struct{}
+	done              chan struct{}
+}
+
+func NewOrderScheduler(log *logrus.Logger, v *viper.Viper, rdb *redis.Client, repo *repository.OrderRepo, payments *PaymentService, notifications *NotificationService) *OrderScheduler {
+	v.SetDefault("scheduler.enabled", true)
+	v.SetDefault("scheduler.interval", time.Minute)
+	v.SetDefault("scheduler.reminder-before", 24*time.Hour)
+	v.SetDefault("scheduler.auto-complete-after", 72*time.Hour)
+	v.SetDefault("scheduler.batch-size", 100)
+	return &OrderScheduler{
+		log:               log,
+		redis:             rdb,
+		repo:              repo,
+		payments:          payments,
+		notifications:     notifications,
+		nodeID:            uuid.NewString(),
+		enabled:           v.GetBool("scheduler.enabled"),
+		interval:          v.GetDuration("scheduler.interval"),
+		reminderBefore:    v.GetDuration("scheduler.reminder-before"),
+		autoCompleteAfter: v.GetDuration("scheduler.auto-complete-after"),
+		batchSize:         v.GetInt("scheduler.batch-size"),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+}
+
+func RegisterSchedulerLifeCycle(lc fx.Lifecycle, s *OrderScheduler) {
+	if !s.enabled {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go s.run()
+			s.log.Infof("order scheduler %s started", s.nodeID)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(s.stop)
+			<-s.done
+			if s.leader {
+				if err := releaseLeaderScript.Run(ctx, s.redis, []string{schedulerLeaderKey}, s.nodeID).Err(); err != nil {
+					s.log.Errorf("release order scheduler lock: %v", err)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+func (s *OrderScheduler) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.tick()
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *OrderScheduler) tick() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+	if !s.lead(ctx) {
+		return
+	}
+	now := time.Now().UTC()
+	s.remind(ctx, now)
+	s.markLate(ctx, now)
+	s.autoComplete(ctx, now)
+	s.payments.SettlePending(ctx, s.batchSize)
+}
+
+// lead takes or keeps the leader lock. The lock outlives a few ticks, so a
+// replica that dies hands over to another one shortly after.
+func (s *OrderScheduler) lead(ctx context.Context) bool {
+	ttl := 3 * s.interval
+	if s.leader {
+		renewed, err := renewLeaderScript.Run(ctx, s.redis, []string{schedulerLeaderKey}, s.nodeID, ttl.Milliseconds()).Int()
+		if err != nil {
+			s.log.Errorf("renew order scheduler lock: %v", err)
+			return false
+		}
+		if renewed == 1 {
+			return true
+		}
+		s.leader = false
+		s.log.Warnf("order scheduler %s lost leadership", s.nodeID)
+	}
+	acquired, err := s.redis.SetNX(ctx, schedulerLeaderKey, s.nodeID, ttl).Result()
+	if err != nil {
+		s.log.Errorf("acquire order scheduler lock: %v", err)
+		return false
+	}
+	if acquired {
+		s.leader = true
+		s.log.Infof("order scheduler %s is the leader", s.nodeID)
+	}
+	return acquired
+}
+
+func (s *OrderScheduler) remind(ctx context.Context, now time.Time) {
+	orders, err := s.repo.FindOrdersDueBefore(ctx, now, now.Add(s.reminderBefore), s.batchSize)
+	if err != nil {
+		return
+	}
+	for _, order := range orders {
+		order, changed, err := s.repo.MarkReminded(ctx, order.ID, now)
+		if err != nil || !changed {
+			continue
+		}
+		s.notifications.NotifyOrder(ctx, order.SellerID, model.NotificationOrderDueSoon, order)
+	}
+}
+
+func (s *OrderScheduler) markLate(ctx context.Context, now time.Time) {
+	orders, err := s.repo.FindOverdueOrders(ctx, now, s.batchSize)
+	if err != nil {
+		return
+	}
+	for _, order := range orders {
+		order, changed, err := s.repo.MarkLate(ctx, order.ID, now)
+		if err != nil || !changed {
+			continue
+		}
+		s.notifications.NotifyOrder(ctx, order.SellerID, model.NotificationOrderLate, order)
+	}
+}
+
+// autoComplete accepts the pending delivery of orders the buyer left
+// unanswered on their behalf. An answer of the buyer that races it wins, and
+// the order is skipped.
+func (s *OrderScheduler) autoComplete(ctx context.Context, now time.Time) {
+	orders, err := s.repo.FindDeliveredBefore(ctx, now.Add(-s.autoCompleteAfter), s.batchSize)
+	if err != nil {
+		return
+	}
+	note := fmt.Sprintf("completed automatically after %s without a response from the buyer", s.autoCompleteAfter)
+	columns := map[string]interface{}{"completed_at": now, "auto_completed": true}
+	for _, order := range orders {
+		delivery, err := s.repo.GetPendingDelivery(ctx, order.ID)
+		switch {
+		case err == nil:
+			order, _, err = s.repo.RespondToDelivery(ctx, order.ID, delivery.ID, uuid.Nil, model.OrderDelivered, model.OrderCompleted, model.DeliveryAccepted, now, columns, note)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// delivered before deliveries were recorded
+			order, err = s.repo.TransitionOrder(ctx, order.ID, model.OrderDelivered, model.OrderCompleted, uuid.Nil, now, columns, note)
+		}
+		if err != nil {
+			continue
+		}
+		_ = settleOrder(ctx, s.log, s.payments, s.repo, order)
+		s.notifications.NotifyParties(ctx, uuid.Nil, model.NotificationOrderAutoCompleted, order)
+	}
+}
@@ -1,44 +1,243 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"go.uber.org/fx"
+	"gorm.io/gorm"
+	"time"
 )
 
 var ServiceModule = fx.Module("service", fx.Provide(
 	NewOrderService,
 	NewChatService,
-))
+	NewAttachmentService,
+	NewPresenceService,
+	NewUnreadService,
+	NewMessageService,
+	NewTranscriptService,
+	NewModerationService,
+	NewDeliveryService,
+	NewPaymentService,
+	NewDisputeService,
+	NewReviewService,
+	NewNotificationService,
+	NewOrderScheduler,
+), fx.Invoke(RegisterSchedulerLifeCycle))
+
+var (
+	ErrOrderNotFound         = errors.New("order not found")
+	ErrNotOrderParty         = errors.New("user is neither buyer nor seller of the order")
+	ErrInvalidTransition     = errors.New("order can not move to this status")
+	ErrTransitionNotAllowed  = errors.New("user may not move the order to this status")
+	ErrPackageRequired       = errors.New("packageId is required")
+	ErrPackageNotFound       = errors.New("gig package not found")
+	ErrPackageMismatch       = errors.New("gig package is not offered by this seller and service")
+	ErrPackageUnavailable    = errors.New("gig package is no longer offered")
+	ErrInvalidIdempotencyKey = errors.New("Idempotency-Key must be at most 255 characters")
+	ErrIdempotencyKeyReused  = errors.New("Idempotency-Key was already used for a different order")
+	ErrIdempotencyKeyInUse   = errors.New("a request with this Idempotency-Key is still being processed")
+	ErrUseDeliveryWorkflow   = errors.New("use the delivery endpoints for this step")
+	ErrUseDisputeWorkflow    = errors.New("use the dispute endpoints for this step")
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	idempotencyClaimPrefix  = "order_idempotency:"
+)
+
+// releaseClaimScript drops an idempotency claim only while this request
+// holds it.
+var releaseClaimScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
 
 type OrderService struct {
-	log  *logrus.Logger
-	v    *viper.Viper
-	repo *repository.OrderRepo
+	log            *logrus.Logger
+	v              *viper.Viper
+	redis          *redis.Client
+	repo           *repository.OrderRepo
+	payments       *PaymentService
+	notifications  *NotificationService
+	idempotencyTTL time.Duration
+	claimTTL       time.Duration
 }
 
 func NewOrderService(
 	log *logrus.Logger,
 	v *viper.Viper,
+	rdb *redis.Client,
 	repo *repository.OrderRepo,
+	payments *PaymentService,
+	notifications *NotificationService,
 ) *OrderService {
+	v.SetDefault("orders.idempotency-ttl", 24*time.Hour)
+	v.SetDefault("orders.idempotency-claim-ttl", time.Minute)
 	return &OrderService{
-		log:  log,
-		v:    v,
-		repo: repo,
+		log:            log,
+		v:              v,
+		redis:          rdb,
+		repo:           repo,
+		payments:       payments,
+		notifications:  notifications,
+		idempotencyTTL: v.GetDuration("orders.idempotency-ttl"),
+		claimTTL:       v.GetDuration("orders.idempotency-claim-ttl"),
+	}
+}
+
+// PlaceOrder places an order of the selected package and opens its chat
+// room. The package, as it is now, is copied into the order and sets its
+// price and due date. The price is charged from the buyer and held in escrow
+// until the order is completed or cancelled; a free package is ordered
+// without a charge. The seller is notified of the new order.
+//
+// With an idempotency key, a repeated request of the same buyer and key is
+// answered with the order of the first one for orders.idempotency-ttl, and
+// nothing new is created. Reusing the key for a different request fails with
+// ErrIdempotencyKeyReused. The key is claimed before the buyer is charged, so
+// a concurrent request with the same key fails with ErrIdempotencyKeyInUse
+// instead of charging a second time.
+func (os *OrderService) PlaceOrder(ctx context.Context, req *model.OrderPlaceRequest, idempotencyKey string) (*model.PlacedOrder, error) {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, ErrInvalidIdempotencyKey
+	}
+	requestHash := placeRequestHash(req)
+	if idempotencyKey != "" {
+		release, err := os.claim(ctx, req.BuyerId, idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		placed, err := os.replay(ctx, req.BuyerId, idempotencyKey, requestHash)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return placed, err
+		}
+	}
+
+	if req.PackageId == uuid.Nil {
+		return nil, ErrPackageRequired
+	}
+	pkg, err := os.repo.GetGigPackage(ctx, req.PackageId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPackageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Gig.SellerID != req.SellerId || (req.ServiceId != uuid.Nil && pkg.GigID != req.ServiceId) {
+		return nil, ErrPackageMismatch
+	}
+	if !pkg.IsActive || !pkg.Gig.IsActive {
+		return nil, ErrPackageUnavailable
+	}
+
+	now := time.Now().UTC()
+	dueDate := now.AddDate(0, 0, pkg.DeliveryTime)
+	order := &model.Order{
+		ID:              uuid.New(),
+		OrderNumber:     os.repo.GenerateOrderNumber(),
+		BuyerID:         req.BuyerId,
+		SellerID:        req.SellerId,
+		PackageID:       pkg.ID,
+		Price:           pkg.Price,
+		PaymentMethod:   "NOT_SET",
+		Status:          model.OrderPending,
+		DueDate:         &dueDate,
+		PackageSnapshot: model.NewPackageSnapshot(pkg),
+	}
+	room := &model.ChatRoom{
+		ParticipantOne: req.BuyerId,
+		ParticipantTwo: req.SellerId,
+		ServiceId:      pkg.GigID,
+		MasterKey:      os.MasterKey(req.SellerId, req.BuyerId, pkg.GigID, order.OrderNumber),
+	}
+	var key *model.OrderIdempotencyKey
+	if idempotencyKey != "" {
+		key = &model.OrderIdempotencyKey{
+			BuyerID:     req.BuyerId,
+			Key:         idempotencyKey,
+			RequestHash: requestHash,
+			ExpiresAt:   now.Add(os.idempotencyTTL),
+		}
+	}
+
+	payment, err := os.payments.Charge(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	err = os.repo.PlaceOrder(ctx, order, room, key, payment)
+	if err != nil {
+		os.payments.Void(ctx, payment)
+	}
+	if errors.Is(err, repository.ErrDuplicateIdempotencyKey) {
+		// the claim expired and a later request with the same key won
+		return os.replay(ctx, req.BuyerId, idempotencyKey, requestHash)
 	}
+	if err != nil {
+		return nil, err
+	}
+	os.notifications.NotifyOrder(ctx, order.SellerID, model.NotificationOrderPlaced, order)
+	return &model.PlacedOrder{ChatRoomID: room.ChatRoomID, Order: order}, nil
 }
 
-func (os *OrderService) FindOrCreate(req *model.OrderPlaceRequest) (*model.Order, error) {
-	orderInDB, err := os.repo.FindOrCreate(req)
+// claim holds the buyer's idempotency key in Redis for the time it takes to
+// place the order. The returned func releases it again.
+func (os *OrderService) claim(ctx context.Context, buyerId uuid.UUID, idempotencyKey string) (func(), error) {
+	key := idempotencyClaimPrefix + buyerId.String() + ":" + idempotencyKey
+	token := uuid.NewString()
+	acquired, err := os.redis.SetNX(ctx, key, token, os.claimTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key: %w", err)
+	}
+	if !acquired {
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return func() {
+		if err := releaseClaimScript.Run(context.Background(), os.redis, []string{key}, token).Err(); err != nil {
+			os.log.Errorf("release idempotency key: %v", err)
+		}
+	}, nil
+}
+
+// replay answers a request from the live entry of its idempotency key. It
+// returns gorm.ErrRecordNotFound when there is none.
+func (os *OrderService) replay(ctx context.Context, buyerId uuid.UUID, idempotencyKey, requestHash string) (*model.PlacedOrder, error) {
+	entry, err := os.repo.GetIdempotencyKey(ctx, buyerId, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	order, err := os.repo.GetOrderById(entry.OrderID)
 	if err != nil {
-		os.log.Error(err)
 		return nil, err
 	}
-	return orderInDB, nil
+	return &model.PlacedOrder{ChatRoomID: entry.ChatRoomID, Order: order, Replayed: true}, nil
+}
+
+func (os *OrderService) MasterKey(seller, buyer, serviceId uuid.UUID, orderId string) string {
+	hash := sha256.New()
+	hash.Write([]byte(seller.String() + buyer.String() + serviceId.String() + orderId))
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+func placeRequestHash(req *model.OrderPlaceRequest) string {
+	hash := sha256.Sum256([]byte(req.BuyerId.String() + req.SellerId.String() + req.ServiceId.String() + req.PackageId.String()))
+	return hex.EncodeToString(hash[:])
 }
 
 func (os *OrderService) GetAllOrderByUserId(userId uuid.UUID) ([]*model.Order, error) {
@@ -49,3 +248,144 @@ func (os *OrderService) GetAllOrderByUserId(userId uuid.UUID) ([]*model.Order, e
 	}
 	return ordersRelatedToUser, nil
 }
+
+// Transition moves an order to a new status on behalf of its buyer or seller,
+// or of a platform moderator. The order has to be in a status that leads to
+// the new one, and the actor's role has to be allowed to take that step. The
+// note is kept with the step in the order's timeline.
+func (os *OrderService) Transition(ctx context.Context, actorId, orderId uuid.UUID, to model.OrderStatus, platformModerator bool, note string) (*model.Order, error) {
+	order, role, err := os.orderFor(actorId, orderId, platformModerator)
+	if err != nil {
+		return nil, err
+	}
+	if err := transitionStep(order.Status, to, role); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	columns := map[string]interface{}{}
+	switch to {
+	case model.OrderAccepted:
+		columns["accepted_at"] = now
+	case model.OrderCompleted:
+		columns["completed_at"] = now
+	case model.OrderCancelled:
+		columns["cancelled_at"] = now
+	}
+
+	updated, err := os.repo.TransitionOrder(ctx, order.ID, order.Status, to, actorId, now, columns, note)
+	if err != nil {
+		return nil, stepError(err)
+	}
+	os.log.Infof("order %s moved from %s to %s by %s (%s)", order.OrderNumber, order.Status, to, actorId, role)
+	_ = os.settle(ctx, updated)
+	os.changed(ctx, actorId, updated)
+	return updated, nil
+}
+
+// transitionStep validates a step taken through Transition. Steps that
+// belong to the delivery and dispute workflows are sent there.
+func transitionStep(from, to model.OrderStatus, role model.RoomRole) error {
+	if err := checkStep(from, to, role); err != nil {
+		return err
+	}
+	// deliveries and the buyer's answer to them carry more than a status
+	if to == model.OrderDelivered || to == model.OrderRevisionRequested || from == model.OrderDelivered && to == model.OrderCompleted {
+		return ErrUseDeliveryWorkflow
+	}
+	// so do disputes and their rulings
+	if to == model.OrderDisputed || from == model.OrderDisputed {
+		return ErrUseDisputeWorkflow
+	}
+	return nil
+}
+
+// checkStep validates a step of any workflow against the order state
+// machine.
+func checkStep(from, to model.OrderStatus, role model.RoomRole) error {
+	if !from.CanTransition(to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	if !from.AllowedBy(to, role) {
+		return fmt.Errorf("%w: %s to %s as %s", ErrTransitionNotAllowed, from, to, role)
+	}
+	return nil
+}
+
+// stepError turns the errors of a step that lost a race against another
+// change of the order into the service errors.
+func stepError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrStaleOrderStatus):
+		return fmt.Errorf("%w: %s", ErrInvalidTransition, err)
+	case errors.Is(err, repository.ErrStaleDelivery):
+		return ErrDeliveryNotPending
+	}
+	return err
+}
+
+// changed tells the buyer and seller, other than the actor, that their order
+// moved to a new status.
+func (os *OrderService) changed(ctx context.Context, actorId uuid.UUID, order *model.Order) {
+	os.notifications.NotifyParties(ctx, actorId, model.NotificationOrderUpdated, order)
+}
+
+// settle moves the escrow of an order that was just completed or cancelled.
+// The order is already stored, so a failure does not undo it: it is recorded
+// in the order's timeline and returned, and the scheduler retries it.
+func (os *OrderService) settle(ctx context.Context, order *model.Order) error {
+	return settleOrder(ctx, os.log, os.payments, os.repo, order)
+}
+
+func settleOrder(ctx context.Context, log *logrus.Logger, payments *PaymentService, repo *repository.OrderRepo, order *model.Order) error {
+	_, err := payments.Settle(ctx, order)
+	if err == nil {
+		return nil
+	}
+	log.Errorf("failed to settle payment of order %s: %v", order.OrderNumber, err)
+	_ = repo.AddOrderEvent(ctx, &model.OrderEvent{
+		OrderID:    order.ID,
+		Type:       model.OrderEventSettlementDeferred,
+		FromStatus: order.Status,
+		ToStatus:   order.Status,
+		Note:       "settlement failed and is retried: " + err.Error(),
+	})
+	return err
+}
+
+// Payment returns the payment of an order with its ledger entries.
+func (os *OrderService) Payment(ctx context.Context, userId, orderId uuid.UUID, platformModerator bool) (*model.Payment, error) {
+	if _, _, err := os.orderFor(userId, orderId, platformModerator); err != nil {
+		return nil, err
+	}
+	return os.payments.ForOrder(ctx, orderId)
+}
+
+// Timeline returns every recorded change of an order, oldest first.
+func (os *OrderService) Timeline(ctx context.Context, userId, orderId uuid.UUID, platformModerator bool) ([]*model.OrderEvent, error) {
+	if _, _, err := os.orderFor(userId, orderId, platformModerator); err != nil {
+		return nil, err
+	}
+	return os.repo.GetOrderEvents(ctx, orderId)
+}
+
+// orderFor loads an order together with the role the user has on it.
+func (os *OrderService) orderFor(userId, orderId uuid.UUID, platformModerator bool) (*model.Order, model.RoomRole, error) {
+	order, err := os.repo.GetOrderById(orderId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case userId == order.BuyerID:
+		return order, model.RoleBuyer, nil
+	case userId == order.SellerID:
+		return order, model.RoleSeller, nil
+	case platformModerator:
+		return order, model.RoleModerator, nil
+	}
+	return nil, "", ErrNotOrderParty
+}
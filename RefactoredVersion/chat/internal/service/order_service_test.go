@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"testing"
+)
+
+func TestCheckStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to model.OrderStatus
+		role     model.RoomRole
+		want     error
+	}{
+		{"seller accepts", model.OrderPending, model.OrderAccepted, model.RoleSeller, nil},
+		{"buyer can not accept", model.OrderPending, model.OrderAccepted, model.RoleBuyer, ErrTransitionNotAllowed},
+		{"observer can not cancel", model.OrderPending, model.OrderCancelled, model.RoleObserver, ErrTransitionNotAllowed},
+		{"no skipping to delivered", model.OrderPending, model.OrderDelivered, model.RoleSeller, ErrInvalidTransition},
+		{"buyer accepts delivery", model.OrderDelivered, model.OrderCompleted, model.RoleBuyer, nil},
+		{"seller can not complete", model.OrderDelivered, model.OrderCompleted, model.RoleSeller, ErrTransitionNotAllowed},
+		{"moderator rules", model.OrderDisputed, model.OrderCancelled, model.RoleModerator, nil},
+		{"parties can not rule", model.OrderDisputed, model.OrderCompleted, model.RoleBuyer, ErrTransitionNotAllowed},
+		{"completed is final", model.OrderCompleted, model.OrderCancelled, model.RoleModerator, ErrInvalidTransition},
+		{"cancelled is final", model.OrderCancelled, model.OrderPending, model.RoleBuyer, ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStep(tt.from, tt.to, tt.role)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("checkStep(%s, %s, %s) = %v, want %v", tt.from, tt.to, tt.role, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitionStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to model.OrderStatus
+		role     model.RoomRole
+		want     error
+	}{
+		{"accept", model.OrderPending, model.OrderAccepted, model.RoleSeller, nil},
+		{"start", model.OrderAccepted, model.OrderInProgress, model.RoleSeller, nil},
+		{"cancel", model.OrderAccepted, model.OrderCancelled, model.RoleBuyer, nil},
+		{"resume after revision", model.OrderRevisionRequested, model.OrderInProgress, model.RoleSeller, nil},
+		{"deliver", model.OrderInProgress, model.OrderDelivered, model.RoleSeller, ErrUseDeliveryWorkflow},
+		{"redeliver", model.OrderRevisionRequested, model.OrderDelivered, model.RoleSeller, ErrUseDeliveryWorkflow},
+		{"request revision", model.OrderDelivered, model.OrderRevisionRequested, model.RoleBuyer, ErrUseDeliveryWorkflow},
+		{"accept delivery", model.OrderDelivered, model.OrderCompleted, model.RoleBuyer, ErrUseDeliveryWorkflow},
+		{"open dispute", model.OrderInProgress, model.OrderDisputed, model.RoleBuyer, ErrUseDisputeWorkflow},
+		{"rule", model.OrderDisputed, model.OrderCompleted, model.RoleModerator, ErrUseDisputeWorkflow},
+		// the state machine is checked before the redirect
+		{"deliver as buyer", model.OrderInProgress, model.OrderDelivered, model.RoleBuyer, ErrTransitionNotAllowed},
+		{"dispute a pending order", model.OrderPending, model.OrderDisputed, model.RoleBuyer, ErrInvalidTransition},
+		{"reopen", model.OrderCompleted, model.OrderInProgress, model.RoleSeller, ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := transitionStep(tt.from, tt.to, tt.role)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("transitionStep(%s, %s, %s) = %v, want %v", tt.from, tt.to, tt.role, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+var (
+	ErrPaymentDeclined = errors.New("payment was declined")
+	ErrPaymentNotFound = errors.New("order has no payment")
+)
+
+// freePaymentMethod is the payment method of orders placed for free.
+const freePaymentMethod = "FREE"
+
+// PaymentService keeps the escrow of orders. The buyer pays when the order
+// is placed and the money is held in escrow; completing the order releases
+// it to the seller minus the platform fee, cancelling it refunds the buyer.
+// A dispute ruled a partial refund splits it between the two.
+// Every movement goes through the PaymentProvider first and is then booked
+// as a balanced posting in the ledger.
+type PaymentService struct {
+	log        *logrus.Logger
+	repo       *repository.PaymentRepo
+	provider   repository.PaymentProvider
+	currency   string
+	feePercent float64
+}
+
+func NewPaymentService(log *logrus.Logger, v *viper.Viper, repo *repository.PaymentRepo, provider repository.PaymentProvider) *PaymentService {
+	v.SetDefault("payments.currency", "USD")
+	v.SetDefault("payments.fee-percent", 10)
+	return &PaymentService{
+		log:        log,
+		repo:       repo,
+		provider:   provider,
+		currency:   v.GetString("payments.currency"),
+		feePercent: v.GetFloat64("payments.fee-percent"),
+	}
+}
+
+// Charge takes the price of a new order from the buyer into escrow and sets
+// the order's payment method and transaction id. The payment it returns,
+// with its hold posting, is stored together with the order; if that fails
+// the charge has to be given back with Void. A free order has nothing to
+// hold: it is not charged and gets no payment.
+func (s *PaymentService) Charge(ctx context.Context, order *model.Order) (*model.Payment, error) {
+	amount := model.MinorUnits(order.Price)
+	if amount == 0 {
+		order.PaymentMethod = freePaymentMethod
+		return nil, nil
+	}
+	reference, err := s.provider.Charge(ctx, &model.PaymentCharge{
+		OrderID:  order.ID,
+		BuyerID:  order.BuyerID,
+		Amount:   amount,
+		Currency: s.currency,
+	})
+	if errors.Is(err, repository.ErrPaymentDeclined) {
+		return nil, ErrPaymentDeclined
+	}
+	if err != nil {
+		return nil, err
+	}
+	order.PaymentMethod = s.provider.Name()
+	order.TransactionID = &reference
+
+	payment := &model.Payment{
+		ID:        uuid.New(),
+		OrderID:   order.ID,
+		BuyerID:   order.BuyerID,
+		SellerID:  order.SellerID,
+		Provider:  s.provider.Name(),
+		Reference: reference,
+		Currency:  s.currency,
+		Amount:    amount,
+		Fee:       int64(math.Round(float64(amount) * s.feePercent / 100)),
+		Status:    model.PaymentHeld,
+	}
+	payment.Entries = s.posting(payment, model.PostingHold,
+		ledgerLine(model.AccountBuyer, &order.BuyerID, -amount),
+		ledgerLine(model.AccountEscrow, nil, amount),
+	)
+	return payment, nil
+}
+
+// Void gives a charge back to the buyer when its order was never stored.
+// Nothing was booked, so only the provider is involved.
+func (s *PaymentService) Void(ctx context.Context, payment *model.Payment) {
+	if payment == nil {
+		return
+	}
+	err := s.provider.Refund(ctx, &model.PaymentTransfer{
+		Key:       payment.ID.String() + ":void",
+		Reference: payment.Reference,
+		UserID:    payment.BuyerID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+	})
+	if err != nil {
+		s.log.Errorf("failed to void charge %s of order %s: %v", payment.Reference, payment.OrderID, err)
+	}
+}
+
+// Settle releases the payment of a completed order, less any refund a
+// dispute ruling set on it, and refunds the payment of a cancelled one.
+// Payments of orders in any other status, payments that left escrow already
+// and orders without a payment are left alone.
+func (s *PaymentService) Settle(ctx context.Context, order *model.Order) (*model.Payment, error) {
+	if order.Status != model.OrderCompleted && order.Status != model.OrderCancelled {
+		return nil, nil
+	}
+	payment, err := s.repo.GetPaymentByOrder(ctx, order.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != model.PaymentHeld {
+		return payment, nil
+	}
+	return payment, s.settle(ctx, payment, order.Status)
+}
+
+// SettlePending retries the settlements that failed when their order was
+// completed or cancelled.
+func (s *PaymentService) SettlePending(ctx context.Context, limit int) {
+	for _, status := range []model.OrderStatus{model.OrderCompleted, model.OrderCancelled} {
+		payments, err := s.repo.FindUnsettledPayments(ctx, status, limit)
+		if err != nil {
+			return
+		}
+		for _, payment := range payments {
+			if err := s.settle(ctx, payment, status); err != nil {
+				s.log.Errorf("failed to settle payment of order %s: %v", payment.OrderID, err)
+			}
+		}
+	}
+}
+
+// ForOrder returns the payment of an order with its ledger entries.
+func (s *PaymentService) ForOrder(ctx context.Context, orderId uuid.UUID) (*model.Payment, error) {
+	payment, err := s.repo.GetPaymentByOrder(ctx, orderId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPaymentNotFound
+	}
+	return payment, err
+}
+
+func (s *PaymentService) settle(ctx context.Context, payment *model.Payment, orderStatus model.OrderStatus) error {
+	var (
+		status  model.PaymentStatus
+		entries []*model.LedgerEntry
+	)
+	switch {
+	case orderStatus == model.OrderCancelled:
+		status = model.PaymentRefunded
+		if err := s.provider.Refund(ctx, s.transfer(payment, model.PostingRefund, payment.BuyerID, payment.Amount)); err != nil {
+			return err
+		}
+		entries = s.posting(payment, model.PostingRefund,
+			ledgerLine(model.AccountEscrow, nil, -payment.Amount),
+			ledgerLine(model.AccountBuyer, &payment.BuyerID, payment.Amount),
+		)
+	case orderStatus == model.OrderCompleted && payment.RefundAmount > 0:
+		status = model.PaymentSplit
+		refund := payment.RefundAmount
+		if err := s.provider.Refund(ctx, s.transfer(payment, model.PostingRefund, payment.BuyerID, refund)); err != nil {
+			return err
+		}
+		released := payment.Amount - refund
+		fee := int64(math.Round(float64(payment.Fee) * float64(released) / float64(payment.Amount)))
+		if err := s.provider.Payout(ctx, s.transfer(payment, model.PostingRelease, payment.SellerID, released-fee)); err != nil {
+			return err
+		}
+		entries = append(
+			s.posting(payment, model.PostingRefund,
+				ledgerLine(model.AccountEscrow, nil, -refund),
+				ledgerLine(model.AccountBuyer, &payment.BuyerID, refund),
+			),
+			s.posting(payment, model.PostingRelease,
+				ledgerLine(model.AccountEscrow, nil, -released),
+				ledgerLine(model.AccountSeller, &payment.SellerID, released-fee),
+				ledgerLine(model.AccountPlatformFee, nil, fee),
+			)...,
+		)
+	case orderStatus == model.OrderCompleted:
+		status = model.PaymentReleased
+		payout := payment.Amount - payment.Fee
+		if err := s.provider.Payout(ctx, s.transfer(payment, model.PostingRelease, payment.SellerID, payout)); err != nil {
+			return err
+		}
+		entries = s.posting(payment, model.PostingRelease,
+			ledgerLine(model.AccountEscrow, nil, -payment.Amount),
+			ledgerLine(model.AccountSeller, &payment.SellerID, payout),
+			ledgerLine(model.AccountPlatformFee, nil, payment.Fee),
+		)
+	default:
+		return fmt.Errorf("payment of an order in status %s can not be settled", orderStatus)
+	}
+
+	err := s.repo.SettlePayment(ctx, payment, status, time.Now().UTC(), entries)
+	if errors.Is(err, repository.ErrPaymentSettled) {
+		// a concurrent settlement of the same order booked it; the provider
+		// saw the same transfer keys from both
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Infof("payment of order %s %s", payment.OrderID, status)
+	return nil
+}
+
+func (s *PaymentService) transfer(payment *model.Payment, posting model.LedgerPosting, userId uuid.UUID, amount int64) *model.PaymentTransfer {
+	return &model.PaymentTransfer{
+		Key:       payment.ID.String() + ":" + string(posting),
+		Reference: payment.Reference,
+		UserID:    userId,
+		Amount:    amount,
+		Currency:  payment.Currency,
+	}
+}
+
+// posting stamps the lines of one posting with the payment, a shared posting
+// id and the currency.
+func (s *PaymentService) posting(payment *model.Payment, posting model.LedgerPosting, lines ...*model.LedgerEntry) []*model.LedgerEntry {
+	id := uuid.New()
+	for _, line := range lines {
+		line.PaymentID = payment.ID
+		line.PostingID = id
+		line.Posting = posting
+		line.Currency = payment.Currency
+	}
+	return lines
+}
+
+func ledgerLine(account model.LedgerAccount, ownerId *uuid.UUID, amount int64) *model.LedgerEntry {
+	return &model.LedgerEntry{Account: account, OwnerID: ownerId, Amount: amount}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"time"
+)
+
+const (
+	presenceKeyPrefix = "presence:"
+	lastSeenKeyPrefix = "last_seen:"
+	presenceUsersKey  = "presence_users"
+)
+
+// PresenceService tracks which users have a chat socket open. Every socket is
+// a member of the sorted set presence:{userId} scored with the time its
+// heartbeat expires; a user is online while any member is unexpired.
+type PresenceService struct {
+	log   *logrus.Logger
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+func NewPresenceService(log *logrus.Logger, v *viper.Viper, rdb *redis.Client) *PresenceService {
+	v.SetDefault("presence.ttl", time.Minute)
+	return &PresenceService{
+		log:   log,
+		redis: rdb,
+		ttl:   v.GetDuration("presence.ttl"),
+	}
+}
+
+// Connect registers a socket and reports whether the user just came online.
+func (s *PresenceService) Connect(ctx context.Context, userId uuid.UUID, connId string) (bool, error) {
+	now := time.Now()
+	key := s.key(userId)
+
+	pipe := s.redis.TxPipeline()
+	before := pipe.ZCount(ctx, key, s.score(now), "+inf")
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(s.ttl).Unix()), Member: connId})
+	pipe.Expire(ctx, key, s.keyTTL())
+	pipe.SAdd(ctx, presenceUsersKey, userId.String())
+	pipe.Set(ctx, s.lastSeenKey(userId), now.Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("presence connect: %w", err)
+	}
+	return before.Val() == 0, nil
+}
+
+// Heartbeat keeps a socket alive for another ttl.
+func (s *PresenceService) Heartbeat(ctx context.Context, userId uuid.UUID, connId string) error {
+	now := time.Now()
+	key := s.key(userId)
+
+	pipe := s.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(s.ttl).Unix()), Member: connId})
+	pipe.Expire(ctx, key, s.keyTTL())
+	pipe.Set(ctx, s.lastSeenKey(userId), now.Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("presence heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Disconnect removes a socket and reports whether it was the user's last one.
+func (s *PresenceService) Disconnect(ctx context.Context, userId uuid.UUID, connId string) (bool, error) {
+	now := time.Now()
+	key := s.key(userId)
+
+	pipe := s.redis.TxPipeline()
+	removed := pipe.ZRem(ctx, key, connId)
+	after := pipe.ZCount(ctx, key, s.score(now), "+inf")
+	pipe.Set(ctx, s.lastSeenKey(userId), now.Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("presence disconnect: %w", err)
+	}
+	return removed.Val() > 0 && after.Val() == 0, nil
+}
+
+func (s *PresenceService) Get(ctx context.Context, userId uuid.UUID) (*model.Presence, error) {
+	pipe := s.redis.Pipeline()
+	live := pipe.ZCount(ctx, s.key(userId), s.score(time.Now()), "+inf")
+	lastSeen := pipe.Get(ctx, s.lastSeenKey(userId))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("presence get: %w", err)
+	}
+
+	presence := &model.Presence{UserID: userId, Online: live.Val() > 0}
+	if seen, err := lastSeen.Int64(); err == nil {
+		t := time.Unix(seen, 0).UTC()
+		presence.LastSeen = &t
+	}
+	return presence, nil
+}
+
+// Sweep drops sockets whose heartbeat expired, for instance because their
+// node died, and returns the users that went offline as a result.
+func (s *PresenceService) Sweep(ctx context.Context) ([]uuid.UUID, error) {
+	users, err := s.redis.SMembers(ctx, presenceUsersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("presence sweep: %w", err)
+	}
+
+	var offline []uuid.UUID
+	now := s.score(time.Now())
+	for _, raw := range users {
+		userId, err := uuid.Parse(raw)
+		if err != nil {
+			s.redis.SRem(ctx, presenceUsersKey, raw)
+			continue
+		}
+		key := s.key(userId)
+		pipe := s.redis.TxPipeline()
+		removed := pipe.ZRemRangeByScore(ctx, key, "-inf", "("+now)
+		left := pipe.ZCard(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			s.log.Errorf("presence sweep of %s: %v", userId, err)
+			continue
+		}
+		if left.Val() == 0 {
+			s.redis.SRem(ctx, presenceUsersKey, raw)
+			// only the node that removed the last socket reports the change
+			if removed.Val() > 0 {
+				offline = append(offline, userId)
+			}
+		}
+	}
+	return offline, nil
+}
+
+// keyTTL outlives the heartbeat so Sweep still sees expired sockets and can
+// report the user offline before Redis drops the whole set.
+func (s *PresenceService) keyTTL() time.Duration {
+	return 3 * s.ttl
+}
+
+func (s *PresenceService) key(userId uuid.UUID) string {
+	return presenceKeyPrefix + userId.String()
+}
+
+func (s *PresenceService) lastSeenKey(userId uuid.UUID) string {
+	return lastSeenKeyPrefix + userId.String()
+}
+
+func (s *PresenceService) score(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
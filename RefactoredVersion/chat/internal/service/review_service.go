@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReviewLimit = 20
+	maxReviewLimit     = 100
+)
+
+var (
+	ErrReviewNotBuyer    = errors.New("only the buyer can review an order")
+	ErrOrderNotCompleted = errors.New("only completed orders can be reviewed")
+	ErrInvalidRating     = fmt.Errorf("rating must be between %d and %d", model.MinRating, model.MaxRating)
+	ErrReviewTitle       = errors.New("a review needs a title")
+	ErrOrderReviewed     = errors.New("order is already reviewed")
+	ErrReviewNotFound    = errors.New("review not found")
+	ErrReviewNotSeller   = errors.New("only the seller can reply to a review")
+	ErrEmptyReviewReply  = errors.New("reply is required")
+	ErrReviewReplyExists = errors.New("review already has a reply")
+	ErrReviewGigNotFound = errors.New("gig of the order not found")
+)
+
+// ReviewService takes the buyer's review of a completed order and the
+// seller's public reply to it. Storing a review recomputes the rating of the
+// gig the order was placed for.
+type ReviewService struct {
+	log    *logrus.Logger
+	repo   *repository.ReviewRepo
+	orders *OrderService
+}
+
+func NewReviewService(log *logrus.Logger, repo *repository.ReviewRepo, orders *OrderService) *ReviewService {
+	return &ReviewService{
+		log:    log,
+		repo:   repo,
+		orders: orders,
+	}
+}
+
+// Submit stores the buyer's review of a completed order. Every order can be
+// reviewed once.
+func (s *ReviewService) Submit(ctx context.Context, buyerId, orderId uuid.UUID, req *model.ReviewRequest) (*model.Review, error) {
+	order, role, err := s.orders.orderFor(buyerId, orderId, false)
+	if err != nil {
+		return nil, err
+	}
+	if role != model.RoleBuyer {
+		return nil, ErrReviewNotBuyer
+	}
+	if order.Status != model.OrderCompleted {
+		return nil, ErrOrderNotCompleted
+	}
+	if req.Rating < model.MinRating || req.Rating > model.MaxRating {
+		return nil, ErrInvalidRating
+	}
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		return nil, ErrReviewTitle
+	}
+	gigId, err := s.gigOf(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &model.Review{
+		ID:       uuid.New(),
+		Title:    title,
+		Rating:   req.Rating,
+		IsPublic: true,
+		AuthorID: buyerId,
+		OrderID:  orderId,
+		GigID:    gigId,
+		SellerID: order.SellerID,
+	}
+	if content := strings.TrimSpace(req.Content); content != "" {
+		review.Content = &content
+	}
+	err = s.repo.CreateReview(ctx, review)
+	if errors.Is(err, repository.ErrDuplicateReview) {
+		return nil, ErrOrderReviewed
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReviewGigNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.orders.notifications.NotifyReview(ctx, order.SellerID, model.NotificationReviewReceived, review)
+	return review, nil
+}
+
+// Reply stores the seller's public answer to a review of one of their
+// orders. A review has at most one reply.
+func (s *ReviewService) Reply(ctx context.Context, sellerId, reviewId uuid.UUID, req *model.ReviewReplyRequest) (*model.Review, error) {
+	review, err := s.repo.GetReview(ctx, reviewId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if review.SellerID != sellerId {
+		return nil, ErrReviewNotSeller
+	}
+	reply := strings.TrimSpace(req.Reply)
+	if reply == "" {
+		return nil, ErrEmptyReviewReply
+	}
+	err = s.repo.ReplyToReview(ctx, review, reply, time.Now().UTC())
+	if errors.Is(err, repository.ErrReviewReplied) {
+		return nil, ErrReviewReplyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.orders.notifications.NotifyReview(ctx, review.AuthorID, model.NotificationReviewReplied, review)
+	return review, nil
+}
+
+// GigReviews lists the public reviews of a gig, newest first.
+func (s *ReviewService) GigReviews(ctx context.Context, gigId uuid.UUID, limit, offset int) (*model.ReviewPage, error) {
+	return s.page(ctx, "gigId", gigId, limit, offset)
+}
+
+// SellerReviews lists the public reviews of all gigs of a seller, newest
+// first.
+func (s *ReviewService) SellerReviews(ctx context.Context, sellerId uuid.UUID, limit, offset int) (*model.ReviewPage, error) {
+	return s.page(ctx, "sellerId", sellerId, limit, offset)
+}
+
+func (s *ReviewService) page(ctx context.Context, column string, id uuid.UUID, limit, offset int) (*model.ReviewPage, error) {
+	if limit <= 0 {
+		limit = defaultReviewLimit
+	}
+	if limit > maxReviewLimit {
+		limit = maxReviewLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	reviews, total, err := s.repo.ListPublicReviews(ctx, column, id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ReviewPage{Reviews: reviews, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// gigOf reads the gig from the package snapshot, and from the package itself
+// for orders placed before snapshots existed.
+func (s *ReviewService) gigOf(ctx context.Context, order *model.Order) (uuid.UUID, error) {
+	if order.PackageSnapshot != nil {
+		return order.PackageSnapshot.GigID, nil
+	}
+	pkg, err := s.orders.repo.GetGigPackage(ctx, order.PackageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, ErrReviewGigNotFound
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return pkg.GigID, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/google/uuid"
+	"github.com/jung-kurt/gofpdf"
+	"time"
+)
+
+const transcriptTimeLayout = "2006-01-02 15:04:05 UTC"
+
+// RenderTranscriptPDF lays out a signed transcript as an A4 document. The
+// signature in the footer is the one of the JSON export of the same
+// transcript.
+func RenderTranscriptPDF(signed *model.SignedTranscript) ([]byte, error) {
+	t := signed.Transcript
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	// core fonts are cp1252; characters outside it, such as emoji, are dropped
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(fmt.Sprintf("Order %s conversation", t.Order.OrderNumber), true)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "I", 7)
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(0, 4, fmt.Sprintf("%s %s", signed.Algorithm, signed.Signature), "", 1, "L", false, 0, "")
+		pdf.CellFormat(0, 4, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 15)
+	pdf.CellFormat(0, 9, tr(fmt.Sprintf("Order %s conversation", t.Order.OrderNumber)), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.CellFormat(0, 5, fmt.Sprintf("Exported %s by %s", t.ExportedAt.Format(transcriptTimeLayout), t.ExportedBy), "", 1, "L", false, 0, "")
+	pdf.Ln(3)
+
+	section(pdf, "Order")
+	field(pdf, tr, "Order id", t.Order.ID.String())
+	field(pdf, tr, "Status", string(t.Order.Status))
+	field(pdf, tr, "Price", fmt.Sprintf("%.2f", t.Order.Price))
+	field(pdf, tr, "Payment method", t.Order.PaymentMethod)
+	field(pdf, tr, "Package", t.Order.PackageID.String())
+	field(pdf, tr, "Placed", t.Order.CreatedAt.Format(transcriptTimeLayout))
+	if t.Order.DueDate != nil {
+		field(pdf, tr, "Due", t.Order.DueDate.UTC().Format(transcriptTimeLayout))
+	}
+	if t.Order.CompletedAt != nil {
+		field(pdf, tr, "Completed", t.Order.CompletedAt.UTC().Format(transcriptTimeLayout))
+	}
+	if t.Order.Requirements != nil {
+		field(pdf, tr, "Requirements", *t.Order.Requirements)
+	}
+	pdf.Ln(3)
+
+	section(pdf, "Participants")
+	roles := make(map[uuid.UUID]model.RoomRole, len(t.Participants))
+	for _, participant := range t.Participants {
+		roles[participant.UserID] = participant.Role
+		field(pdf, tr, string(participant.Role), participant.UserID.String())
+	}
+	pdf.Ln(3)
+
+	section(pdf, fmt.Sprintf("Messages (%d)", len(t.Messages)))
+	for _, msg := range t.Messages {
+		pdf.SetFont("Helvetica", "B", 8)
+		header := fmt.Sprintf("%s  %s (%s)", msg.SentAt.Format(transcriptTimeLayout), msg.From, senderRole(roles, msg.From))
+		if msg.EditedAt != 0 {
+			header += "  edited " + time.UnixMilli(msg.EditedAt).UTC().Format(transcriptTimeLayout)
+		}
+		pdf.CellFormat(0, 5, tr(header), "", 1, "L", false, 0, "")
+
+		pdf.SetFont("Helvetica", "", 9)
+		switch {
+		case msg.Deleted:
+			pdf.SetFont("Helvetica", "I", 9)
+			pdf.MultiCell(0, 5, "Message deleted", "", "L", false)
+		case msg.Body != "":
+			pdf.MultiCell(0, 5, tr(msg.Body), "", "L", false)
+		}
+		if msg.Attachment != nil {
+			pdf.SetTextColor(20, 70, 160)
+			label := fmt.Sprintf("Attachment: %s (%s, %d bytes)", msg.Attachment.FileName, msg.Attachment.ContentType, msg.Attachment.Size)
+			pdf.CellFormat(0, 5, tr(label), "", 1, "L", false, 0, msg.Attachment.URL)
+			pdf.SetTextColor(0, 0, 0)
+		}
+		pdf.Ln(2)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("render transcript pdf: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func section(pdf *gofpdf.Fpdf, title string) {
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 7, title, "B", 1, "L", false, 0, "")
+	pdf.Ln(1)
+}
+
+func field(pdf *gofpdf.Fpdf, tr func(string) string, label, value string) {
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.CellFormat(35, 5, tr(label), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.MultiCell(0, 5, tr(value), "", "L", false)
+}
+
+func senderRole(roles map[uuid.UUID]model.RoomRole, userId uuid.UUID) string {
+	if role, ok := roles[userId]; ok {
+		return string(role)
+	}
+	return "former member"
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	transcriptPageSize           = 100
+	transcriptSignatureAlgorithm = "HMAC-SHA256"
+)
+
+var (
+	ErrTranscriptSigningKey  = errors.New("export.signing-key is not configured")
+	ErrInvalidTranscriptSign = errors.New("transcript signature does not match")
+)
+
+// TranscriptService exports the whole conversation of an order, signed so
+// that it can serve as a record in disputes.
+type TranscriptService struct {
+	log        *logrus.Logger
+	orderRepo  *repository.OrderRepo
+	chatSrv    *ChatService
+	msgStore   repository.MessageStore
+	attachSrv  *AttachmentService
+	signingKey []byte
+	linkTTL    time.Duration
+}
+
+func NewTranscriptService(
+	log *logrus.Logger,
+	v *viper.Viper,
+	orderRepo *repository.OrderRepo,
+	chatSrv *ChatService,
+	msgStore repository.MessageStore,
+	attachSrv *AttachmentService,
+) *TranscriptService {
+	// S3 does not presign for longer than seven days
+	v.SetDefault("export.attachment-url-ttl", 7*24*time.Hour)
+
+	return &TranscriptService{
+		log:        log,
+		orderRepo:  orderRepo,
+		chatSrv:    chatSrv,
+		msgStore:   msgStore,
+		attachSrv:  attachSrv,
+		signingKey: []byte(v.GetString("export.signing-key")),
+		linkTTL:    v.GetDuration("export.attachment-url-ttl"),
+	}
+}
+
+// Export builds and signs the transcript of the order's chat room for one of
+// its members.
+func (s *TranscriptService) Export(ctx context.Context, userId, orderId uuid.UUID) (*model.SignedTranscript, error) {
+	if len(s.signingKey) == 0 {
+		return nil, ErrTranscriptSigningKey
+	}
+	chatRoom, err := s.chatSrv.GetChatRoomByOrderId(ctx, userId, orderId)
+	if err != nil {
+		return nil, err
+	}
+	order, err := s.orderRepo.GetOrderById(orderId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.allMessages(ctx, chatRoom.ChatRoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachmentIds []uuid.UUID
+	for _, msg := range messages {
+		if msg.DeletedAt == 0 && msg.AttachmentID != nil {
+			attachmentIds = append(attachmentIds, *msg.AttachmentID)
+		}
+	}
+	attachments, err := s.attachSrv.TranscriptAttachments(ctx, attachmentIds, s.linkTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	transcript := &model.Transcript{
+		ChatRoomID: chatRoom.ChatRoomID,
+		Order: model.TranscriptOrder{
+			ID:            order.ID,
+			OrderNumber:   order.OrderNumber,
+			Status:        order.Status,
+			Price:         order.Price,
+			PaymentMethod: order.PaymentMethod,
+			PackageID:     order.PackageID,
+			BuyerID:       order.BuyerID,
+			SellerID:      order.SellerID,
+			Requirements:  order.Requirements,
+			CreatedAt:     order.CreatedAt.UTC(),
+			DueDate:       order.DueDate,
+			CompletedAt:   order.CompletedAt,
+		},
+		Participants: append([]model.RoomMember{
+			{ChatRoomID: chatRoom.ChatRoomID, UserID: chatRoom.ParticipantOne, Role: model.RoleBuyer, JoinedAt: chatRoom.CreatedAt.UTC()},
+			{ChatRoomID: chatRoom.ChatRoomID, UserID: chatRoom.ParticipantTwo, Role: model.RoleSeller, JoinedAt: chatRoom.CreatedAt.UTC()},
+		}, chatRoom.Members...),
+		Messages:   make([]model.TranscriptMessage, 0, len(messages)),
+		ExportedBy: userId,
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	for _, msg := range messages {
+		deleted := msg.DeletedAt != 0
+		msg.Redact()
+		entry := model.TranscriptMessage{
+			ID:        msg.ID,
+			From:      msg.From,
+			To:        msg.To,
+			SentAt:    time.UnixMilli(msg.Timestamp).UTC(),
+			Timestamp: msg.Timestamp,
+			Body:      msg.Body,
+			Status:    msg.Status,
+			EditedAt:  msg.EditedAt,
+			Deleted:   deleted,
+		}
+		if msg.AttachmentID != nil {
+			entry.Attachment = attachments[*msg.AttachmentID]
+		}
+		transcript.Messages = append(transcript.Messages, entry)
+	}
+
+	signature, err := s.sign(transcript)
+	if err != nil {
+		return nil, err
+	}
+	return &model.SignedTranscript{
+		Transcript: transcript,
+		Algorithm:  transcriptSignatureAlgorithm,
+		Signature:  signature,
+	}, nil
+}
+
+// Verify checks an exported transcript against its signature.
+func (s *TranscriptService) Verify(signed *model.SignedTranscript) error {
+	if len(s.signingKey) == 0 {
+		return ErrTranscriptSigningKey
+	}
+	expected, err := s.sign(signed.Transcript)
+	if err != nil {
+		return err
+	}
+	if signed.Algorithm != transcriptSignatureAlgorithm || !hmac.Equal([]byte(expected), []byte(signed.Signature)) {
+		return ErrInvalidTranscriptSign
+	}
+	return nil
+}
+
+// allMessages pages through the whole room history, oldest first.
+func (s *TranscriptService) allMessages(ctx context.Context, roomId uuid.UUID) ([]*model.Message, error) {
+	var messages []*model.Message
+	var before model.MessageCursor
+	for {
+		page, err := s.msgStore.ListByRoom(ctx, roomId, before, transcriptPageSize)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+		if len(page) < transcriptPageSize {
+			break
+		}
+		before = page[len(page)-1].Cursor()
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (s *TranscriptService) sign(transcript *model.Transcript) (string, error) {
+	data, err := json.Marshal(transcript)
+	if err != nil {
+		return "", fmt.Errorf("marshal transcript: %w", err)
+	}
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"time"
+)
+
+const (
+	unreadKeyPrefix      = "unread:"
+	unreadCountKeyPrefix = "unread_count:"
+	maxReviseAttempts    = 5
+)
+
+// ackUnreadScript drops the queued frames up to and including the acked
+// server timestamp and lowers the room counter by the same amount. The
+// counter is cleared once the backlog is empty, since by then the user has
+// caught up even on messages that were trimmed from a full backlog.
+var ackUnreadScript = redis.NewScript(`
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+local acked = 0
+for i, raw in ipairs(items) do
+	local ok, frame = pcall(cjson.decode, raw)
+	if ok and tonumber(frame.timestamp or 0) > tonumber(ARGV[1]) then
+		break
+	end
+	acked = i
+end
+if acked > 0 then
+	redis.call('LTRIM', KEYS[1], acked, -1)
+end
+local left = redis.call('HINCRBY', KEYS[2], ARGV[2], -acked)
+if left <= 0 or redis.call('LLEN', KEYS[1]) == 0 then
+	redis.call('HDEL', KEYS[2], ARGV[2])
+end
+return acked
+`)
+
+// UnreadService keeps the backlog of frames for users that were offline when
+// they were sent. Each unread:{roomId}:{userId} list is capped and expires,
+// and unread_count:{userId} holds the per-room count of unacked messages.
+type UnreadService struct {
+	log         *logrus.Logger
+	redis       *redis.Client
+	maxMessages int64
+	ttl         time.Duration
+}
+
+func NewUnreadService(log *logrus.Logger, v *viper.Viper, rdb *redis.Client) *UnreadService {
+	v.SetDefault("chat.unread.max-messages", 500)
+	v.SetDefault("chat.unread.ttl", 7*24*time.Hour)
+	return &UnreadService{
+		log:         log,
+		redis:       rdb,
+		maxMessages: v.GetInt64("chat.unread.max-messages"),
+		ttl:         v.GetDuration("chat.unread.ttl"),
+	}
+}
+
+// Push queues an encoded frame, keeping only the newest maxMessages.
+func (s *UnreadService) Push(ctx context.Context, roomId, userId uuid.UUID, frame []byte) error {
+	key := s.key(roomId, userId)
+	countKey := s.countKey(userId)
+
+	pipe := s.redis.TxPipeline()
+	pipe.RPush(ctx, key, frame)
+	pipe.LTrim(ctx, key, -s.maxMessages, -1)
+	pipe.Expire(ctx, key, s.ttl)
+	pipe.HIncrBy(ctx, countKey, roomId.String(), 1)
+	pipe.Expire(ctx, countKey, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("queue unread frame: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the queued frames, oldest first, without removing them.
+func (s *UnreadService) Pending(ctx context.Context, roomId, userId uuid.UUID) ([]string, error) {
+	frames, err := s.redis.LRange(ctx, s.key(roomId, userId), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("read unread frames: %w", err)
+	}
+	return frames, nil
+}
+
+// Ack removes the queued frames with a timestamp up to and including
+// timestamp and returns how many were removed.
+func (s *UnreadService) Ack(ctx context.Context, roomId, userId uuid.UUID, timestamp int64) (int64, error) {
+	acked, err := ackUnreadScript.Run(ctx, s.redis,
+		[]string{s.key(roomId, userId), s.countKey(userId)},
+		timestamp, roomId.String(),
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("ack unread frames: %w", err)
+	}
+	return acked, nil
+}
+
+// Revise rewrites the first queued frame that revise matches, so that
+// messages edited or deleted while the user was away are not replayed as
+// they were. revise returns the new frame, or nil to drop the frame. Revise
+// reports whether a frame matched.
+func (s *UnreadService) Revise(ctx context.Context, roomId, userId uuid.UUID, revise func(frame []byte) (replacement []byte, matched bool)) (bool, error) {
+	key := s.key(roomId, userId)
+	for attempt := 0; attempt < maxReviseAttempts; attempt++ {
+		matched := false
+		err := s.redis.Watch(ctx, func(tx *redis.Tx) error {
+			frames, err := tx.LRange(ctx, key, 0, -1).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			for i, frame := range frames {
+				replacement, ok := revise([]byte(frame))
+				if !ok {
+					continue
+				}
+				matched = true
+				_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					if replacement != nil {
+						pipe.LSet(ctx, key, int64(i), replacement)
+						return nil
+					}
+					pipe.LRem(ctx, key, 1, frame)
+					pipe.HIncrBy(ctx, s.countKey(userId), roomId.String(), -1)
+					return nil
+				})
+				return err
+			}
+			return nil
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			// the backlog changed underneath, look again
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("revise unread frame: %w", err)
+		}
+		return matched, nil
+	}
+	return false, fmt.Errorf("revise unread frame: %w", redis.TxFailedErr)
+}
+
+// Counts returns the number of unacked messages per room for the user.
+func (s *UnreadService) Counts(ctx context.Context, userId uuid.UUID) (map[uuid.UUID]int64, error) {
+	raw, err := s.redis.HGetAll(ctx, s.countKey(userId)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("read unread counts: %w", err)
+	}
+	counts := make(map[uuid.UUID]int64, len(raw))
+	for room, value := range raw {
+		roomId, err := uuid.Parse(room)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || count <= 0 {
+			continue
+		}
+		counts[roomId] = count
+	}
+	return counts, nil
+}
+
+func (s *UnreadService) key(roomId, userId uuid.UUID) string {
+	return fmt.Sprintf("%s%s:%s", unreadKeyPrefix, roomId.String(), userId.String())
+}
+
+func (s *UnreadService) countKey(userId uuid.UUID) string {
+	return unreadCountKeyPrefix + userId.String()
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/attachment"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/chat"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/moderation"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/notification"
+	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/review"
 	"io"
 	"os"
 	"path"
@@ -11,6 +15,7 @@ import (
 	"time"
 
 	"github.com/SwanHtetAungPhyo/chat-order/cmd"
+	"github.com/SwanHtetAungPhyo/chat-order/cmd/middleware"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/placeOrder"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/handler/ws"
 	"github.com/SwanHtetAungPhyo/chat-order/internal/model"
@@ -41,10 +46,15 @@ func main() {
 		placeOrder.OrdHandlerModule,
 		fx.Provide(
 			chat.NewChatRestHanlder,
+			attachment.NewAttachmentHandler,
+			moderation.NewModerationHandler,
+			review.NewReviewHandler,
+			notification.NewNotificationHandler,
 		),
 		cmd.AppStateModule,
 		fx.Invoke(
 			//StartMigration,
+			middleware.InitJWKS,
 			cmd.RegisterLifeCycle,
 		),
 	)
@@ -130,13 +140,19 @@ func DynamoDb(cfg *aws.Config) *dynamodb.Client {
 	return dynamodb.NewFromConfig(*cfg)
 }
 
-func NewFiberApp() *fiber.App {
+func NewFiberApp(v *viper.Viper) *fiber.App {
+	// leave room for the multipart envelope around the largest attachment
+	bodyLimit := 4 << 20
+	if maxAttachment := v.GetInt("attachments.max-size"); maxAttachment+(1<<20) > bodyLimit {
+		bodyLimit = maxAttachment + (1 << 20)
+	}
 	return fiber.New(fiber.Config{
 		DisableStartupMessage: false,
 		Prefork:               false,
 		StrictRouting:         false,
 		CaseSensitive:         true,
 		AppName:               "fiber",
+		BodyLimit:             bodyLimit,
 	})
 }
 
@@ -154,8 +170,15 @@ func RedisClient(v *viper.Viper) *redis.Client {
 	return client
 }
 
-func NewS3Client(cfg *aws.Config) *s3.Client {
-	return s3.NewFromConfig(*cfg)
+// NewS3Client talks to AWS S3, or to an S3 compatible store such as MinIO
+// when aws.s3.endpoint is set.
+func NewS3Client(cfg *aws.Config, v *viper.Viper) *s3.Client {
+	return s3.NewFromConfig(*cfg, func(o *s3.Options) {
+		if endpoint := v.GetString("aws.s3.endpoint"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
 }
 
 func StartMigration(db *gorm.DB, log *logrus.Logger) {
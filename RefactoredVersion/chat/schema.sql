@@ -303,7 +303,18 @@ create table "Order"
     created_at     timestamp with time zone,
     updated_at     timestamp with time zone,
     completed_at   timestamp with time zone,
-    due_date       timestamp with time zone
+    due_date       timestamp with time zone,
+    accepted_at       timestamp with time zone,
+    delivered_at      timestamp with time zone,
+    cancelled_at      timestamp with time zone,
+    status_changed_by uuid,
+    status_changed_at timestamp with time zone,
+    package_snapshot  jsonb,
+    revisions_used    bigint default 0 not null,
+    is_late           boolean default false not null,
+    late_at           timestamp with time zone,
+    due_reminded_at   timestamp with time zone,
+    auto_completed    boolean default false not null
 );
 
 alter table "Order"
@@ -312,6 +323,197 @@ alter table "Order"
 create unique index "idx_Order_order_number"
     on "Order" (order_number);
 
+create index "idx_Order_status_due_date"
+    on "Order" (status, due_date);
+
+create table "OrderEvent"
+(
+    id          uuid default gen_random_uuid() not null
+        primary key,
+    order_id    uuid                           not null
+        constraint "fk_Order_events"
+            references "Order",
+    actor_id    uuid,
+    type        text                           not null,
+    from_status text,
+    to_status   text,
+    diff        jsonb,
+    note        text,
+    created_at  timestamp with time zone
+);
+
+alter table "OrderEvent"
+    owner to postgres;
+
+create index "idx_OrderEvent_order_id"
+    on "OrderEvent" (order_id, created_at);
+
+create table "OrderDelivery"
+(
+    id             uuid default gen_random_uuid() not null
+        primary key,
+    order_id       uuid                           not null
+        constraint "fk_Order_deliveries"
+            references "Order",
+    number         bigint                         not null,
+    seller_id      uuid                           not null,
+    message        text,
+    attachment_ids jsonb,
+    response       text default 'pending'::text   not null,
+    response_note  text,
+    responded_at   timestamp with time zone,
+    created_at     timestamp with time zone
+);
+
+alter table "OrderDelivery"
+    owner to postgres;
+
+create unique index "idx_OrderDelivery_order_number"
+    on "OrderDelivery" (order_id, number);
+
+create table "OrderIdempotencyKey"
+(
+    buyer_id     uuid                     not null,
+    key          text                     not null,
+    request_hash text                     not null,
+    order_id     uuid                     not null
+        constraint "fk_Order_idempotency_keys"
+            references "Order",
+    chat_room_id uuid                     not null,
+    created_at   timestamp with time zone,
+    expires_at   timestamp with time zone not null,
+    primary key (buyer_id, key)
+);
+
+alter table "OrderIdempotencyKey"
+    owner to postgres;
+
+create index "idx_OrderIdempotencyKey_expires_at"
+    on "OrderIdempotencyKey" (expires_at);
+
+-- "Payment" replaces the Payments table of the monolith schema (schema.sql in
+-- the repository root). That table only records a payment method and a
+-- transaction id per order, which "Order" keeps in its own payment_method and
+-- transaction_id, and has no amount, currency, parties or status to hold an
+-- escrow by. Its rows are carried over once onto the orders with:
+--
+-- update "Order" o
+-- set payment_method = p.payment_method, transaction_id = p.transaction_id
+-- from payments p
+-- where p.order_id = o.id;
+--
+-- Those orders get no "Payment": they were paid before escrow existed, and
+-- settling leaves orders without a payment alone.
+create table "Payment"
+(
+    id         uuid default gen_random_uuid() not null
+        primary key,
+    order_id   uuid                           not null
+        constraint "fk_Order_payment"
+            references "Order",
+    buyer_id   uuid                           not null,
+    seller_id  uuid                           not null,
+    provider   text                           not null,
+    reference  text                           not null,
+    currency   text                           not null,
+    amount     bigint                         not null,
+    fee        bigint                         not null,
+    refund_amount bigint default 0            not null,
+    status     text default 'held'::text      not null,
+    created_at timestamp with time zone,
+    settled_at timestamp with time zone
+);
+
+alter table "Payment"
+    owner to postgres;
+
+create unique index "idx_Payment_order_id"
+    on "Payment" (order_id);
+
+create index "idx_Payment_status"
+    on "Payment" (status, created_at);
+
+create table "LedgerEntry"
+(
+    id         uuid default gen_random_uuid() not null
+        primary key,
+    payment_id uuid                           not null
+        constraint "fk_Payment_entries"
+            references "Payment",
+    posting_id uuid                           not null,
+    posting    text                           not null,
+    account    text                           not null,
+    owner_id   uuid,
+    amount     bigint                         not null,
+    currency   text                           not null,
+    created_at timestamp with time zone
+);
+
+alter table "LedgerEntry"
+    owner to postgres;
+
+create index "idx_LedgerEntry_payment_id"
+    on "LedgerEntry" (payment_id);
+
+create index "idx_LedgerEntry_account_owner"
+    on "LedgerEntry" (account, owner_id);
+
+create table "OrderDispute"
+(
+    id             uuid default gen_random_uuid() not null
+        primary key,
+    order_id       uuid                           not null
+        constraint "fk_Order_disputes"
+            references "Order",
+    opened_by      uuid                           not null,
+    opened_by_role text                           not null,
+    reason         text                           not null,
+    status         text default 'open'::text      not null,
+    moderator_id   uuid,
+    assigned_at    timestamp with time zone,
+    ruling         text,
+    refund_amount  bigint default 0               not null,
+    ruling_note    text,
+    resolved_at    timestamp with time zone,
+    created_at     timestamp with time zone
+);
+
+alter table "OrderDispute"
+    owner to postgres;
+
+create index "idx_OrderDispute_order_id"
+    on "OrderDispute" (order_id);
+
+create unique index "idx_OrderDispute_open_order"
+    on "OrderDispute" (order_id)
+    where status = 'open';
+
+create index "idx_OrderDispute_status"
+    on "OrderDispute" (status, moderator_id, created_at);
+
+create table "DisputeEvidence"
+(
+    id                uuid default gen_random_uuid() not null
+        primary key,
+    dispute_id        uuid                           not null
+        constraint "fk_OrderDispute_evidence"
+            references "OrderDispute",
+    submitted_by      uuid                           not null,
+    message_id        text                           not null,
+    message_timestamp bigint                         not null,
+    message_from      uuid                           not null,
+    body              text,
+    attachment_id     uuid,
+    note              text,
+    created_at        timestamp with time zone
+);
+
+alter table "DisputeEvidence"
+    owner to postgres;
+
+create index "idx_DisputeEvidence_dispute_id"
+    on "DisputeEvidence" (dispute_id);
+
 create table "Review"
 (
     id          uuid    default gen_random_uuid() not null
@@ -329,7 +531,13 @@ create table "Review"
             references "Order"
             on update cascade on delete restrict,
     "createdAt" timestamp with time zone,
-    "updatedAt" timestamp with time zone
+    "updatedAt" timestamp with time zone,
+    "gigId"       uuid                              not null
+        constraint "fk_Review_gig"
+            references "Gig",
+    "sellerId"    uuid                              not null,
+    "sellerReply" text,
+    "repliedAt"   timestamp with time zone
 );
 
 alter table "Review"
@@ -338,6 +546,148 @@ alter table "Review"
 create unique index "idx_Review_order_id"
     on "Review" ("orderId");
 
+create index "idx_Review_gig_id"
+    on "Review" ("gigId", "createdAt");
+
+create index "idx_Review_seller_id"
+    on "Review" ("sellerId", "createdAt");
+
 create index "idx_Review_author_id"
     on "Review" ("authorId");
 
+
+-- "Chat" replaces the chat table of the monolith schema (schema.sql in the
+-- repository root), which can not hold these messages as they are: its
+-- message_id is a uuid while clients send any text id, its user_id is a
+-- required recipient while room broadcasts have none, and its timestamp is a
+-- timestamptz while the stores key and page messages by unix millis. Rows of
+-- an existing chat table are carried over once with:
+--
+-- insert into "Chat" (message_id, chat_room_id, sender_id, recipient_id, time_stamp, body, status)
+-- select message_id::text, chat_room_id, sender_id, user_id,
+--        (extract(epoch from timestamp) * 1000)::bigint, message, 'read'
+-- from chat
+-- on conflict do nothing;
+create table "Chat"
+(
+    message_id    text                        not null,
+    chat_room_id  uuid                        not null,
+    sender_id     uuid                        not null,
+    recipient_id  uuid,
+    time_stamp    bigint                      not null,
+    body          text                        not null,
+    image_url     text,
+    attachment_id uuid,
+    status        text default 'sent'::text   not null,
+    edited_at     bigint,
+    deleted_at    bigint,
+    edits         jsonb,
+    reactions     jsonb,
+    version       bigint default 0            not null,
+    primary key (chat_room_id, message_id)
+);
+
+alter table "Chat"
+    owner to postgres;
+
+create index idx_chat_room_time
+    on "Chat" (chat_room_id, time_stamp);
+
+create table chat_attachment
+(
+    id           uuid default gen_random_uuid() not null
+        primary key,
+    chat_room_id uuid                           not null,
+    owner_id     uuid                           not null,
+    file_name    text                           not null,
+    content_type text                           not null,
+    size         bigint                         not null,
+    object_key   text                           not null,
+    status       text default 'pending'::text   not null,
+    created_at   timestamp with time zone
+);
+
+alter table chat_attachment
+    owner to postgres;
+
+create index idx_chat_attachment_chat_room_id
+    on chat_attachment (chat_room_id);
+
+create table chat_search
+(
+    chat_room_id uuid   not null,
+    message_id   text   not null,
+    sender_id    uuid   not null,
+    time_stamp   bigint not null,
+    body         text   not null,
+    document     tsvector generated always as (to_tsvector('english', body)) stored,
+    primary key (chat_room_id, message_id)
+);
+
+alter table chat_search
+    owner to postgres;
+
+create index idx_chat_search_document
+    on chat_search using gin (document);
+
+create table chat_room_member
+(
+    chat_room_id uuid not null,
+    user_id      uuid not null,
+    role         text not null,
+    added_by     uuid,
+    joined_at    timestamp with time zone,
+    primary key (chat_room_id, user_id)
+);
+
+alter table chat_room_member
+    owner to postgres;
+
+create index idx_chat_room_member_user_id
+    on chat_room_member (user_id);
+
+create table chat_moderation_flag
+(
+    id           uuid default gen_random_uuid()  not null
+        primary key,
+    chat_room_id uuid                            not null,
+    message_id   text                            not null,
+    sender_id    uuid                            not null,
+    rule         text                            not null,
+    action       text                            not null,
+    body         text                            not null,
+    matched      text,
+    status       text default 'pending'::text    not null,
+    reviewed_by  uuid,
+    reviewed_at  timestamp with time zone,
+    note         text,
+    created_at   timestamp with time zone
+);
+
+alter table chat_moderation_flag
+    owner to postgres;
+
+create index idx_chat_moderation_flag_status
+    on chat_moderation_flag (status, created_at);
+
+create table "Notification"
+(
+    id         bigserial                      not null
+        primary key,
+    user_id    uuid                           not null,
+    type       text                           not null,
+    order_id   uuid,
+    payload    jsonb                          not null,
+    read_at    timestamp with time zone,
+    created_at timestamp with time zone
+);
+
+alter table "Notification"
+    owner to postgres;
+
+create index "idx_Notification_user_id"
+    on "Notification" (user_id, id);
+
+create index "idx_Notification_user_unread"
+    on "Notification" (user_id)
+    where (read_at IS NULL);